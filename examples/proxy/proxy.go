@@ -1,7 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"google.golang.org/grpc/credentials/insecure"
 
 	server "github.com/TylerJGabb/grpc-http-proxy/examples/server/impl"
@@ -9,15 +18,115 @@ import (
 )
 
 func main() {
-	exampleServer := server.ExampleServer{}
-	addr := exampleServer.Start(9091)
-
 	port := flag.Int("port", 8080, "the port number")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+	tlsCert := flag.String("tls-cert", "", "path to a certificate to serve HTTPS and WSS with, requires -tls-key")
+	tlsKey := flag.String("tls-key", "", "path to the private key of -tls-cert")
+	enableH2C := flag.Bool("h2c", false, "accept HTTP/2 without TLS")
+	unixSocket := flag.String("unix-socket", "", "listen on a unix domain socket at this path instead of the port")
+	configPath := flag.String("config", "", "path to a json config file")
+	logLevel := flag.String("log-level", "info", "the minimum level logged, one of debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "the format of log events, json or text")
+	adminAddr := flag.String("admin-addr", "", "serve the unauthenticated admin api at this host:port, e.g. localhost:9090")
+	capturePath := flag.String("capture", "", "record every call to this jsonl file, see proxy.WithCapture")
+	replayPath := flag.String("replay", "", "answer calls from this capture file instead of starting the example server")
+	mockDir := flag.String("mock", "", "answer calls from the fixtures in this directory instead of starting the example server")
 	flag.Parse()
-	hps := proxy.NewHttpProxyServer(
-		addr,
+
+	logger, err := proxy.NewLogger(proxy.LoggingConfig{Level: *logLevel, Format: *logFormat}, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logging flags: %v\n", err)
+		os.Exit(1)
+	}
+	fatal := func(msg string, err error) {
+		logger.Error(msg, slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	opts := []proxy.OptFunc{
 		proxy.WithPort(*port),
 		proxy.WithGrpcTransportCredentials(insecure.NewCredentials()),
-	)
-	hps.RunBlocking()
+		proxy.WithLogger(logger),
+	}
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			fatal("loading tls certificate failed", err)
+		}
+		opts = append(opts, proxy.WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	}
+	if *enableH2C {
+		opts = append(opts, proxy.WithH2C())
+	}
+	if *unixSocket != "" {
+		opts = append(opts, proxy.WithUnixSocket(*unixSocket))
+	}
+	if *adminAddr != "" {
+		opts = append(opts, proxy.WithAdmin(*adminAddr))
+	}
+	if *capturePath != "" {
+		recorder, err := proxy.NewCaptureRecorder(proxy.CaptureConfig{File: *capturePath})
+		if err != nil {
+			fatal("opening capture file failed", err)
+		}
+		opts = append(opts, proxy.WithCapture(recorder))
+	}
+	// the example server is only needed when calls are neither replayed nor mocked
+	addr := "stub"
+	if *replayPath != "" {
+		r, err := proxy.NewReplayServer(proxy.ReplayConfig{Files: []string{*replayPath}})
+		if err != nil {
+			fatal("loading replay failed", err)
+		}
+		opts = append(opts, proxy.WithReplay(r))
+	} else if *mockDir != "" {
+		m, err := proxy.NewMockServer(proxy.MockConfig{Fixtures: []string{*mockDir}})
+		if err != nil {
+			fatal("loading mock fixtures failed", err)
+		}
+		opts = append(opts, proxy.WithMock(m))
+	} else {
+		exampleServer := server.ExampleServer{}
+		addr = exampleServer.Start(9091)
+	}
+	if *configPath != "" {
+		cfg, err := proxy.LoadConfig(*configPath)
+		if err != nil {
+			fatal("loading config failed", err)
+		}
+		configOpts, err := cfg.Options()
+		if err != nil {
+			fatal("applying config failed", err)
+		}
+		opts = append(opts, configOpts...)
+	}
+	hps := proxy.NewHttpProxyServer(addr, opts...)
+	if err := proxy.RegisterTylerSandboxRoutes(hps); err != nil {
+		fatal("registering routes failed", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- hps.RunBlocking()
+	}()
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			fatal("proxy server stopped", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down proxy server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := hps.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shutting down proxy server failed", slog.Any("error", err))
+	}
+	<-runErr
 }
@@ -0,0 +1,230 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/redact"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/rotate"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+)
+
+// timeLayout is the timestamp of the common log format
+const timeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Config describes the format of the access log and where it is written
+type Config struct {
+	// Format is common, combined or json, combined by default
+	Format string `json:"format"`
+	// File is the path of the access log, it is rotated like the audit log. Empty writes
+	// to the writer the log is created with
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"maxSizeMB"`
+	MaxBackups int    `json:"maxBackups"`
+}
+
+// Entry is a single request
+type Entry struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remoteIp"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	Route     string    `json:"route,omitempty"`
+	// DurationMs is how long the request was handled, for streams how long they were open
+	DurationMs float64 `json:"durationMs"`
+	Stream     *Stream `json:"stream,omitempty"`
+}
+
+// Stream describes a stream that was opened, once it has ended
+type Stream struct {
+	Transport string `json:"transport"`
+	// Initiator is the side that ended the stream: client, server or proxy
+	Initiator string `json:"initiator"`
+	// CloseCode is the websocket close code, 0 for server-sent events
+	CloseCode int `json:"closeCode,omitempty"`
+	// Code is the grpc code the stream ended with, e.g. OK or CANCELLED
+	Code        string `json:"code"`
+	MessagesIn  int64  `json:"messagesIn"`
+	MessagesOut int64  `json:"messagesOut"`
+	BytesIn     int64  `json:"bytesIn"`
+	BytesOut    int64  `json:"bytesOut"`
+}
+
+// Logger writes an entry per request, it is safe for concurrent use
+type Logger struct {
+	format string
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// New returns the access log of cfg, written to w unless cfg names a file
+func New(cfg Config, w io.Writer) (*Logger, error) {
+	format := strings.ToLower(cfg.Format)
+	switch format {
+	case "":
+		format = FormatCombined
+	case FormatCommon, FormatCombined, FormatJSON:
+	default:
+		return nil, fmt.Errorf("access log format %q: must be common, combined or json", cfg.Format)
+	}
+	l := &Logger{format: format, w: w}
+	if cfg.File != "" {
+		file, err := rotate.Config{File: cfg.File, MaxSizeMB: cfg.MaxSizeMB, MaxBackups: cfg.MaxBackups}.Open()
+		if err != nil {
+			return nil, fmt.Errorf("opening access log: %w", err)
+		}
+		l.w, l.closer = file, file
+	}
+	return l, nil
+}
+
+// Close closes the file of the log, if it has one
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Log writes e as a single line
+func (l *Logger) Log(e Entry) error {
+	var line []byte
+	if l.format == FormatJSON {
+		var err error
+		if line, err = json.Marshal(e); err != nil {
+			return err
+		}
+	} else {
+		line = []byte(l.formatLine(e))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(append(line, '\n'))
+	return err
+}
+
+// formatLine writes e in the common or combined log format. Streams are followed by
+// their details as key=value pairs
+func (l *Logger) formatLine(e Entry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s [%s] %s %d %s",
+		e.RemoteIP,
+		orDash(e.User),
+		e.Time.Format(timeLayout),
+		quote(e.Method+" "+e.URI+" "+e.Protocol),
+		e.Status,
+		bytesOrDash(e.Bytes),
+	)
+	if l.format == FormatCombined {
+		fmt.Fprintf(&b, " %s %s", quote(orDash(e.Referer)), quote(orDash(e.UserAgent)))
+	}
+	if s := e.Stream; s != nil {
+		fmt.Fprintf(&b, " duration_ms=%s transport=%s initiator=%s code=%s messages_in=%d messages_out=%d bytes_in=%d bytes_out=%d",
+			strconv.FormatFloat(e.DurationMs, 'f', 3, 64),
+			s.Transport, s.Initiator, s.Code, s.MessagesIn, s.MessagesOut, s.BytesIn, s.BytesOut,
+		)
+		if s.CloseCode != 0 {
+			fmt.Fprintf(&b, " close_code=%d", s.CloseCode)
+		}
+	}
+	return b.String()
+}
+
+// Middleware logs every request once it has been handled, which for streams is once they
+// have ended. Streams that were refused before they were opened are logged like any other
+// request, with the status they were refused with. The values of the redactQuery parameters,
+// e.g. those credentials are accepted in, are replaced with redact.Redacted
+func (l *Logger) Middleware(redactQuery ...string) gin.HandlerFunc {
+	query := redact.New(redactQuery)
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		e := Entry{
+			Time:       start,
+			RemoteIP:   c.ClientIP(),
+			Method:     c.Request.Method,
+			URI:        query.URI(c.Request.URL),
+			Protocol:   c.Request.Proto,
+			Status:     c.Writer.Status(),
+			Referer:    c.Request.Referer(),
+			UserAgent:  c.Request.UserAgent(),
+			RequestID:  c.Writer.Header().Get(logging.RequestIDHeader),
+			Route:      c.FullPath(),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if size := c.Writer.Size(); size > 0 {
+			e.Bytes = int64(size)
+		}
+		if identity, ok := auth.FromContext(c.Request.Context()); ok {
+			e.User = identity.Subject
+		}
+		if rejected, ok := serverstream.RejectedStatus(c); ok {
+			e.Status = rejected
+		}
+		if summary, ok := serverstream.StreamSummary(c); ok {
+			e.Stream = &Stream{
+				Transport:   summary.Transport,
+				Initiator:   summary.Initiator,
+				CloseCode:   summary.CloseCode,
+				Code:        grpcerr.CodeName(status.Code(summary.Err)),
+				MessagesIn:  summary.MessagesIn,
+				MessagesOut: summary.MessagesOut,
+				BytesIn:     summary.BytesIn,
+				BytesOut:    summary.BytesOut,
+			}
+			e.DurationMs = float64(summary.Duration.Microseconds()) / 1000
+			if summary.Transport == "websocket" {
+				// the connection was hijacked, so the response writer knows neither
+				e.Status, e.Bytes = http.StatusSwitchingProtocols, summary.BytesOut
+			}
+		}
+		if err := l.Log(e); err != nil {
+			logging.FromContext(c.Request.Context()).Error("writing access log failed", slog.Any("error", err))
+		}
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func bytesOrDash(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// quote wraps s in double quotes, escaping the quotes and backslashes in it
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
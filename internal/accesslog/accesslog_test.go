@@ -0,0 +1,88 @@
+package accesslog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/accesslog"
+)
+
+func TestLog(t *testing.T) {
+	entry := accesslog.Entry{
+		Time:      time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		RemoteIP:  "127.0.0.1",
+		User:      "frank",
+		Method:    "GET",
+		URI:       "/stream?value=3",
+		Protocol:  "HTTP/1.1",
+		Status:    101,
+		Bytes:     42,
+		UserAgent: `agent "quoted"`,
+		Stream: &accesslog.Stream{
+			Transport: "websocket", Initiator: "client", CloseCode: 1000, Code: "CANCELLED",
+			MessagesIn: 1, MessagesOut: 3, BytesIn: 2, BytesOut: 42,
+		},
+		DurationMs: 1500,
+	}
+	log := func(t *testing.T, format string) string {
+		var out bytes.Buffer
+		l, err := accesslog.New(accesslog.Config{Format: format}, &out)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		if err := l.Log(entry); err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		return out.String()
+	}
+
+	t.Run("common", func(t *testing.T) {
+		expected := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /stream?value=3 HTTP/1.1" 101 42` +
+			` duration_ms=1500.000 transport=websocket initiator=client code=CANCELLED messages_in=1 messages_out=3 bytes_in=2 bytes_out=42 close_code=1000` + "\n"
+		if line := log(t, "common"); line != expected {
+			t.Fatalf("expected\n%s\ngot\n%s\n", expected, line)
+		}
+	})
+
+	t.Run("combined", func(t *testing.T) {
+		expected := `[10/Oct/2000:13:55:36 -0700] "GET /stream?value=3 HTTP/1.1" 101 42 "-" "agent \"quoted\"" duration_ms=`
+		if line := log(t, ""); !strings.Contains(line, expected) {
+			t.Fatalf("expected %s in\n%s\n", expected, line)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var decoded accesslog.Entry
+		if err := json.Unmarshal([]byte(log(t, "json")), &decoded); err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		if decoded.Stream == nil || *decoded.Stream != *entry.Stream {
+			t.Fatalf("expected stream %+v, got %+v\n", entry.Stream, decoded.Stream)
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		if _, err := accesslog.New(accesslog.Config{Format: "apache"}, nil); err == nil {
+			t.Fatalf("expected an error\n")
+		}
+	})
+
+	t.Run("writes to a file", func(t *testing.T) {
+		l, err := accesslog.New(accesslog.Config{File: filepath.Join(t.TempDir(), "access.log")}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		if err := l.Log(accesslog.Entry{Method: "GET"}); err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatalf("unexpected error closing: %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/redact"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/rotate"
+	"google.golang.org/protobuf/proto"
+)
+
+// Redacted replaces the values of redacted fields
+const Redacted = redact.Redacted
+
+// Config describes where audit events are written and which calls are audited
+type Config struct {
+	// File is the path of the audit log, it is rotated once it grows past MaxSizeMB
+	// and MaxBackups rotated files are kept next to it as File.1, File.2 and so on
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"maxSizeMB"`
+	MaxBackups int    `json:"maxBackups"`
+	// Methods are globs of the fully qualified methods to audit, see glob.Pattern.
+	// Empty audits every method
+	Methods []string `json:"methods"`
+	// Redact names request fields whose values are replaced with Redacted wherever they
+	// occur in the payload. Names match both the json and the proto name of a field
+	Redact []string `json:"redact"`
+	// OmitRequest leaves the request payload out of events altogether
+	OmitRequest bool `json:"omitRequest"`
+	// SkipSync leaves flushing events to disk to the operating system. By default every
+	// event is synced once written, so that it survives a crash of the host
+	SkipSync bool `json:"skipSync"`
+}
+
+// Event is a single audited call, written as one json line
+type Event struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Stream   bool      `json:"stream,omitempty"`
+	Subject  string    `json:"subject,omitempty"`
+	Scheme   string    `json:"scheme,omitempty"`
+	RemoteIP string    `json:"remoteIp,omitempty"`
+	// Code is the grpc code the call ended with, e.g. OK or PERMISSION_DENIED
+	Code      string  `json:"code"`
+	Message   string  `json:"message,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+	// Request is the redacted request payload as json
+	Request json.RawMessage `json:"request,omitempty"`
+}
+
+// Logger writes audit events to a rotating file, it is safe for concurrent use
+type Logger struct {
+	methods     []glob.Pattern
+	redact      redact.Fields
+	omitRequest bool
+	sync        bool
+	file        *rotate.File
+}
+
+// New opens the audit log of cfg
+func New(cfg Config) (*Logger, error) {
+	if cfg.File == "" {
+		return nil, errors.New("audit log needs a file")
+	}
+	methods, err := glob.CompileAll(cfg.Methods)
+	if err != nil {
+		return nil, fmt.Errorf("audit methods: %w", err)
+	}
+	file, err := rotate.Config{File: cfg.File, MaxSizeMB: cfg.MaxSizeMB, MaxBackups: cfg.MaxBackups}.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &Logger{methods: methods, redact: redact.New(cfg.Redact), omitRequest: cfg.OmitRequest, sync: !cfg.SkipSync, file: file}, nil
+}
+
+// Audits reports whether calls of the fully qualified method are audited
+func (l *Logger) Audits(fullMethodName string) bool {
+	return len(l.methods) == 0 || glob.MatchAny(l.methods, fullMethodName)
+}
+
+// Record writes ev with request as its redacted payload. request may be nil when the
+// call failed before its request could be decoded
+func (l *Logger) Record(ev Event, request proto.Message) error {
+	if request != nil && !l.omitRequest {
+		payload, err := l.redact.Message(request)
+		if err != nil {
+			return fmt.Errorf("redacting request: %w", err)
+		}
+		ev.Request = payload
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if l.sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+func (l *Logger) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,128 @@
+package audit_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/audit"
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func readEvents(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening audit log: %v\n", err)
+	}
+	defer f.Close()
+	var events []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("audit line %q is not json: %v\n", scanner.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("audits only the configured methods", func(t *testing.T) {
+		l, err := audit.New(audit.Config{
+			File:    filepath.Join(t.TempDir(), "audit.log"),
+			Methods: []string{"/pkg.Service/Update*", "/admin.**"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		defer l.Close()
+		for method, expected := range map[string]bool{
+			"/pkg.Service/UpdateUser": true,
+			"/pkg.Service/GetUser":    false,
+			"/admin.Service/Reset":    true,
+		} {
+			if got := l.Audits(method); got != expected {
+				t.Fatalf("expected Audits(%s) to be %v\n", method, expected)
+			}
+		}
+	})
+
+	t.Run("writes one json line per event with the request redacted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		l, err := audit.New(audit.Config{File: path, Redact: []string{"send_period_seconds", "password"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		request := &tgsbpb.ServerStreamStringRequest{Value: "hello", SendPeriodSeconds: 3}
+		err = l.Record(audit.Event{
+			Time:      time.Now(),
+			Method:    "/pkg.Service/Method",
+			Subject:   "alice",
+			Scheme:    "jwt",
+			Code:      "OK",
+			LatencyMs: 12.5,
+		}, request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		nested, _ := structpb.NewStruct(map[string]any{
+			"user": map[string]any{"name": "bob", "password": "hunter2"},
+			"list": []any{map[string]any{"password": "x"}},
+		})
+		if err := l.Record(audit.Event{Method: "/pkg.Service/Other", Code: "PERMISSION_DENIED"}, nested); err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		if err := l.Record(audit.Event{Method: "/pkg.Service/Other", Code: "INVALID_ARGUMENT"}, nil); err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		l.Close()
+
+		events := readEvents(t, path)
+		if len(events) != 3 {
+			t.Fatalf("expected 3 events, got %d\n", len(events))
+		}
+		first := events[0]
+		if first["subject"] != "alice" || first["code"] != "OK" || first["latencyMs"] != 12.5 {
+			t.Fatalf("unexpected event: %v\n", first)
+		}
+		req := first["request"].(map[string]any)
+		if req["value"] != "hello" || req["sendPeriodSeconds"] != audit.Redacted {
+			t.Fatalf("expected only sendPeriodSeconds to be redacted, got %v\n", req)
+		}
+		second := events[1]["request"].(map[string]any)
+		if second["user"].(map[string]any)["password"] != audit.Redacted {
+			t.Fatalf("expected nested password to be redacted, got %v\n", second)
+		}
+		if second["list"].([]any)[0].(map[string]any)["password"] != audit.Redacted {
+			t.Fatalf("expected password in list to be redacted, got %v\n", second)
+		}
+		if _, ok := events[2]["request"]; ok {
+			t.Fatalf("expected no request without a decoded request, got %v\n", events[2])
+		}
+	})
+
+	t.Run("omits the request when configured", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		l, err := audit.New(audit.Config{File: path, OmitRequest: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		l.Record(audit.Event{Method: "/pkg.Service/Method", Code: "OK"}, &tgsbpb.UnaryCallStringRequest{Value: "secret"})
+		l.Close()
+		if _, ok := readEvents(t, path)[0]["request"]; ok {
+			t.Fatalf("expected the request to be omitted\n")
+		}
+	})
+
+	t.Run("requires a file", func(t *testing.T) {
+		if _, err := audit.New(audit.Config{}); err == nil {
+			t.Fatalf("expected an error without a file\n")
+		}
+	})
+}
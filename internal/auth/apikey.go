@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/ratelimit"
+)
+
+// APIKey is a key as stored in config, only the hash of the key itself is ever stored
+type APIKey struct {
+	// Name identifies the key holder, it becomes the subject of the identity
+	Name string `json:"name"`
+	// Hash is the hex encoded SHA-256 of the key, see HashAPIKey
+	Hash string `json:"hash"`
+	// Methods are glob patterns of the fully qualified methods the key may invoke,
+	// e.g. /pkg.Service/*. An empty list allows every method
+	Methods []string `json:"methods"`
+	// RateLimit, if set, limits the calls made with the key
+	RateLimit *ratelimit.Limit `json:"rateLimit"`
+}
+
+// APIKeyConfig describes where API keys are read from requests and which keys are valid
+type APIKeyConfig struct {
+	// Header carries the key. Defaults to X-API-Key
+	Header string `json:"header"`
+	// QueryParam carries the key when the header is absent. Defaults to api_key
+	QueryParam string `json:"queryParam"`
+	// File is a json file holding a list of keys, in addition to Keys
+	File string   `json:"file"`
+	Keys []APIKey `json:"keys"`
+}
+
+// HashAPIKey returns the hash to store for key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type apiKeyEntry struct {
+	name    string
+	methods []glob.Pattern
+	limiter *ratelimit.Bucket
+}
+
+// APIKeyAuthenticator authenticates requests carrying one of the configured API keys
+type APIKeyAuthenticator struct {
+	header     string
+	queryParam string
+	// keys are indexed by the hash of the key
+	keys map[string]*apiKeyEntry
+}
+
+// QueryParam is the query parameter requests may carry the key in
+func (a *APIKeyAuthenticator) QueryParam() string {
+	return a.queryParam
+}
+
+func NewAPIKeyAuthenticator(cfg APIKeyConfig) (*APIKeyAuthenticator, error) {
+	keys := cfg.Keys
+	if cfg.File != "" {
+		b, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("reading api keys: %w", err)
+		}
+		var fileKeys []APIKey
+		if err := json.Unmarshal(b, &fileKeys); err != nil {
+			return nil, fmt.Errorf("reading api keys: %w", err)
+		}
+		keys = append(append([]APIKey{}, keys...), fileKeys...)
+	}
+
+	a := &APIKeyAuthenticator{
+		header:     cfg.Header,
+		queryParam: cfg.QueryParam,
+		keys:       map[string]*apiKeyEntry{},
+	}
+	if a.header == "" {
+		a.header = "X-API-Key"
+	}
+	if a.queryParam == "" {
+		a.queryParam = "api_key"
+	}
+	for _, key := range keys {
+		hash := strings.ToLower(key.Hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("api key %q: hash must be a hex encoded SHA-256", key.Name)
+		}
+		methods, err := glob.CompileAll(key.Methods)
+		if err != nil {
+			return nil, fmt.Errorf("api key %q: %w", key.Name, err)
+		}
+		entry := &apiKeyEntry{name: key.Name, methods: methods}
+		if key.RateLimit != nil {
+			entry.limiter = ratelimit.NewBucket(*key.RateLimit)
+		}
+		a.keys[hash] = entry
+	}
+	if len(a.keys) == 0 {
+		return nil, errors.New("no api keys configured")
+	}
+	return a, nil
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Identity, context.Context, error) {
+	key := r.Header.Get(a.header)
+	if key == "" {
+		key = r.URL.Query().Get(a.queryParam)
+	}
+	if key == "" {
+		return nil, nil, ErrNoCredentials
+	}
+	entry, ok := a.keys[HashAPIKey(key)]
+	if !ok {
+		return nil, nil, errors.New("invalid api key")
+	}
+	return &Identity{
+		Subject:        entry.name,
+		Scheme:         "apikey",
+		allowedMethods: entry.methods,
+		limiter:        entry.limiter,
+	}, r.Context(), nil
+}
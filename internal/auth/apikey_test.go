@@ -0,0 +1,79 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_APIKeyAuthenticator(t *testing.T) {
+	authenticator, err := auth.NewAPIKeyAuthenticator(auth.APIKeyConfig{
+		Keys: []auth.APIKey{
+			{Name: "partner", Hash: auth.HashAPIKey("secret"), Methods: []string{"/pkg.Service/Get*"}},
+			{Name: "limited", Hash: auth.HashAPIKey("limited"), RateLimit: &ratelimit.Limit{Rate: 0, Burst: 1}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v\n", err)
+	}
+
+	t.Run("accepts keys from the header or query parameter", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("X-API-Key", "secret")
+		identity, _, err := authenticator.Authenticate(r)
+		if err != nil || identity.Subject != "partner" {
+			t.Fatalf("expected partner identity, got %v, %v\n", identity, err)
+		}
+
+		r = httptest.NewRequest("GET", "/test?api_key=secret", nil)
+		if _, _, err := authenticator.Authenticate(r); err != nil {
+			t.Fatalf("expected query key to be accepted, got %v\n", err)
+		}
+	})
+
+	t.Run("rejects unknown keys and ignores requests without a key", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/test?api_key=wrong", nil)
+		if _, _, err := authenticator.Authenticate(r); err == nil || err == auth.ErrNoCredentials {
+			t.Fatalf("expected unknown key to be rejected, got %v\n", err)
+		}
+		r = httptest.NewRequest("GET", "/test", nil)
+		if _, _, err := authenticator.Authenticate(r); err != auth.ErrNoCredentials {
+			t.Fatalf("expected ErrNoCredentials, got %v\n", err)
+		}
+	})
+
+	t.Run("route guard enforces method scopes and rate limits", func(t *testing.T) {
+		reject := func(c *gin.Context, httpStatus int, _ int, _ string) {
+			c.AbortWithStatus(httpStatus)
+		}
+		app := gin.New()
+		app.Use(auth.Middleware([]auth.Authenticator{authenticator}, reject))
+		ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+		app.GET("/get", auth.RouteGuard("/pkg.Service/GetThing", reject), ok)
+		app.GET("/delete", auth.RouteGuard("/pkg.Service/DeleteThing", reject), ok)
+
+		call := func(path string, key string) int {
+			r := httptest.NewRequest("GET", path, nil)
+			r.Header.Set("X-API-Key", key)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+			return w.Code
+		}
+		if code := call("/get", "secret"); code != http.StatusOK {
+			t.Fatalf("expected scoped method to be allowed, got %d\n", code)
+		}
+		if code := call("/delete", "secret"); code != http.StatusForbidden {
+			t.Fatalf("expected method outside scope to be forbidden, got %d\n", code)
+		}
+		if code := call("/delete", "limited"); code != http.StatusOK {
+			t.Fatalf("expected first limited call to be allowed, got %d\n", code)
+		}
+		if code := call("/delete", "limited"); code != http.StatusTooManyRequests {
+			t.Fatalf("expected second limited call to be rate limited, got %d\n", code)
+		}
+	})
+}
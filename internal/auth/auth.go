@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// CloseUnauthorized and CloseForbidden are the websocket close codes matching
+	// http 401 and 403, in the range reserved for applications
+	CloseUnauthorized = grpcerr.CloseUnauthorized
+	CloseForbidden    = grpcerr.CloseForbidden
+
+	// BearerSubprotocol is the websocket subprotocol that announces a bearer token as the
+	// next offered subprotocol, for browsers that cannot set headers on websocket handshakes
+	BearerSubprotocol = "bearer"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries none of its credentials
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	// Subject identifies the caller, e.g. the sub claim of a JWT or the name of an API key
+	Subject string
+	// Scheme is the authentication scheme that produced the identity, e.g. jwt or apikey
+	Scheme string
+	// Claims holds the claims of a JWT, it is empty for other schemes
+	Claims map[string]any
+
+	// allowedMethods restricts the methods the caller may invoke, empty allows every method
+	allowedMethods []glob.Pattern
+	// limiter is the caller's own rate limit, if any
+	limiter *ratelimit.Bucket
+}
+
+// Allows reports whether the caller may invoke the fully qualified method
+func (i *Identity) Allows(fullMethodName string) bool {
+	return len(i.allowedMethods) == 0 || glob.MatchAny(i.allowedMethods, fullMethodName)
+}
+
+// TakeQuota takes cost from the caller's own rate limit, if any. When the limit is
+// exhausted it returns false and the time until the call may be retried
+func (i *Identity) TakeQuota(cost float64) (bool, time.Duration) {
+	if i.limiter == nil {
+		return true, 0
+	}
+	return i.limiter.Take(cost)
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the caller, if the request was authenticated
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// Authenticator verifies the credentials of a request
+type Authenticator interface {
+	// Authenticate returns the identity of the caller. It returns ErrNoCredentials if the
+	// request does not carry its kind of credentials, so the next authenticator can be tried.
+	// ctx is the context to use for the rest of the request, which may carry metadata for the backend
+	Authenticate(r *http.Request) (identity *Identity, ctx context.Context, err error)
+}
+
+// QueryParams returns the query parameters the authenticators accept credentials in
+func QueryParams(authenticators []Authenticator) []string {
+	var params []string
+	for _, a := range authenticators {
+		if q, ok := a.(interface{ QueryParam() string }); ok {
+			params = append(params, q.QueryParam())
+		}
+	}
+	return params
+}
+
+// RejectFunc refuses a request with an http status, or a close code for websockets
+type RejectFunc func(c *gin.Context, httpStatus int, closeCode int, reason string)
+
+// Middleware authenticates every request with the first authenticator whose credentials
+// it carries, and stores the identity in the request context. Requests without valid
+// credentials are rejected with 401, or CloseUnauthorized for websockets
+func Middleware(authenticators []Authenticator, reject RejectFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, authenticator := range authenticators {
+			identity, ctx, err := authenticator.Authenticate(c.Request)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			if err != nil {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				reject(c, http.StatusUnauthorized, CloseUnauthorized, err.Error())
+				return
+			}
+			c.Request = c.Request.WithContext(WithIdentity(ctx, identity))
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", "Bearer")
+		reject(c, http.StatusUnauthorized, CloseUnauthorized, "missing credentials")
+	}
+}
+
+// RouteGuard enforces the method scopes and rate limit of the caller for the route of a
+// single method. Calls outside the caller's scopes are rejected with 403, or CloseForbidden
+// for websockets, and calls over its rate limit with 429, or CloseTryAgainLater.
+func RouteGuard(fullMethodName string, reject RejectFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, ok := FromContext(c.Request.Context())
+		if !ok {
+			c.Next()
+			return
+		}
+		if !identity.Allows(fullMethodName) {
+			reject(c, http.StatusForbidden, CloseForbidden, fmt.Sprintf("%s is not allowed for %s", fullMethodName, identity.Subject))
+			return
+		}
+		if ok, retryAfter := identity.TakeQuota(1); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			reject(c, http.StatusTooManyRequests, websocket.CloseTryAgainLater, "rate limit exceeded")
+			return
+		}
+		c.Next()
+	}
+}
+
+// BearerToken extracts a bearer token from the Authorization header or, for websocket
+// handshakes, from the query parameter or the subprotocol following BearerSubprotocol
+func BearerToken(r *http.Request, queryParam string) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+	if token := r.URL.Query().Get(queryParam); queryParam != "" && token != "" {
+		return token
+	}
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == BearerSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+)
+
+// JWTConfig describes how bearer tokens are verified. One of JWKSFile or PEMFile is required
+type JWTConfig struct {
+	// JWKSFile is a local JSON Web Key Set holding the verification keys
+	JWKSFile string
+	// PEMFile holds a single verification key, as a public key or a certificate
+	PEMFile string
+	// Issuer, if set, must match the iss claim
+	Issuer string
+	// Audience, if set, must be contained in the aud claim
+	Audience string
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat
+	Leeway time.Duration
+	// ForwardClaims maps claim names to the grpc metadata keys they are forwarded to the backend as
+	ForwardClaims map[string]string
+	// QueryParam is the query parameter websocket handshakes may carry the token in. Defaults to access_token
+	QueryParam string
+}
+
+// JWTAuthenticator authenticates requests carrying a bearer JWT
+type JWTAuthenticator struct {
+	cfg    JWTConfig
+	keys   map[string]crypto.PublicKey
+	parser *jwt.Parser
+}
+
+func NewJWTAuthenticator(cfg JWTConfig) (*JWTAuthenticator, error) {
+	var keys map[string]crypto.PublicKey
+	var err error
+	switch {
+	case cfg.JWKSFile != "":
+		keys, err = loadJWKS(cfg.JWKSFile)
+	case cfg.PEMFile != "":
+		var key crypto.PublicKey
+		key, err = loadPEM(cfg.PEMFile)
+		keys = map[string]crypto.PublicKey{"": key}
+	default:
+		err = errors.New("one of JWKSFile or PEMFile is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading jwt verification keys: %w", err)
+	}
+	if cfg.QueryParam == "" {
+		cfg.QueryParam = "access_token"
+	}
+
+	parserOpts := []jwt.ParserOption{
+		// only asymmetric algorithms, so a public key can never be used as an hmac secret
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(cfg.Audience))
+	}
+	return &JWTAuthenticator{
+		cfg:    cfg,
+		keys:   keys,
+		parser: jwt.NewParser(parserOpts...),
+	}, nil
+}
+
+// QueryParam is the query parameter websocket handshakes may carry the token in
+func (a *JWTAuthenticator) QueryParam() string {
+	return a.cfg.QueryParam
+}
+
+func (a *JWTAuthenticator) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	// a token without kid can only be verified if there is no choice of key
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, context.Context, error) {
+	raw := BearerToken(r, a.cfg.QueryParam)
+	if raw == "" {
+		return nil, nil, ErrNoCredentials
+	}
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(raw, claims, a.keyFunc); err != nil {
+		return nil, nil, fmt.Errorf("invalid bearer token: %w", err)
+	}
+	subject, _ := claims.GetSubject()
+
+	ctx := r.Context()
+	for claim, key := range a.cfg.ForwardClaims {
+		value, ok := claims[claim]
+		if !ok {
+			continue
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, key, claimString(value))
+	}
+	return &Identity{Subject: subject, Scheme: "jwt", Claims: claims}, ctx, nil
+}
+
+// claimString renders a claim as a metadata value, strings as is and anything else as json
+func claimString(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no keys in key set")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func loadPEM(path string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
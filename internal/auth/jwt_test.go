@@ -0,0 +1,172 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+)
+
+// writeJWKS generates a P-256 key and writes its public half as a key set with the given kid
+func writeJWKS(t *testing.T, kid string) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v\n", err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	set := map[string]any{"keys": []map[string]string{{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   encode(key.X.FillBytes(make([]byte, 32))),
+		"y":   encode(key.Y.FillBytes(make([]byte, 32))),
+	}}}
+	b, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("failed to write key set: %v\n", err)
+	}
+	return key, path
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v\n", err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":    "user-1",
+		"iss":    "https://issuer.example",
+		"aud":    "proxy",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"tenant": "acme",
+	}
+}
+
+func Test_JWTAuthenticator(t *testing.T) {
+	key, jwksFile := writeJWKS(t, "key-1")
+	authenticator, err := auth.NewJWTAuthenticator(auth.JWTConfig{
+		JWKSFile:      jwksFile,
+		Issuer:        "https://issuer.example",
+		Audience:      "proxy",
+		ForwardClaims: map[string]string{"tenant": "x-tenant"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v\n", err)
+	}
+
+	t.Run("accepts a valid bearer token and forwards claims as metadata", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("Authorization", "Bearer "+sign(t, key, "key-1", validClaims()))
+		identity, ctx, err := authenticator.Authenticate(r)
+		if err != nil {
+			t.Fatalf("did not expect error: %v\n", err)
+		}
+		if identity.Subject != "user-1" {
+			t.Fatalf("expected subject user-1, got %s\n", identity.Subject)
+		}
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if got := md.Get("x-tenant"); len(got) != 1 || got[0] != "acme" {
+			t.Fatalf("expected x-tenant metadata acme, got %v\n", got)
+		}
+	})
+
+	t.Run("rejects expired tokens, wrong audiences and unknown keys", func(t *testing.T) {
+		expired := validClaims()
+		expired["exp"] = time.Now().Add(-time.Hour).Unix()
+		wrongAudience := validClaims()
+		wrongAudience["aud"] = "someone-else"
+		for name, token := range map[string]string{
+			"expired":        sign(t, key, "key-1", expired),
+			"wrong audience": sign(t, key, "key-1", wrongAudience),
+			"unknown key":    sign(t, key, "key-2", validClaims()),
+		} {
+			r := httptest.NewRequest("GET", "/test", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			if _, _, err := authenticator.Authenticate(r); err == nil {
+				t.Fatalf("expected %s token to be rejected\n", name)
+			}
+		}
+	})
+
+	t.Run("reads the token from the query or subprotocol of websocket handshakes only", func(t *testing.T) {
+		token := sign(t, key, "key-1", validClaims())
+		upgrade := func(r *http.Request) *http.Request {
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+			return r
+		}
+
+		r := upgrade(httptest.NewRequest("GET", "/test?access_token="+token, nil))
+		if _, _, err := authenticator.Authenticate(r); err != nil {
+			t.Fatalf("expected query token to be accepted, got %v\n", err)
+		}
+
+		r = upgrade(httptest.NewRequest("GET", "/test", nil))
+		r.Header.Set("Sec-WebSocket-Protocol", auth.BearerSubprotocol+", "+token)
+		if _, _, err := authenticator.Authenticate(r); err != nil {
+			t.Fatalf("expected subprotocol token to be accepted, got %v\n", err)
+		}
+
+		r = httptest.NewRequest("GET", "/test?access_token="+token, nil)
+		if _, _, err := authenticator.Authenticate(r); err != auth.ErrNoCredentials {
+			t.Fatalf("expected query token of plain request to be ignored, got %v\n", err)
+		}
+	})
+}
+
+func Test_Middleware(t *testing.T) {
+	key, jwksFile := writeJWKS(t, "key-1")
+	authenticator, err := auth.NewJWTAuthenticator(auth.JWTConfig{JWKSFile: jwksFile})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v\n", err)
+	}
+	reject := func(c *gin.Context, httpStatus int, _ int, reason string) {
+		c.AbortWithStatus(httpStatus)
+	}
+
+	app := gin.New()
+	app.Use(auth.Middleware([]auth.Authenticator{authenticator}, reject))
+	app.GET("/test", func(c *gin.Context) {
+		identity, _ := auth.FromContext(c.Request.Context())
+		c.String(http.StatusOK, identity.Subject)
+	})
+
+	t.Run("rejects requests without credentials with 401", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusUnauthorized, w.Code)
+		}
+	})
+
+	t.Run("stores the identity of authenticated requests", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("Authorization", "Bearer "+sign(t, key, "key-1", validClaims()))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != "user-1" {
+			t.Fatalf("Expected 200 user-1, got %d %s\n", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package authz_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/authz"
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_Expr(t *testing.T) {
+	env := map[string]any{
+		"method":  "/pkg.Admin/Delete",
+		"ip":      "10.1.2.3",
+		"headers": map[string]any{"x-tenant": "acme"},
+		"claims":  map[string]any{"roles": []any{"reader", "admin"}, "level": 3.0},
+		"request": map[string]any{"value": "42"},
+	}
+	for src, expected := range map[string]bool{
+		`method == "/pkg.Admin/Delete"`:                              true,
+		`glob(method, "/pkg.Admin/*") && !("admin" in claims.roles)`: false,
+		`"admin" in claims.roles`:                                    true,
+		`claims["level"] >= 2 && claims.level < 4`:                   true,
+		`headers["X-Tenant"] == 'acme'`:                              true,
+		`cidr(ip, "10.0.0.0/8")`:                                     true,
+		`cidr(ip, "192.168.0.0/16") || request.value == 42`:          true,
+		`request.value > 100`:                                        false,
+		`claims.missing == null`:                                     true,
+		`claims.missing.deeper == "x"`:                               false,
+		`startsWith(lower("ABC"), "ab") && ["a", "b"]`:               true,
+	} {
+		expr, err := authz.Compile(src)
+		if err != nil {
+			t.Fatalf("did not expect error compiling %s: %v\n", src, err)
+		}
+		got, err := expr.Eval(env)
+		if err != nil {
+			t.Fatalf("did not expect error evaluating %s: %v\n", src, err)
+		}
+		if got != expected {
+			t.Fatalf("Expected %s to be %v\n", src, expected)
+		}
+	}
+
+	for _, src := range []string{`method ==`, `"unterminated`, `nope(1)`, `(method`, `method # 1`} {
+		if _, err := authz.Compile(src); err == nil {
+			t.Fatalf("Expected error compiling %s\n", src)
+		}
+	}
+}
+
+func Test_Policy(t *testing.T) {
+	cfg := authz.Config{
+		Rules: []authz.Rule{
+			{Name: "no-admin", Methods: []string{"/pkg.Admin/*"}, Effect: authz.Deny, When: `!("admin" in claims.roles)`},
+			{Name: "small-values", Effect: authz.Allow, When: `request.value < 100`},
+			{Name: "tenant", Effect: authz.Allow, When: `headers["x-tenant"] == "acme"`},
+		},
+	}
+	policy, err := authz.New(cfg)
+	if err != nil {
+		t.Fatalf("did not expect error: %v\n", err)
+	}
+
+	t.Run("the first matching rule decides", func(t *testing.T) {
+		d := policy.Evaluate(authz.Input{Method: "/pkg.Admin/Delete", Claims: map[string]any{"roles": []string{"reader"}}})
+		if d.Allowed || d.Rule != "no-admin" {
+			t.Fatalf("Expected no-admin to deny, got %+v\n", d)
+		}
+		d = policy.Evaluate(authz.Input{Method: "/pkg.S/M", Request: &tgsbpb.UnaryCallIntRequest{Value: 7}})
+		if !d.Allowed || d.Rule != "small-values" {
+			t.Fatalf("Expected small-values to allow, got %+v\n", d)
+		}
+		d = policy.Evaluate(authz.Input{Method: "/pkg.S/M", Header: http.Header{"X-Tenant": {"acme"}}})
+		if !d.Allowed || d.Rule != "tenant" {
+			t.Fatalf("Expected tenant to allow, got %+v\n", d)
+		}
+	})
+
+	t.Run("denies by default with PERMISSION_DENIED", func(t *testing.T) {
+		err := policy.Check(context.Background(), authz.Input{Method: "/pkg.S/M", Request: &tgsbpb.UnaryCallIntRequest{Value: 700}})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("Expected PERMISSION_DENIED, got %v\n", err)
+		}
+	})
+
+	t.Run("does not enforce in dry run mode", func(t *testing.T) {
+		cfg := cfg
+		cfg.DryRun = true
+		dryRun, _ := authz.New(cfg)
+		if err := dryRun.Check(context.Background(), authz.Input{Method: "/pkg.S/M"}); err != nil {
+			t.Fatalf("Expected no error in dry run mode, got %v\n", err)
+		}
+	})
+
+	t.Run("refuses invalid rules", func(t *testing.T) {
+		if _, err := authz.New(authz.Config{Rules: []authz.Rule{{Effect: "maybe"}}}); err == nil {
+			t.Fatalf("Expected error for an unknown effect\n")
+		}
+		if _, err := authz.New(authz.Config{Rules: []authz.Rule{{Effect: authz.Allow, When: "a =="}}}); err == nil {
+			t.Fatalf("Expected error for an invalid expression\n")
+		}
+	})
+}
@@ -0,0 +1,580 @@
+package authz
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+)
+
+// Expr is a compiled condition. The language has
+//
+//   - literals: 'single' or "double" quoted strings, numbers, true, false, null and lists [a, b]
+//   - variables: method, subject, scheme, ip, headers, claims and request, indexed with
+//     .name or ["name"], e.g. claims.roles or headers["x-tenant"]. Missing values are null
+//   - operators: == != < <= > >= in && || ! and parentheses. "in" tests membership of
+//     a list, a substring or a key of an object
+//   - functions: glob(s, pattern), cidr(ip, "10.0.0.0/8"), startsWith(s, prefix),
+//     endsWith(s, suffix) and lower(s)
+type Expr struct {
+	src  string
+	root node
+	// vars are the variables the expression refers to
+	vars map[string]bool
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Compile parses src into an expression
+func Compile(src string) (*Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, vars: map[string]bool{}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.peek().text, p.peek().pos)
+	}
+	return &Expr{src: src, root: root, vars: p.vars}, nil
+}
+
+// Eval evaluates the expression against the variables in env and reports whether it holds
+func (e *Expr) Eval(env map[string]any) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: sb.String(), pos: i})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			j := i + 1
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: src[i:j], pos: i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	vars   map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator or keyword text
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokenOp || t.kind == tokenIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %q at offset %d", text, p.peek().pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right node
+		right, err = p.parseAnd()
+		left = binary{op: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	for err == nil && p.accept("&&") {
+		var right node
+		right, err = p.parseNot()
+		left = binary{op: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		return not{operand: operand}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if p.accept(op) {
+			right, err := p.parsePrimary()
+			return binary{op: op, left: left, right: right}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	var n node
+	switch {
+	case t.kind == tokenString:
+		n = literal{value: t.text}
+	case t.kind == tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", t.text, t.pos)
+		}
+		n = literal{value: f}
+	case t.kind == tokenIdent && t.text == "true":
+		n = literal{value: true}
+	case t.kind == tokenIdent && t.text == "false":
+		n = literal{value: false}
+	case t.kind == tokenIdent && t.text == "null":
+		n = literal{value: nil}
+	case t.kind == tokenIdent && p.accept("("):
+		fn, ok := functions[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s at offset %d", t.text, t.pos)
+		}
+		args, err := p.parseList(")")
+		if err != nil {
+			return nil, err
+		}
+		n = call{name: t.text, fn: fn, args: args}
+	case t.kind == tokenIdent:
+		p.vars[t.text] = true
+		n = variable{name: t.text}
+	case t.kind == tokenOp && t.text == "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		n = inner
+	case t.kind == tokenOp && t.text == "[":
+		items, err := p.parseList("]")
+		if err != nil {
+			return nil, err
+		}
+		n = list{items: items}
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			field := p.next()
+			if field.kind != tokenIdent {
+				return nil, fmt.Errorf("expected a field name at offset %d", field.pos)
+			}
+			n = index{target: n, key: literal{value: field.text}}
+		case p.accept("["):
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = index{target: n, key: key}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parseList(end string) ([]node, error) {
+	var items []node
+	if p.accept(end) {
+		return items, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.accept(end) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type node interface {
+	eval(env map[string]any) (any, error)
+}
+
+type literal struct{ value any }
+
+func (l literal) eval(map[string]any) (any, error) {
+	return l.value, nil
+}
+
+type variable struct{ name string }
+
+func (v variable) eval(env map[string]any) (any, error) {
+	value, ok := env[v.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %s", v.name)
+	}
+	return value, nil
+}
+
+type list struct{ items []node }
+
+func (l list) eval(env map[string]any) (any, error) {
+	values := make([]any, 0, len(l.items))
+	for _, item := range l.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+type index struct{ target, key node }
+
+func (ix index) eval(env map[string]any) (any, error) {
+	target, err := ix.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ix.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case map[string]any:
+		k := toString(key)
+		if v, ok := t[k]; ok {
+			return v, nil
+		}
+		// header names are case insensitive and stored lower case
+		return t[strings.ToLower(k)], nil
+	case []any:
+		i, ok := toNumber(key)
+		if !ok || i < 0 || int(i) >= len(t) {
+			return nil, nil
+		}
+		return t[int(i)], nil
+	default:
+		return nil, nil
+	}
+}
+
+type not struct{ operand node }
+
+func (n not) eval(env map[string]any) (any, error) {
+	v, err := n.operand.eval(env)
+	return !truthy(v), err
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (b binary) eval(env map[string]any) (any, error) {
+	left, err := b.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := b.right.eval(env)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := b.right.eval(env)
+		return truthy(right), err
+	}
+	right, err := b.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left), nil
+	default:
+		return compare(b.op, left, right), nil
+	}
+}
+
+type call struct {
+	name string
+	fn   func(args []any) (any, error)
+	args []node
+}
+
+func (c call) eval(env map[string]any) (any, error) {
+	args := make([]any, 0, len(c.args))
+	for _, arg := range c.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	v, err := c.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.name, err)
+	}
+	return v, nil
+}
+
+var functions = map[string]func(args []any) (any, error){
+	"glob": stringFunc(func(s, pattern string) (any, error) {
+		p, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return p.Match(s), nil
+	}),
+	"cidr": stringFunc(func(ip, cidr string) (any, error) {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		parsed := net.ParseIP(ip)
+		return parsed != nil && network.Contains(parsed), nil
+	}),
+	"startsWith": stringFunc(func(s, prefix string) (any, error) {
+		return strings.HasPrefix(s, prefix), nil
+	}),
+	"endsWith": stringFunc(func(s, suffix string) (any, error) {
+		return strings.HasSuffix(s, suffix), nil
+	}),
+	"lower": func(args []any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes 1 argument, got %d", len(args))
+		}
+		return strings.ToLower(toString(args[0])), nil
+	},
+}
+
+func stringFunc(fn func(a, b string) (any, error)) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("takes 2 arguments, got %d", len(args))
+		}
+		return fn(toString(args[0]), toString(args[1]))
+	}
+}
+
+func truthy(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case float64:
+		return t != 0
+	case []any:
+		return len(t) > 0
+	case map[string]any:
+		return len(t) > 0
+	default:
+		return true
+	}
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// toNumber converts numbers and numeric strings, as protojson renders 64 bit integers as strings
+func toNumber(v any) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func equal(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	_, aIsNumber := a.(float64)
+	_, bIsNumber := b.(float64)
+	if aIsNumber || bIsNumber {
+		x, okA := toNumber(a)
+		y, okB := toNumber(b)
+		return okA && okB && x == y
+	}
+	if x, ok := a.(bool); ok {
+		y, ok := b.(bool)
+		return ok && x == y
+	}
+	return toString(a) == toString(b)
+}
+
+func contains(container, item any) bool {
+	switch t := container.(type) {
+	case []any:
+		for _, v := range t {
+			if equal(v, item) {
+				return true
+			}
+		}
+		return false
+	case map[string]any:
+		_, ok := t[toString(item)]
+		return ok
+	case string:
+		return item != nil && strings.Contains(t, toString(item))
+	default:
+		return false
+	}
+}
+
+func compare(op string, a, b any) bool {
+	x, okA := toNumber(a)
+	y, okB := toNumber(b)
+	if !okA || !okB {
+		s, t := toString(a), toString(b)
+		if a == nil || b == nil {
+			return false
+		}
+		switch op {
+		case "<":
+			return s < t
+		case "<=":
+			return s <= t
+		case ">":
+			return s > t
+		default:
+			return s >= t
+		}
+	}
+	switch op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	default:
+		return x >= y
+	}
+}
@@ -0,0 +1,205 @@
+// Package authz evaluates declarative authorization policies before calls reach the backend
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Effect is what a matching rule decides
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Rule decides calls of the methods matching Methods, or of every method if it is empty,
+// for which When holds, or always if it is empty
+type Rule struct {
+	Name    string   `json:"name"`
+	Methods []string `json:"methods"`
+	Effect  Effect   `json:"effect"`
+	When    string   `json:"when"`
+}
+
+// Config is an ordered list of rules, the first matching rule decides a call
+type Config struct {
+	Rules []Rule `json:"rules"`
+	// Default decides calls no rule matches. Defaults to deny
+	Default Effect `json:"default"`
+	// DryRun logs the decisions without enforcing them, to audit a policy before rolling it out
+	DryRun bool `json:"dryRun"`
+}
+
+// Input is what a call is authorized on
+type Input struct {
+	Method  string
+	Subject string
+	Scheme  string
+	Claims  map[string]any
+	IP      string
+	Header  http.Header
+	Request proto.Message
+}
+
+// Decision is the outcome of evaluating a policy
+type Decision struct {
+	Allowed bool
+	// Rule is the name of the deciding rule, or "default"
+	Rule   string
+	Reason string
+}
+
+type rule struct {
+	name    string
+	methods []glob.Pattern
+	effect  Effect
+	when    *Expr
+}
+
+// Policy is a compiled Config
+type Policy struct {
+	rules        []rule
+	defaultRule  Effect
+	dryRun       bool
+	needsRequest bool
+}
+
+func New(cfg Config) (*Policy, error) {
+	p := &Policy{defaultRule: cfg.Default, dryRun: cfg.DryRun}
+	if p.defaultRule == "" {
+		p.defaultRule = Deny
+	}
+	if p.defaultRule != Allow && p.defaultRule != Deny {
+		return nil, fmt.Errorf("default must be allow or deny, got %q", cfg.Default)
+	}
+	for i, r := range cfg.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i)
+		}
+		if r.Effect != Allow && r.Effect != Deny {
+			return nil, fmt.Errorf("%s: effect must be allow or deny, got %q", name, r.Effect)
+		}
+		methods, err := glob.CompileAll(r.Methods)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		compiled := rule{name: name, methods: methods, effect: r.Effect}
+		if r.When != "" {
+			if compiled.when, err = Compile(r.When); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			p.needsRequest = p.needsRequest || compiled.when.vars["request"]
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+// Evaluate decides the call. Rules whose condition fails to evaluate deny the call
+func (p *Policy) Evaluate(in Input) Decision {
+	var env map[string]any
+	for _, r := range p.rules {
+		if len(r.methods) > 0 && !glob.MatchAny(r.methods, in.Method) {
+			continue
+		}
+		if r.when != nil {
+			if env == nil {
+				env = p.env(in)
+			}
+			holds, err := r.when.Eval(env)
+			if err != nil {
+				return Decision{Allowed: false, Rule: r.name, Reason: fmt.Sprintf("evaluating %q: %v", r.when, err)}
+			}
+			if !holds {
+				continue
+			}
+		}
+		return Decision{Allowed: r.effect == Allow, Rule: r.name, Reason: string(r.effect)}
+	}
+	return Decision{Allowed: p.defaultRule == Allow, Rule: "default", Reason: string(p.defaultRule)}
+}
+
+// Check evaluates the call and returns a PERMISSION_DENIED status error if it is denied.
+// Denials and dry-run decisions are logged to the logger of ctx, in dry-run mode nothing is denied
+func (p *Policy) Check(ctx context.Context, in Input) error {
+	d := p.Evaluate(in)
+	logger := logging.FromContext(ctx)
+	if p.dryRun {
+		logger.Info("authz dry run", slog.String("subject", in.Subject), slog.Bool("allowed", d.Allowed),
+			slog.String("rule", d.Rule), slog.String("reason", d.Reason))
+		return nil
+	}
+	if d.Allowed {
+		return nil
+	}
+	logger.Info("authz denied", slog.String("subject", in.Subject), slog.String("rule", d.Rule), slog.String("reason", d.Reason))
+	return status.Errorf(codes.PermissionDenied, "%s is denied by %s", in.Method, d.Rule)
+}
+
+// NeedsRequest reports whether any rule refers to the request message, so callers
+// know whether the request has to be decoded before the policy is checked
+func (p *Policy) NeedsRequest() bool {
+	return p.needsRequest
+}
+
+func (p *Policy) env(in Input) map[string]any {
+	headers := map[string]any{}
+	for name, values := range in.Header {
+		if len(values) > 0 {
+			headers[strings.ToLower(name)] = values[0]
+		}
+	}
+	claims := map[string]any{}
+	for name, value := range in.Claims {
+		claims[name] = normalize(value)
+	}
+	env := map[string]any{
+		"method":  in.Method,
+		"subject": in.Subject,
+		"scheme":  in.Scheme,
+		"ip":      in.IP,
+		"headers": headers,
+		"claims":  claims,
+		"request": map[string]any{},
+	}
+	if p.needsRequest && in.Request != nil {
+		env["request"] = messageFields(in.Request)
+	}
+	return env
+}
+
+// messageFields renders the request the way it is written in json request bodies
+func messageFields(m proto.Message) map[string]any {
+	fields := map[string]any{}
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(b, &fields)
+	return fields
+}
+
+// normalize converts claim values to the types expressions work on, e.g. []string to []any
+func normalize(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out any
+	json.Unmarshal(b, &out)
+	return out
+}
@@ -0,0 +1,259 @@
+package breaker
+
+import (
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type State int
+
+const (
+	// Closed lets every call through while counting failures
+	Closed State = iota
+	// Open fails every call fast until the open duration has passed
+	Open
+	// HalfOpen lets a limited number of probe calls through to decide whether to close again
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrOpen is returned by Allow while the breaker does not let calls through
+var ErrOpen = status.Error(codes.Unavailable, "circuit breaker is open")
+
+// Config describes when a breaker trips. Zero values are replaced by the defaults
+// documented on each field
+type Config struct {
+	// Window is the rolling period over which calls are counted. Defaults to 10s
+	Window time.Duration
+	// MinRequests is the number of calls in the window below which the breaker never trips. Defaults to 20
+	MinRequests int
+	// FailureRate is the fraction of failed calls in the window that trips the breaker. Defaults to 0.5
+	FailureRate float64
+	// FailureCodes are the grpc codes counted as failures. Defaults to
+	// UNAVAILABLE, DEADLINE_EXCEEDED, INTERNAL and UNKNOWN
+	FailureCodes []codes.Code
+	// SlowCallDuration makes calls slower than it count as failures, 0 disables it
+	SlowCallDuration time.Duration
+	// OpenDuration is how long the breaker stays open before probing the backend. Defaults to 30s
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of calls let through while half-open, all of them
+	// must succeed to close the breaker. Defaults to 1
+	HalfOpenProbes int
+	// ProbeTimeout is how long probes may take before they are given up on and new probes
+	// are let through, e.g. when they are long lived streams. Defaults to OpenDuration
+	ProbeTimeout time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.FailureRate <= 0 {
+		c.FailureRate = 0.5
+	}
+	if len(c.FailureCodes) == 0 {
+		c.FailureCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown}
+	}
+	if c.OpenDuration <= 0 {
+		c.OpenDuration = 30 * time.Second
+	}
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = 1
+	}
+	if c.ProbeTimeout <= 0 {
+		c.ProbeTimeout = c.OpenDuration
+	}
+	return c
+}
+
+// buckets splits the window so old calls age out gradually
+const buckets = 10
+
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// Breaker is a circuit breaker for a single backend method, it is safe for concurrent use
+type Breaker struct {
+	cfg Config
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	openedAt time.Time
+	buckets  [buckets]bucket
+	// probes is the number of calls let through since the breaker became half-open,
+	// probeSuccesses how many of them succeeded and probedAt when the last one was let through
+	probes         int
+	probeSuccesses int
+	probedAt       time.Time
+}
+
+func New(cfg Config) *Breaker {
+	return &Breaker{cfg: cfg.withDefaults(), now: time.Now}
+}
+
+// Allow reports whether a call may proceed, it returns ErrOpen when it may not.
+// Every allowed call must be followed by a call to Record
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	switch b.state {
+	case Open:
+		return ErrOpen
+	case HalfOpen:
+		if b.probes >= b.cfg.HalfOpenProbes && b.now().Before(b.probedAt.Add(b.cfg.ProbeTimeout)) {
+			return ErrOpen
+		}
+		if b.probes >= b.cfg.HalfOpenProbes {
+			// the probes timed out, they are given up on
+			b.probes, b.probeSuccesses = 0, 0
+		}
+		b.probes++
+		b.probedAt = b.now()
+	}
+	return nil
+}
+
+// RetryAfter is how long until an open breaker starts probing the backend again
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return 0
+	}
+	return b.openedAt.Add(b.cfg.OpenDuration).Sub(b.now())
+}
+
+// RetryAfterSeconds is RetryAfter rounded up to whole seconds, the granularity of the Retry-After header
+func (b *Breaker) RetryAfterSeconds() int {
+	return int(math.Ceil(b.RetryAfter().Seconds()))
+}
+
+// Record reports the outcome of an allowed call. io.EOF counts as success,
+// cancellations are not counted at all as they say nothing about the backend, but
+// release the probe slot they took while half-open
+func (b *Breaker) Record(err error, latency time.Duration) {
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if status.Code(err) == codes.Canceled {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.advance()
+		if b.state == HalfOpen && b.probes > b.probeSuccesses {
+			b.probes--
+		}
+		return
+	}
+	failed := slices.Contains(b.cfg.FailureCodes, status.Code(err)) ||
+		b.cfg.SlowCallDuration > 0 && latency > b.cfg.SlowCallDuration
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	switch b.state {
+	case HalfOpen:
+		if failed {
+			b.trip()
+			return
+		}
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.cfg.HalfOpenProbes {
+			b.reset()
+		}
+	case Closed:
+		current := b.currentBucket()
+		current.requests++
+		if failed {
+			current.failures++
+		}
+		requests, failures := b.counts()
+		if requests >= b.cfg.MinRequests && float64(failures)/float64(requests) >= b.cfg.FailureRate {
+			b.trip()
+		}
+	}
+}
+
+// Counts returns the number of calls and failures in the current window
+func (b *Breaker) Counts() (requests int, failures int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts()
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// advance moves an open breaker to half-open once its open duration has passed
+func (b *Breaker) advance() {
+	if b.state == Open && !b.now().Before(b.openedAt.Add(b.cfg.OpenDuration)) {
+		b.state = HalfOpen
+		b.probes = 0
+		b.probeSuccesses = 0
+	}
+}
+
+func (b *Breaker) trip() {
+	b.state = Open
+	b.openedAt = b.now()
+}
+
+func (b *Breaker) reset() {
+	b.state = Closed
+	b.buckets = [buckets]bucket{}
+}
+
+func (b *Breaker) bucketWidth() time.Duration {
+	return b.cfg.Window / buckets
+}
+
+func (b *Breaker) currentBucket() *bucket {
+	now := b.now()
+	start := now.Truncate(b.bucketWidth())
+	current := &b.buckets[(start.UnixNano()/int64(b.bucketWidth()))%buckets]
+	if !current.start.Equal(start) {
+		*current = bucket{start: start}
+	}
+	return current
+}
+
+func (b *Breaker) counts() (requests int, failures int) {
+	oldest := b.now().Add(-b.cfg.Window)
+	for _, bkt := range b.buckets {
+		if bkt.start.After(oldest) {
+			requests += bkt.requests
+			failures += bkt.failures
+		}
+	}
+	return requests, failures
+}
@@ -0,0 +1,140 @@
+package breaker
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func newTestBreaker(cfg Config) (*Breaker, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	b := New(cfg)
+	b.now = clock.Now
+	return b, clock
+}
+
+func Test_Breaker(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	t.Run("trips once the failure rate is reached", func(t *testing.T) {
+		b, _ := newTestBreaker(Config{MinRequests: 4, FailureRate: 0.5})
+		for _, err := range []error{nil, nil, unavailable} {
+			b.Allow()
+			b.Record(err, 0)
+		}
+		if b.State() != Closed {
+			t.Fatalf("expected breaker to be closed below min requests, got %s\n", b.State())
+		}
+		b.Allow()
+		b.Record(unavailable, 0)
+		if b.State() != Open {
+			t.Fatalf("expected breaker to be open, got %s\n", b.State())
+		}
+		if !errors.Is(b.Allow(), ErrOpen) {
+			t.Fatalf("expected open breaker to fail fast\n")
+		}
+	})
+
+	t.Run("client errors, cancellations and EOF are not failures", func(t *testing.T) {
+		b, _ := newTestBreaker(Config{MinRequests: 1})
+		for _, err := range []error{
+			status.Error(codes.InvalidArgument, "bad"),
+			status.Error(codes.Canceled, "cancelled"),
+			io.EOF,
+		} {
+			b.Allow()
+			b.Record(err, 0)
+		}
+		if b.State() != Closed {
+			t.Fatalf("expected breaker to stay closed, got %s\n", b.State())
+		}
+	})
+
+	t.Run("slow calls count as failures", func(t *testing.T) {
+		b, _ := newTestBreaker(Config{MinRequests: 1, SlowCallDuration: time.Second})
+		b.Allow()
+		b.Record(nil, 2*time.Second)
+		if b.State() != Open {
+			t.Fatalf("expected slow call to trip the breaker, got %s\n", b.State())
+		}
+	})
+
+	t.Run("half-open probe closes the breaker on success and reopens it on failure", func(t *testing.T) {
+		b, clock := newTestBreaker(Config{MinRequests: 1, OpenDuration: time.Second})
+		b.Allow()
+		b.Record(unavailable, 0)
+
+		clock.now = clock.now.Add(time.Second)
+		if b.State() != HalfOpen {
+			t.Fatalf("expected breaker to be half-open, got %s\n", b.State())
+		}
+		if err := b.Allow(); err != nil {
+			t.Fatalf("expected probe to be allowed, got %v\n", err)
+		}
+		if !errors.Is(b.Allow(), ErrOpen) {
+			t.Fatalf("expected only one probe to be allowed\n")
+		}
+		b.Record(unavailable, 0)
+		if b.State() != Open {
+			t.Fatalf("expected failed probe to reopen the breaker, got %s\n", b.State())
+		}
+
+		clock.now = clock.now.Add(time.Second)
+		b.Allow()
+		b.Record(nil, 0)
+		if b.State() != Closed {
+			t.Fatalf("expected successful probe to close the breaker, got %s\n", b.State())
+		}
+	})
+
+	t.Run("cancelled and timed out probes release their slot", func(t *testing.T) {
+		b, clock := newTestBreaker(Config{MinRequests: 1, OpenDuration: time.Second, ProbeTimeout: 5 * time.Second})
+		b.Allow()
+		b.Record(unavailable, 0)
+		clock.now = clock.now.Add(time.Second)
+
+		if err := b.Allow(); err != nil {
+			t.Fatalf("expected probe to be allowed, got %v\n", err)
+		}
+		b.Record(status.Error(codes.Canceled, "client left"), 0)
+		if err := b.Allow(); err != nil {
+			t.Fatalf("expected the cancelled probe to release its slot, got %v\n", err)
+		}
+		if !errors.Is(b.Allow(), ErrOpen) {
+			t.Fatalf("expected only one probe to be allowed\n")
+		}
+
+		clock.now = clock.now.Add(5 * time.Second)
+		if err := b.Allow(); err != nil {
+			t.Fatalf("expected a probe to be allowed once the last one timed out, got %v\n", err)
+		}
+		b.Record(nil, 0)
+		if b.State() != Closed {
+			t.Fatalf("expected successful probe to close the breaker, got %s\n", b.State())
+		}
+	})
+
+	t.Run("failures age out of the window", func(t *testing.T) {
+		b, clock := newTestBreaker(Config{MinRequests: 2, Window: 10 * time.Second})
+		b.Allow()
+		b.Record(unavailable, 0)
+		clock.now = clock.now.Add(11 * time.Second)
+		b.Allow()
+		b.Record(unavailable, 0)
+		if b.State() != Closed {
+			t.Fatalf("expected old failure to be forgotten, got %s\n", b.State())
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+)
+
+type key struct {
+	backend string
+	method  string
+}
+
+// Status is a point in time view of a breaker
+type Status struct {
+	Backend  string `json:"backend"`
+	Method   string `json:"method"`
+	State    string `json:"state"`
+	Requests int    `json:"requests"`
+	Failures int    `json:"failures"`
+}
+
+// Registry hands out one breaker per backend and method, all sharing the same config
+type Registry struct {
+	cfg Config
+
+	mu       sync.Mutex
+	breakers map[key]*Breaker
+}
+
+func NewRegistry(cfg Config) *Registry {
+	return &Registry{
+		cfg:      cfg,
+		breakers: map[key]*Breaker{},
+	}
+}
+
+// Get returns the breaker of the method on the backend, creating it on first use
+func (r *Registry) Get(backend string, method string) *Breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := key{backend, method}
+	b, ok := r.breakers[k]
+	if !ok {
+		b = New(r.cfg)
+		r.breakers[k] = b
+	}
+	return b
+}
+
+// Snapshot returns the status of every breaker, ordered by backend and method
+func (r *Registry) Snapshot() []Status {
+	r.mu.Lock()
+	statuses := make([]Status, 0, len(r.breakers))
+	for k, b := range r.breakers {
+		requests, failures := b.Counts()
+		statuses = append(statuses, Status{
+			Backend:  k.backend,
+			Method:   k.method,
+			State:    b.State().String(),
+			Requests: requests,
+			Failures: failures,
+		})
+	}
+	r.mu.Unlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Backend != statuses[j].Backend {
+			return statuses[i].Backend < statuses[j].Backend
+		}
+		return statuses[i].Method < statuses[j].Method
+	})
+	return statuses
+}
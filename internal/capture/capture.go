@@ -0,0 +1,333 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/redact"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/rotate"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultMaxMessages bounds the stream messages held for a record, see Config.MaxMessages
+const defaultMaxMessages = 1000
+
+// defaultRedactedHeaders carry credentials, the websocket subprotocols may carry a bearer token
+var defaultRedactedHeaders = []string{"authorization", "proxy-authorization", "cookie", "x-api-key", "sec-websocket-protocol"}
+
+// Config describes where exchanges are captured and which ones are
+type Config struct {
+	// File is the path of the capture, it is rotated once it grows past MaxSizeMB and
+	// MaxBackups rotated files are kept next to it as File.1, File.2 and so on
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"maxSizeMB"`
+	MaxBackups int    `json:"maxBackups"`
+	// Methods are globs of the fully qualified methods to capture, see glob.Pattern.
+	// Empty captures every method
+	Methods []string `json:"methods"`
+	// SampleRate is the fraction of the calls of captured methods that are recorded, 1 by default
+	SampleRate *float64 `json:"sampleRate"`
+	// Redact names message fields whose values are replaced with redact.Redacted, in
+	// requests, responses and stream messages alike
+	Redact []string `json:"redact"`
+	// RedactHeaders names request headers whose values are replaced, in addition to
+	// Authorization, Proxy-Authorization, Cookie, X-Api-Key and Sec-Websocket-Protocol
+	RedactHeaders []string `json:"redactHeaders"`
+	// MaxMessages is the number of stream messages kept per record, 1000 by default. The
+	// messages of a stream are held in memory until it ends, later ones are dropped and
+	// the record is marked as truncated
+	MaxMessages int `json:"maxMessages"`
+}
+
+// Record is a single captured exchange, written as one json line. Messages are rendered
+// as protojson
+type Record struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Stream bool      `json:"stream,omitempty"`
+	// Transport is http for unary calls, websocket or sse for streams
+	Transport  string `json:"transport"`
+	HTTPMethod string `json:"httpMethod"`
+	// Path is the path of the request with its query, the values of redacted fields and
+	// credential parameters in the query are replaced
+	Path string `json:"path"`
+	// Metadata are the request headers, with lower case names
+	Metadata map[string][]string `json:"metadata,omitempty"`
+	Request  json.RawMessage     `json:"request,omitempty"`
+	// Response is the response of a successful unary call
+	Response json.RawMessage `json:"response,omitempty"`
+	// Messages are the messages a stream forwarded to the client, in order
+	Messages []Message `json:"messages,omitempty"`
+	// Truncated is set when the stream forwarded more than Config.MaxMessages messages,
+	// only the first ones are recorded
+	Truncated  bool   `json:"truncated,omitempty"`
+	Status     Status `json:"status"`
+	HTTPStatus int    `json:"httpStatus,omitempty"`
+	// CloseCode is the code a websocket was closed with
+	CloseCode  int     `json:"closeCode,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// Message is a message of a stream with the time it was forwarded, relative to the start of the call
+type Message struct {
+	OffsetMs float64         `json:"offsetMs"`
+	Message  json.RawMessage `json:"message"`
+}
+
+// Status is the grpc status a call ended with, details are rendered as protojson
+type Status struct {
+	// Code is the canonical name of the code, e.g. OK or FAILED_PRECONDITION
+	Code    string            `json:"code"`
+	Message string            `json:"message,omitempty"`
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// Recorder writes captured exchanges to a rotating file, it is safe for concurrent use
+type Recorder struct {
+	methods    []glob.Pattern
+	sampleRate float64
+	maxMsgs    int
+	fields     redact.Fields
+	redact     []string
+	headers    map[string]bool
+	file       *rotate.File
+}
+
+// New opens the capture file of cfg
+func New(cfg Config) (*Recorder, error) {
+	if cfg.File == "" {
+		return nil, errors.New("capture needs a file")
+	}
+	methods, err := glob.CompileAll(cfg.Methods)
+	if err != nil {
+		return nil, fmt.Errorf("capture methods: %w", err)
+	}
+	sampleRate := 1.0
+	if cfg.SampleRate != nil {
+		sampleRate = *cfg.SampleRate
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("capture sample rate %v: must be between 0 and 1", sampleRate)
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = defaultMaxMessages
+	}
+	file, err := rotate.Config{File: cfg.File, MaxSizeMB: cfg.MaxSizeMB, MaxBackups: cfg.MaxBackups}.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening capture file: %w", err)
+	}
+	headers := map[string]bool{}
+	for _, name := range append(defaultRedactedHeaders, cfg.RedactHeaders...) {
+		headers[strings.ToLower(name)] = true
+	}
+	return &Recorder{methods: methods, sampleRate: sampleRate, maxMsgs: cfg.MaxMessages, fields: redact.New(cfg.Redact), redact: cfg.Redact, headers: headers, file: file}, nil
+}
+
+// Captures reports whether calls of the fully qualified method are captured
+func (r *Recorder) Captures(fullMethodName string) bool {
+	return len(r.methods) == 0 || glob.MatchAny(r.methods, fullMethodName)
+}
+
+// exchangeKey holds the exchange of a sampled call in its gin context
+const exchangeKey = "capture.exchange"
+
+// exchange collects a call as it is proxied
+type exchange struct {
+	method string
+	stream bool
+	start  time.Time
+	query  redact.Fields
+
+	// stream messages may be forwarded from a goroutine of their own
+	mu        sync.Mutex
+	response  json.RawMessage
+	messages  []Message
+	truncated bool
+}
+
+// Middleware samples the calls of method and starts capturing those that are sampled,
+// the hooks of the route then record them. The values of the redacted fields and of the
+// redactQuery parameters, e.g. credentials, are replaced in the captured query
+func (r *Recorder) Middleware(fullMethodName string, stream bool, redactQuery ...string) gin.HandlerFunc {
+	query := redact.New(append(append([]string(nil), r.redact...), redactQuery...))
+	return func(c *gin.Context) {
+		if r.sampleRate < 1 && rand.Float64() >= r.sampleRate {
+			return
+		}
+		c.Set(exchangeKey, &exchange{method: fullMethodName, stream: stream, start: time.Now(), query: query})
+	}
+}
+
+func exchangeOf(c *gin.Context) (*exchange, bool) {
+	ex, ok := c.Get(exchangeKey)
+	if !ok {
+		return nil, false
+	}
+	return ex.(*exchange), true
+}
+
+// Response records the response of a unary call, see unary.WithResponseHook
+func (r *Recorder) Response(c *gin.Context, response proto.Message) {
+	ex, ok := exchangeOf(c)
+	if !ok {
+		return
+	}
+	payload, err := r.fields.Message(response)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("capturing response failed", slog.Any("error", err))
+		return
+	}
+	ex.mu.Lock()
+	ex.response = payload
+	ex.mu.Unlock()
+}
+
+// Message records a message forwarded by a stream, see serverstream.WithMessageHook
+func (r *Recorder) Message(c *gin.Context, _ int64, message proto.Message) {
+	ex, ok := exchangeOf(c)
+	if !ok {
+		return
+	}
+	offset := milliseconds(time.Since(ex.start))
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if len(ex.messages) >= r.maxMsgs {
+		ex.truncated = true
+		return
+	}
+	payload, err := r.fields.Message(message)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("capturing stream message failed", slog.Any("error", err))
+		return
+	}
+	ex.messages = append(ex.messages, Message{OffsetMs: offset, Message: payload})
+}
+
+// Complete writes the record of the call once it has ended, see unary.WithCompletion and
+// serverstream.WithCompletion
+func (r *Recorder) Complete(c *gin.Context, request proto.Message, err error, elapsed time.Duration) {
+	ex, ok := exchangeOf(c)
+	if !ok {
+		return
+	}
+	record := Record{
+		Time:       ex.start.UTC(),
+		Method:     ex.method,
+		Stream:     ex.stream,
+		Transport:  "http",
+		HTTPMethod: c.Request.Method,
+		Path:       ex.query.URI(c.Request.URL),
+		Metadata:   r.metadata(c.Request.Header),
+		Status:     statusOf(err),
+		HTTPStatus: c.Writer.Status(),
+		DurationMs: milliseconds(elapsed),
+	}
+	if summary, ok := serverstream.StreamSummary(c); ok {
+		record.Transport = summary.Transport
+		record.CloseCode = summary.CloseCode
+	}
+	if request != nil {
+		payload, err := r.fields.Message(request)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("capturing request failed", slog.Any("error", err))
+		}
+		record.Request = payload
+	}
+	ex.mu.Lock()
+	record.Response, record.Messages, record.Truncated = ex.response, ex.messages, ex.truncated
+	ex.mu.Unlock()
+	if err := r.write(record); err != nil {
+		logging.FromContext(c.Request.Context()).Error("writing capture record failed", slog.Any("error", err))
+	}
+}
+
+func (r *Recorder) write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+// metadata lower cases the names of the headers and redacts the values of sensitive ones
+func (r *Recorder) metadata(header http.Header) map[string][]string {
+	md := make(map[string][]string, len(header))
+	for name, values := range header {
+		name = strings.ToLower(name)
+		if r.headers[name] {
+			md[name] = []string{redact.Redacted}
+			continue
+		}
+		md[name] = append([]string(nil), values...)
+	}
+	return md
+}
+
+// statusOf renders the grpc status of err, nil being OK
+func statusOf(err error) Status {
+	st := status.Convert(err)
+	s := Status{Code: grpcerr.CodeName(st.Code()), Message: st.Message()}
+	for _, detail := range st.Proto().GetDetails() {
+		payload, err := protojson.Marshal(detail)
+		if err != nil {
+			continue
+		}
+		s.Details = append(s.Details, payload)
+	}
+	return s
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// maxRecordSize bounds the length of a line read back from a capture file
+const maxRecordSize = 64 << 20
+
+// ReadFile reads the records of a capture file, in the order they were captured
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,83 @@
+package capture_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/capture"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Run("captures only the configured methods", func(t *testing.T) {
+		r, err := capture.New(capture.Config{
+			File:    filepath.Join(t.TempDir(), "capture.jsonl"),
+			Methods: []string{"/pkg.Service/Get*"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		defer r.Close()
+		if !r.Captures("/pkg.Service/GetUser") || r.Captures("/pkg.Service/UpdateUser") {
+			t.Fatalf("expected only Get methods to be captured\n")
+		}
+	})
+
+	t.Run("refuses sample rates outside of 0 and 1", func(t *testing.T) {
+		rate := 1.5
+		if _, err := capture.New(capture.Config{File: filepath.Join(t.TempDir(), "capture.jsonl"), SampleRate: &rate}); err == nil {
+			t.Fatalf("expected an error\n")
+		}
+	})
+
+	t.Run("keeps at most MaxMessages stream messages and marks the record truncated", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "capture.jsonl")
+		r, err := capture.New(capture.Config{File: path, MaxMessages: 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		app := gin.New()
+		app.GET("/", r.Middleware("/pkg.Service/Watch", true), func(c *gin.Context) {
+			for i, value := range []string{"a", "b", "c"} {
+				r.Message(c, int64(i+1), wrapperspb.String(value))
+			}
+			r.Complete(c, wrapperspb.String("request"), nil, time.Millisecond)
+		})
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		r.Close()
+		records, err := capture.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		if len(records) != 1 || len(records[0].Messages) != 2 || !records[0].Truncated {
+			t.Fatalf("expected a truncated record of 2 messages, got %+v\n", records)
+		}
+		if string(records[0].Messages[1].Message) != `"b"` {
+			t.Fatalf("expected the first messages to be kept, got %s\n", records[0].Messages[1].Message)
+		}
+	})
+
+	t.Run("records nothing for calls that are not sampled", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "capture.jsonl")
+		rate := 0.0
+		r, err := capture.New(capture.Config{File: path, SampleRate: &rate})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		app := gin.New()
+		app.GET("/", r.Middleware("/pkg.Service/Get", false), func(c *gin.Context) {
+			r.Response(c, wrapperspb.String("response"))
+			r.Complete(c, wrapperspb.String("request"), nil, time.Millisecond)
+		})
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		r.Close()
+		if content, _ := os.ReadFile(path); len(content) != 0 {
+			t.Fatalf("expected nothing to be captured, got %s\n", content)
+		}
+	})
+}
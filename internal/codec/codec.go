@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ContentTypeJSON  = "application/json"
+	ContentTypeProto = "application/x-protobuf"
+)
+
+// Codec translates between proto messages and the payloads exchanged with http clients
+type Codec interface {
+	Marshal(m proto.Message) ([]byte, error)
+	Unmarshal(b []byte, m proto.Message) error
+	// ContentType is used as the Content-Type of unary responses
+	ContentType() string
+	// Binary reports whether payloads must be sent as binary websocket messages
+	Binary() bool
+}
+
+// JSON encodes messages with protojson, it is the default codec
+type JSON struct {
+	MarshalOptions   protojson.MarshalOptions
+	UnmarshalOptions protojson.UnmarshalOptions
+}
+
+func (j JSON) Marshal(m proto.Message) ([]byte, error) {
+	return j.MarshalOptions.Marshal(m)
+}
+
+func (j JSON) Unmarshal(b []byte, m proto.Message) error {
+	return j.UnmarshalOptions.Unmarshal(b, m)
+}
+
+func (j JSON) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (j JSON) Binary() bool {
+	return false
+}
+
+// Proto encodes messages in the protobuf wire format
+type Proto struct {
+	MarshalOptions   proto.MarshalOptions
+	UnmarshalOptions proto.UnmarshalOptions
+}
+
+func (p Proto) Marshal(m proto.Message) ([]byte, error) {
+	return p.MarshalOptions.Marshal(m)
+}
+
+func (p Proto) Unmarshal(b []byte, m proto.Message) error {
+	return p.UnmarshalOptions.Unmarshal(b, m)
+}
+
+func (p Proto) ContentType() string {
+	return ContentTypeProto
+}
+
+func (p Proto) Binary() bool {
+	return true
+}
@@ -0,0 +1,188 @@
+// Package cors implements the cross-origin policy of the proxy, for plain requests as well as websocket upgrades
+package cors
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Config describes which cross-origin requests browsers are allowed to make
+type Config struct {
+	// AllowedOrigins are origins like https://app.example.com. A * matches a single run of
+	// characters, e.g. https://*.example.com, and a lone * allows every origin
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// AllowedMethods defaults to GET, POST and HEAD
+	AllowedMethods []string `json:"allowedMethods"`
+	// AllowedHeaders are the request headers browsers may send, a lone * allows whatever is requested.
+	// Defaults to Content-Type and Authorization
+	AllowedHeaders []string `json:"allowedHeaders"`
+	// ExposedHeaders are the response headers scripts may read, e.g. Retry-After
+	ExposedHeaders []string `json:"exposedHeaders"`
+	// AllowCredentials lets browsers send cookies and authorization headers
+	AllowCredentials bool `json:"allowCredentials"`
+	// MaxAge is how long browsers may cache the answer to a preflight request
+	MaxAge time.Duration `json:"-"`
+}
+
+// Policy decides on cross-origin requests according to a Config
+type Policy struct {
+	cfg            Config
+	allowAll       bool
+	allowedHeaders map[string]bool
+	anyHeader      bool
+}
+
+func New(cfg Config) *Policy {
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodHead}
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = []string{"Content-Type", "Authorization"}
+	}
+	p := &Policy{cfg: cfg, allowedHeaders: map[string]bool{}}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			p.allowAll = true
+		}
+	}
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+		}
+		p.allowedHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+	return p
+}
+
+// AllowsOrigin reports whether requests from origin are allowed
+func (p *Policy) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if p.allowAll {
+		return true
+	}
+	for _, pattern := range p.cfg.AllowedOrigins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin matches origin case-insensitively against pattern, whose * may not span
+// the scheme separator, a port or a path
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+	if len(origin) < len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	middle := origin[len(prefix) : len(origin)-len(suffix)]
+	return middle != "" && !strings.ContainsAny(middle, "/:")
+}
+
+// CheckOrigin is the websocket upgrader's origin check under the policy. Same-origin
+// requests and clients that send no Origin, which are not browsers, are always allowed
+func (p *Policy) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.AllowsOrigin(origin)
+}
+
+// Middleware answers preflight requests and adds the CORS headers to the responses of
+// allowed origins. It must run before authentication, as browsers never send
+// credentials with a preflight request
+func (p *Policy) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.Request.Header.Get("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+		if !p.AllowsOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			// the browser blocks the response for lack of CORS headers
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		if p.allowAll && !p.cfg.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.cfg.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			if len(p.cfg.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(p.cfg.ExposedHeaders, ", "))
+			}
+			c.Next()
+			return
+		}
+
+		if !p.allowsMethod(c.Request.Header.Get("Access-Control-Request-Method")) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		requested := requestedHeaders(c.Request)
+		for _, h := range requested {
+			if !p.anyHeader && !p.allowedHeaders[http.CanonicalHeaderKey(h)] {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", strings.Join(p.cfg.AllowedMethods, ", "))
+		if len(requested) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if p.cfg.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.cfg.MaxAge/time.Second)))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+func (p *Policy) allowsMethod(method string) bool {
+	for _, allowed := range p.cfg.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, h := range strings.Split(value, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				headers = append(headers, h)
+			}
+		}
+	}
+	return headers
+}
@@ -0,0 +1,113 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/cors"
+	"github.com/gin-gonic/gin"
+)
+
+func serve(policy *cors.Policy, req *http.Request) *httptest.ResponseRecorder {
+	app := gin.New()
+	app.Use(policy.Middleware())
+	app.POST("/unary", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func Test_Policy(t *testing.T) {
+	policy := cors.New(cors.Config{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.preview.example.com"},
+		ExposedHeaders:   []string{"Retry-After"},
+		AllowCredentials: true,
+		MaxAge:           time.Minute,
+	})
+
+	t.Run("matches origins with wildcards", func(t *testing.T) {
+		for origin, expected := range map[string]bool{
+			"https://app.example.com":               true,
+			"https://APP.example.com":               true,
+			"https://pr-1.preview.example.com":      true,
+			"https://preview.example.com":           false,
+			"https://evil.com/.preview.example.com": false,
+			"http://app.example.com":                false,
+			"":                                      false,
+		} {
+			if policy.AllowsOrigin(origin) != expected {
+				t.Fatalf("Expected AllowsOrigin(%q) to be %v\n", origin, expected)
+			}
+		}
+	})
+
+	t.Run("answers preflight requests of allowed origins", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodOptions, "/unary", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type, authorization")
+		w := serve(policy, req)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusNoContent, w.Code)
+		}
+		expected := map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, POST, HEAD",
+			"Access-Control-Allow-Headers":     "content-type, authorization",
+			"Access-Control-Max-Age":           "60",
+		}
+		for header, value := range expected {
+			if got := w.Header().Get(header); got != value {
+				t.Fatalf("Expected %s %q, got %q\n", header, value, got)
+			}
+		}
+	})
+
+	t.Run("refuses preflight requests for headers that are not allowed", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodOptions, "/unary", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "x-custom")
+		if w := serve(policy, req); w.Code != http.StatusForbidden {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusForbidden, w.Code)
+		}
+	})
+
+	t.Run("adds cors headers to requests of allowed origins only", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/unary", nil)
+		req.Header.Set("Origin", "https://pr-1.preview.example.com")
+		w := serve(policy, req)
+		if w.Header().Get("Access-Control-Allow-Origin") != "https://pr-1.preview.example.com" {
+			t.Fatalf("Expected the origin to be allowed, got %q\n", w.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if w.Header().Get("Access-Control-Expose-Headers") != "Retry-After" {
+			t.Fatalf("Expected Retry-After to be exposed, got %q\n", w.Header().Get("Access-Control-Expose-Headers"))
+		}
+
+		req.Header.Set("Origin", "https://evil.com")
+		w = serve(policy, req)
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Fatalf("Expected no cors headers, got %q\n", w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+
+	t.Run("checks websocket origins under the same policy", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "http://proxy.example.com/stream", nil)
+		for origin, expected := range map[string]bool{
+			"":                         true,
+			"http://proxy.example.com": true,
+			"https://app.example.com":  true,
+			"https://evil.com":         false,
+		} {
+			req.Header.Set("Origin", origin)
+			if policy.CheckOrigin(req) != expected {
+				t.Fatalf("Expected CheckOrigin with origin %q to be %v\n", origin, expected)
+			}
+		}
+	})
+}
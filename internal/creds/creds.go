@@ -0,0 +1,145 @@
+// Package creds provides the per-call credentials the proxy attaches to backend calls
+package creds
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// refreshBefore is how long before expiry a token is replaced
+	refreshBefore = time.Minute
+	// minRetry and maxRetry bound the backoff after a failed refresh
+	minRetry = time.Second
+	maxRetry = time.Minute
+)
+
+// Refreshing is a credentials.PerRPCCredentials sending the token of a TokenSource as
+// a bearer token. Tokens are refreshed in the background ahead of their expiry, so
+// calls never wait for the token endpoint except for the very first token
+type Refreshing struct {
+	source      TokenSource
+	requireTLS  bool
+	stop        chan struct{}
+	stopOnce    sync.Once
+	mu          sync.Mutex
+	token       Token
+	fetched     bool
+	refreshOnce sync.Once
+}
+
+// NewRefreshing returns credentials for source. requireTLS refuses to send the token
+// over connections without transport security, it should only be false for local backends
+func NewRefreshing(source TokenSource, requireTLS bool) *Refreshing {
+	return &Refreshing{
+		source:     source,
+		requireTLS: requireTLS,
+		stop:       make(chan struct{}),
+	}
+}
+
+func (r *Refreshing) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := r.current(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching backend token: %w", err)
+	}
+	return map[string]string{"authorization": "Bearer " + token.Value}, nil
+}
+
+func (r *Refreshing) RequireTransportSecurity() bool {
+	return r.requireTLS
+}
+
+// Close stops the background refresh
+func (r *Refreshing) Close() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+// current returns a valid token, fetching it if there is none yet or it has expired
+// because the background refresh kept failing
+func (r *Refreshing) current(ctx context.Context) (Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fetched && (r.token.Expiry.IsZero() || time.Now().Before(r.token.Expiry)) {
+		return r.token, nil
+	}
+	token, err := r.source.Token(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+	r.token, r.fetched = token, true
+	r.refreshOnce.Do(func() { go r.refreshLoop() })
+	return token, nil
+}
+
+// refreshLoop replaces the token ahead of its expiry until Close is called
+func (r *Refreshing) refreshLoop() {
+	retry := minRetry
+	for {
+		r.mu.Lock()
+		expiry := r.token.Expiry
+		r.mu.Unlock()
+		if expiry.IsZero() {
+			// tokens that never expire never need refreshing
+			return
+		}
+		wait := time.Until(expiry) - refreshBefore
+		if lifetime := time.Until(expiry); wait < lifetime/5 {
+			// short lived tokens are refreshed once 80% of their lifetime has passed
+			wait = lifetime * 4 / 5
+		}
+		select {
+		case <-time.After(wait):
+		case <-r.stop:
+			return
+		}
+		for {
+			token, err := r.source.Token(context.Background())
+			if err == nil {
+				r.mu.Lock()
+				r.token = token
+				r.mu.Unlock()
+				retry = minRetry
+				break
+			}
+			select {
+			case <-time.After(retry):
+			case <-r.stop:
+				return
+			}
+			retry = min(retry*2, maxRetry)
+		}
+	}
+}
+
+type callerTokenKey struct{}
+
+// WithCallerToken returns a copy of ctx carrying the bearer token of the incoming request
+func WithCallerToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, callerTokenKey{}, token)
+}
+
+// Passthrough is a credentials.PerRPCCredentials forwarding the caller's bearer token,
+// see WithCallerToken. Calls without a caller token use Fallback, if set
+type Passthrough struct {
+	Fallback   credentials.PerRPCCredentials
+	RequireTLS bool
+}
+
+func (p Passthrough) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if token, _ := ctx.Value(callerTokenKey{}).(string); token != "" {
+		return map[string]string{"authorization": "Bearer " + token}, nil
+	}
+	if p.Fallback != nil {
+		return p.Fallback.GetRequestMetadata(ctx, uri...)
+	}
+	return nil, nil
+}
+
+func (p Passthrough) RequireTransportSecurity() bool {
+	return p.RequireTLS || (p.Fallback != nil && p.Fallback.RequireTransportSecurity())
+}
@@ -0,0 +1,150 @@
+package creds_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/creds"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type countingSource struct {
+	calls    atomic.Int32
+	lifetime time.Duration
+	err      error
+}
+
+func (s *countingSource) Token(context.Context) (creds.Token, error) {
+	n := s.calls.Add(1)
+	if s.err != nil {
+		return creds.Token{}, s.err
+	}
+	return creds.Token{Value: string(rune('a' + n - 1)), Expiry: time.Now().Add(s.lifetime)}, nil
+}
+
+func Test_Refreshing(t *testing.T) {
+
+	t.Run("sends the token as a bearer token and reuses it", func(t *testing.T) {
+		source := &countingSource{lifetime: time.Hour}
+		r := creds.NewRefreshing(source, true)
+		defer r.Close()
+		for i := 0; i < 3; i++ {
+			md, err := r.GetRequestMetadata(context.Background())
+			if err != nil {
+				t.Fatalf("did not expect error: %v\n", err)
+			}
+			if md["authorization"] != "Bearer a" {
+				t.Fatalf("Expected authorization %q, got %q\n", "Bearer a", md["authorization"])
+			}
+		}
+		if n := source.calls.Load(); n != 1 {
+			t.Fatalf("Expected a single token fetch, got %d\n", n)
+		}
+		if !r.RequireTransportSecurity() {
+			t.Fatalf("Expected transport security to be required\n")
+		}
+	})
+
+	t.Run("refreshes the token in the background before it expires", func(t *testing.T) {
+		source := &countingSource{lifetime: 100 * time.Millisecond}
+		r := creds.NewRefreshing(source, false)
+		defer r.Close()
+		if _, err := r.GetRequestMetadata(context.Background()); err != nil {
+			t.Fatalf("did not expect error: %v\n", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for source.calls.Load() < 3 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := source.calls.Load(); n < 3 {
+			t.Fatalf("Expected the token to be refreshed in the background, got %d fetches\n", n)
+		}
+	})
+
+	t.Run("fails the call when no token can be fetched", func(t *testing.T) {
+		r := creds.NewRefreshing(&countingSource{err: errors.New("boom")}, false)
+		defer r.Close()
+		if _, err := r.GetRequestMetadata(context.Background()); err == nil {
+			t.Fatalf("Expected error\n")
+		}
+	})
+}
+
+func Test_Passthrough(t *testing.T) {
+	fallback := creds.NewRefreshing(creds.StaticToken("service"), false)
+	defer fallback.Close()
+	p := creds.Passthrough{Fallback: fallback}
+
+	t.Run("forwards the caller's token", func(t *testing.T) {
+		md, _ := p.GetRequestMetadata(creds.WithCallerToken(context.Background(), "caller"))
+		if md["authorization"] != "Bearer caller" {
+			t.Fatalf("Expected the caller's token, got %q\n", md["authorization"])
+		}
+	})
+
+	t.Run("falls back without a caller token", func(t *testing.T) {
+		md, _ := p.GetRequestMetadata(context.Background())
+		if md["authorization"] != "Bearer service" {
+			t.Fatalf("Expected the fallback token, got %q\n", md["authorization"])
+		}
+	})
+}
+
+func Test_ClientCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "proxy" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"issued-` + r.FormValue("scope") + `","expires_in":60}`))
+	}))
+	defer server.Close()
+
+	t.Run("fetches a token with the client credentials grant", func(t *testing.T) {
+		cc := &creds.ClientCredentials{TokenURL: server.URL, ClientID: "proxy", ClientSecret: "s3cret", Scopes: []string{"read"}}
+		token, err := cc.Token(context.Background())
+		if err != nil {
+			t.Fatalf("did not expect error: %v\n", err)
+		}
+		if token.Value != "issued-read" {
+			t.Fatalf("Expected token %q, got %q\n", "issued-read", token.Value)
+		}
+		if until := time.Until(token.Expiry); until <= 0 || until > time.Minute {
+			t.Fatalf("Expected the token to expire within a minute, got %v\n", until)
+		}
+	})
+
+	t.Run("fails on rejected client credentials", func(t *testing.T) {
+		cc := &creds.ClientCredentials{TokenURL: server.URL, ClientID: "proxy", ClientSecret: "wrong"}
+		if _, err := cc.Token(context.Background()); err == nil {
+			t.Fatalf("Expected error\n")
+		}
+	})
+}
+
+func Test_SignedJWT(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s := &creds.SignedJWT{Key: key, KeyID: "k1", Issuer: "proxy", Audience: "backend", Lifetime: time.Minute}
+	token, err := s.Token(context.Background())
+	if err != nil {
+		t.Fatalf("did not expect error: %v\n", err)
+	}
+	parsed, err := jwt.Parse(token.Value, func(*jwt.Token) (any, error) { return &key.PublicKey, nil },
+		jwt.WithIssuer("proxy"), jwt.WithAudience("backend"), jwt.WithValidMethods([]string{"ES256"}))
+	if err != nil {
+		t.Fatalf("Expected a valid token, got %v\n", err)
+	}
+	if parsed.Header["kid"] != "k1" {
+		t.Fatalf("Expected kid %q, got %v\n", "k1", parsed.Header["kid"])
+	}
+}
@@ -0,0 +1,170 @@
+package creds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSFiles describes the TLS config of a backend connection in terms of files on disk
+type TLSFiles struct {
+	// CertFile and KeyFile hold the client certificate presented to the backend, both or neither must be set
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// CAFile holds the PEM bundle the backend's certificate is verified against, the system pool is used when empty
+	CAFile string `json:"caFile"`
+	// ServerName overrides the name sent in SNI and verified against the backend's certificate
+	ServerName string `json:"serverName"`
+}
+
+func (f TLSFiles) paths() []string {
+	var paths []string
+	for _, path := range []string{f.CertFile, f.KeyFile, f.CAFile} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// ReloadingTLS is a credentials.TransportCredentials reading its certificates from disk. The files
+// are checked for changes on every handshake and reloaded when rotated, so new connections use the
+// new certificates while established connections, and the streams on them, are left alone
+type ReloadingTLS struct {
+	mu       sync.Mutex
+	files    TLSFiles
+	config   *tls.Config
+	modTimes map[string]time.Time
+	logger   *slog.Logger
+}
+
+// NewReloadingTLS loads the files once, so a broken config is reported up front
+func NewReloadingTLS(files TLSFiles) (*ReloadingTLS, error) {
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, errors.New("certFile and keyFile must be set together")
+	}
+	r := &ReloadingTLS{files: files, logger: slog.Default()}
+	if _, err := r.current(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetLogger sets the logger failed reloads are reported to, slog.Default() is used otherwise
+func (r *ReloadingTLS) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger = logger
+}
+
+// current returns the tls config, reloading it if any of the files changed since it was loaded.
+// If reloading fails, e.g. because only the certificate of a pair has been rotated so far,
+// the previous config is used and the reload is retried on the next handshake
+func (r *ReloadingTLS) current() (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modTimes := map[string]time.Time{}
+	changed := r.config == nil
+	for _, path := range r.files.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			// a file being replaced may briefly not exist
+			if r.config != nil {
+				return r.config, nil
+			}
+			return nil, err
+		}
+		modTimes[path] = info.ModTime()
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return r.config, nil
+	}
+	config, err := loadTLSConfig(r.files)
+	if err != nil {
+		if r.config != nil {
+			r.logger.Warn("keeping previous backend tls config, reloading failed", slog.Any("error", err))
+			return r.config, nil
+		}
+		return nil, err
+	}
+	r.config, r.modTimes = config, modTimes
+	return config, nil
+}
+
+func loadTLSConfig(files TLSFiles) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: files.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if files.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if files.CAFile != "" {
+		b, err := os.ReadFile(files.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", files.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func (r *ReloadingTLS) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	config, err := r.current()
+	if err != nil {
+		return nil, nil, err
+	}
+	return credentials.NewTLS(config).ClientHandshake(ctx, authority, conn)
+}
+
+func (r *ReloadingTLS) ServerHandshake(net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("creds: ReloadingTLS only supports client handshakes")
+}
+
+func (r *ReloadingTLS) Info() credentials.ProtocolInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+		ServerName:       r.files.ServerName,
+	}
+}
+
+func (r *ReloadingTLS) Clone() credentials.TransportCredentials {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &ReloadingTLS{files: r.files, config: r.config, modTimes: r.modTimes, logger: r.logger}
+}
+
+func (r *ReloadingTLS) OverrideServerName(serverName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files.ServerName = serverName
+	// force a reload so the new name is used
+	r.config = nil
+	return nil
+}
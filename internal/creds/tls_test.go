@@ -0,0 +1,186 @@
+package creds_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/creds"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create ca: %v\n", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testCA{cert: cert, key: key}
+}
+
+// issue returns a PEM certificate and key for commonName, valid for the dns name backend.test
+func (ca testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"backend.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v\n", err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, b []byte, modTime time.Time) {
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v\n", path, err)
+	}
+	// rotations within the same second must still be detected
+	os.Chtimes(path, modTime, modTime)
+}
+
+func Test_ReloadingTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "backend", x509.ExtKeyUsageServerAuth)
+	serverPair, _ := tls.X509KeyPair(serverCert, serverKey)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	listener, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v\n", err)
+	}
+	defer listener.Close()
+	clientNames := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err == nil {
+				clientNames <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+			}
+			conn.Close()
+		}
+	}()
+
+	dir := t.TempDir()
+	files := creds.TLSFiles{
+		CertFile:   filepath.Join(dir, "client.crt"),
+		KeyFile:    filepath.Join(dir, "client.key"),
+		CAFile:     filepath.Join(dir, "ca.crt"),
+		ServerName: "backend.test",
+	}
+	now := time.Now()
+	writeFile(t, files.CAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), now)
+	cert, key := ca.issue(t, "proxy-1", x509.ExtKeyUsageClientAuth)
+	writeFile(t, files.CertFile, cert, now)
+	writeFile(t, files.KeyFile, key, now)
+
+	transportCredentials, err := creds.NewReloadingTLS(files)
+	if err != nil {
+		t.Fatalf("did not expect error loading tls files: %v\n", err)
+	}
+	handshake := func(t *testing.T) string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v\n", err)
+		}
+		defer conn.Close()
+		if _, _, err := transportCredentials.ClientHandshake(context.Background(), "ignored:443", conn); err != nil {
+			t.Fatalf("did not expect handshake error: %v\n", err)
+		}
+		select {
+		case name := <-clientNames:
+			return name
+		case <-time.After(time.Second):
+			t.Fatalf("server did not complete the handshake\n")
+			return ""
+		}
+	}
+
+	t.Run("presents the client certificate and verifies the server name", func(t *testing.T) {
+		if name := handshake(t); name != "proxy-1" {
+			t.Fatalf("Expected client certificate %q, got %q\n", "proxy-1", name)
+		}
+	})
+
+	t.Run("picks up rotated certificates", func(t *testing.T) {
+		cert, key := ca.issue(t, "proxy-2", x509.ExtKeyUsageClientAuth)
+		later := now.Add(time.Minute)
+		writeFile(t, files.CertFile, cert, later)
+		writeFile(t, files.KeyFile, key, later)
+		if name := handshake(t); name != "proxy-2" {
+			t.Fatalf("Expected rotated client certificate %q, got %q\n", "proxy-2", name)
+		}
+	})
+
+	t.Run("keeps the previous certificate while a rotation is incomplete", func(t *testing.T) {
+		cert, _ := ca.issue(t, "proxy-3", x509.ExtKeyUsageClientAuth)
+		writeFile(t, files.CertFile, cert, now.Add(2*time.Minute))
+		if name := handshake(t); name != "proxy-2" {
+			t.Fatalf("Expected previous client certificate %q, got %q\n", "proxy-2", name)
+		}
+	})
+
+	t.Run("clones keep the previous certificate while a rotation is incomplete", func(t *testing.T) {
+		clone := transportCredentials.Clone()
+		cert, _ := ca.issue(t, "proxy-4", x509.ExtKeyUsageClientAuth)
+		writeFile(t, files.CertFile, cert, now.Add(3*time.Minute))
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v\n", err)
+		}
+		defer conn.Close()
+		if _, _, err := clone.ClientHandshake(context.Background(), "ignored:443", conn); err != nil {
+			t.Fatalf("did not expect handshake error: %v\n", err)
+		}
+		if name := <-clientNames; name != "proxy-2" {
+			t.Fatalf("Expected previous client certificate %q, got %q\n", "proxy-2", name)
+		}
+	})
+
+	t.Run("refuses a certificate without its key", func(t *testing.T) {
+		if _, err := creds.NewReloadingTLS(creds.TLSFiles{CertFile: files.CertFile}); err == nil {
+			t.Fatalf("Expected error\n")
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package creds
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token is an access token and the time it stops being valid, a zero Expiry never expires
+type Token struct {
+	Value  string
+	Expiry time.Time
+}
+
+// TokenSource produces access tokens for calls to a backend
+type TokenSource interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// StaticToken is a TokenSource always returning the same token, e.g. a service token
+type StaticToken string
+
+func (s StaticToken) Token(context.Context) (Token, error) {
+	return Token{Value: string(s)}, nil
+}
+
+// ClientCredentials fetches tokens from an OAuth2 token endpoint with the client credentials grant
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Audience is sent as the audience parameter, which some providers require
+	Audience   string
+	HTTPClient *http.Client
+}
+
+func (cc *ClientCredentials) Token(ctx context.Context) (Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.Scopes) > 0 {
+		form.Set("scope", strings.Join(cc.Scopes, " "))
+	}
+	if cc.Audience != "" {
+		form.Set("audience", cc.Audience)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cc.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(cc.ClientID), url.QueryEscape(cc.ClientSecret))
+
+	client := cc.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Token{}, fmt.Errorf("token endpoint responded with %s", resp.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Token{}, fmt.Errorf("decoding token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return Token{}, errors.New("token response has no access_token")
+	}
+	token := Token{Value: body.AccessToken}
+	if body.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// SignedJWT mints short lived JWTs signed with a private key, for backends that trust the proxy's key
+type SignedJWT struct {
+	Key      crypto.Signer
+	KeyID    string
+	Issuer   string
+	Subject  string
+	Audience string
+	// Lifetime of every token. Defaults to 5 minutes
+	Lifetime time.Duration
+}
+
+func (s *SignedJWT) Token(context.Context) (Token, error) {
+	lifetime := s.Lifetime
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    s.Issuer,
+		Subject:   s.Subject,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+	}
+	if s.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.Audience}
+	}
+	method, err := signingMethod(s.Key)
+	if err != nil {
+		return Token{}, err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	if s.KeyID != "" {
+		token.Header["kid"] = s.KeyID
+	}
+	signed, err := token.SignedString(s.Key)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{Value: signed, Expiry: now.Add(lifetime)}, nil
+}
+
+func signingMethod(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jwt.SigningMethodES256, nil
+		case 384:
+			return jwt.SigningMethodES384, nil
+		case 521:
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing key %T", key)
+	}
+}
+
+// LoadSigner reads a PEM encoded PKCS8, PKCS1 or EC private key
+func LoadSigner(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%T cannot sign", key)
+	}
+	return signer, nil
+}
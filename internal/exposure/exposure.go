@@ -0,0 +1,51 @@
+// Package exposure decides which grpc methods the proxy exposes over http
+package exposure
+
+import (
+	"fmt"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+)
+
+// Rules allow or deny methods by glob patterns on their fully qualified names. A method is
+// exposed if it matches an Allow pattern, or Allow is empty, and matches no Deny pattern
+type Rules struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Policy is a compiled set of Rules
+type Policy struct {
+	allow []glob.Pattern
+	deny  []glob.Pattern
+}
+
+func New(rules Rules) (*Policy, error) {
+	allow, err := glob.CompileAll(rules.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	deny, err := glob.CompileAll(rules.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+	return &Policy{allow: allow, deny: deny}, nil
+}
+
+// Decide reports whether the method is exposed and the reason why, for the startup report
+func (p *Policy) Decide(fullMethodName string) (exposed bool, reason string) {
+	for _, pattern := range p.deny {
+		if pattern.Match(fullMethodName) {
+			return false, fmt.Sprintf("denied by %s", pattern)
+		}
+	}
+	if len(p.allow) == 0 {
+		return true, "no allowlist"
+	}
+	for _, pattern := range p.allow {
+		if pattern.Match(fullMethodName) {
+			return true, fmt.Sprintf("allowed by %s", pattern)
+		}
+	}
+	return false, "not in allowlist"
+}
@@ -0,0 +1,69 @@
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Pattern matches fully qualified grpc method names such as /pkg.Service/Method.
+// A * matches any run of characters except /, a ** matches any run of characters
+// and a ? matches a single character other than /
+type Pattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+func Compile(pattern string) (Pattern, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			sb.WriteString("[^/]*")
+		case pattern[i] == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return Pattern{}, err
+	}
+	return Pattern{raw: pattern, re: re}, nil
+}
+
+func (p Pattern) Match(name string) bool {
+	return p.re.MatchString(name)
+}
+
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// CompileAll compiles every pattern, failing on the first invalid one
+func CompileAll(patterns []string) ([]Pattern, error) {
+	compiled := make([]Pattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+// MatchAny reports whether name matches at least one of the patterns
+func MatchAny(patterns []Pattern, name string) bool {
+	for _, p := range patterns {
+		if p.Match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+)
+
+func Test_Match(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"/pkg.Service/Method", "/pkg.Service/Method", true},
+		{"/pkg.Service/*", "/pkg.Service/Method", true},
+		{"/pkg.Service/Get*", "/pkg.Service/Delete", false},
+		{"*", "/pkg.Service/Method", false},
+		{"**", "/pkg.Service/Method", true},
+		{"/pkg.*/Method", "/pkg.Admin/Method", true},
+		{"/pkg.Service/Metho?", "/pkg.Service/Method", true},
+		{"/pkg.Service/Method", "/pkg.Service/MethodTwo", false},
+	}
+	for _, tc := range cases {
+		p, err := glob.Compile(tc.pattern)
+		if err != nil {
+			t.Fatalf("failed to compile %s: %v\n", tc.pattern, err)
+		}
+		if p.Match(tc.name) != tc.matches {
+			t.Fatalf("expected %s matching %s to be %v\n", tc.pattern, tc.name, tc.matches)
+		}
+	}
+}
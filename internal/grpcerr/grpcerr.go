@@ -0,0 +1,129 @@
+package grpcerr
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// CloseUnauthorized and CloseForbidden are the websocket close codes matching
+	// http 401 and 403, in the range reserved for applications
+	CloseUnauthorized = 4401
+	CloseForbidden    = 4403
+)
+
+// CloseCode maps the grpc code of err to the websocket close code a stream is refused with
+func CloseCode(err error) int {
+	switch status.Code(err) {
+	case codes.Unauthenticated:
+		return CloseUnauthorized
+	case codes.PermissionDenied:
+		return CloseForbidden
+	case codes.ResourceExhausted, codes.Unavailable:
+		return websocket.CloseTryAgainLater
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return websocket.ClosePolicyViolation
+	default:
+		return websocket.CloseInternalServerErr
+	}
+}
+
+// HTTPStatus maps the grpc code of err to the closest http status,
+// errors that carry no grpc status map to 500 like codes.Unknown
+func HTTPStatus(err error) int {
+	switch status.Code(err) {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CodeFromHTTPStatus is the inverse of HTTPStatus, for responses that were not produced from a grpc status
+func CodeFromHTTPStatus(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case 499:
+		return codes.Canceled
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	switch {
+	case httpStatus < 400:
+		return codes.OK
+	case httpStatus < 500:
+		return codes.InvalidArgument
+	default:
+		return codes.Unknown
+	}
+}
+
+// StatusJSON renders the grpc status of err as json, as a google.rpc.Status with its details,
+// for statuses carrying details such as a google.rpc.BadRequest. It returns nil otherwise
+func StatusJSON(err error) []byte {
+	st, ok := status.FromError(err)
+	if !ok || len(st.Proto().GetDetails()) == 0 {
+		return nil
+	}
+	body, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+// codeNames are the canonical names of the grpc codes, indexed by code
+var codeNames = [...]string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// CodeName returns the canonical name of code, e.g. PERMISSION_DENIED, as the audit log,
+// access log and capture write it
+func CodeName(code codes.Code) string {
+	if int(code) < len(codeNames) {
+		return codeNames[code]
+	}
+	return code.String()
+}
@@ -0,0 +1,19 @@
+package grpcerr_test
+
+import (
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCodeName(t *testing.T) {
+	t.Run("names codes canonically", func(t *testing.T) {
+		if name := grpcerr.CodeName(codes.PermissionDenied); name != "PERMISSION_DENIED" {
+			t.Fatalf("expected PERMISSION_DENIED, got %s\n", name)
+		}
+		if name := grpcerr.CodeName(codes.Canceled); name != "CANCELLED" {
+			t.Fatalf("expected CANCELLED, got %s\n", name)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package intercept
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
+)
+
+// Direction is the way a stream message travels
+type Direction int
+
+const (
+	// Inbound messages go from the client to the backend. The request is the only inbound
+	// message of a server stream, the proxy has no client or bidirectional streaming paths
+	Inbound Direction = iota
+	// Outbound messages go from the backend to the client
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// Interceptor plugs custom logic into the stages of a call, nil funcs are skipped. Messages
+// are mutated in place, and a func returning an error rejects the call, or ends the stream,
+// with the http status and websocket close code matching its grpc status
+type Interceptor struct {
+	// BeforeDecode is called before the request body or query is decoded, it may replace
+	// c.Request, e.g. to rewrite the body
+	BeforeDecode func(c *gin.Context) error
+	// AfterDecode is called with the decoded request before it is validated and authorized
+	AfterDecode func(c *gin.Context, request proto.Message) error
+	// BeforeCall is called with the request once it has been accepted, right before the
+	// backend is called with the context of c.Request, which it may replace, e.g. to add
+	// outgoing metadata
+	BeforeCall func(c *gin.Context, request proto.Message) error
+	// Response is called with the response of a successful unary call before it is encoded
+	Response func(c *gin.Context, response proto.Message) error
+	// StreamMessage is called with every message of a stream and its position in its direction
+	// starting at 1: the request before the stream is opened and every message of the backend
+	// before it is encoded. Outbound messages are reused once it returns, so they must not be
+	// kept, and it may be called from a goroutine of its own
+	StreamMessage func(c *gin.Context, direction Direction, n int64, message proto.Message) error
+	// StreamClose is called once a stream that was opened has ended, with the error it ended
+	// with, nil when the backend ended it, and how long it lasted
+	StreamClose func(c *gin.Context, request proto.Message, err error, elapsed time.Duration)
+}
+
+// Chain runs interceptors in order, a stage stops at the first one that fails
+type Chain []Interceptor
+
+func (ch Chain) BeforeDecode(c *gin.Context) error {
+	for _, i := range ch {
+		if i.BeforeDecode != nil {
+			if err := i.BeforeDecode(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ch Chain) AfterDecode(c *gin.Context, request proto.Message) error {
+	for _, i := range ch {
+		if i.AfterDecode != nil {
+			if err := i.AfterDecode(c, request); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ch Chain) BeforeCall(c *gin.Context, request proto.Message) error {
+	for _, i := range ch {
+		if i.BeforeCall != nil {
+			if err := i.BeforeCall(c, request); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ch Chain) Response(c *gin.Context, response proto.Message) error {
+	for _, i := range ch {
+		if i.Response != nil {
+			if err := i.Response(c, response); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ch Chain) StreamMessage(c *gin.Context, direction Direction, n int64, message proto.Message) error {
+	for _, i := range ch {
+		if i.StreamMessage != nil {
+			if err := i.StreamMessage(c, direction, n, message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StreamClose calls every interceptor, closing cannot be rejected
+func (ch Chain) StreamClose(c *gin.Context, request proto.Message, err error, elapsed time.Duration) {
+	for _, i := range ch {
+		if i.StreamClose != nil {
+			i.StreamClose(c, request, err, elapsed)
+		}
+	}
+}
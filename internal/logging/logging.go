@@ -0,0 +1,145 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader carries the id of a request, it is taken from the request when the
+// client sets it and generated otherwise, and is always echoed in the response
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestID bounds the length of request ids accepted from clients
+const maxRequestID = 128
+
+// Config selects the level and format of a logger
+type Config struct {
+	// Level is one of debug, info, warn or error, info by default
+	Level string `json:"level"`
+	// Format is json or text, text by default
+	Format string `json:"format"`
+}
+
+// New returns a logger writing to w as described by cfg
+func New(cfg Config, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("log level %q: %w", cfg.Level, err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("log format %q: must be json or text", cfg.Format)
+	}
+}
+
+type loggerKey struct{}
+
+// NewContext returns ctx carrying logger, see FromContext
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of the request, or slog.Default() if it has none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With adds attrs to the logger of the request
+func With(c *gin.Context, args ...any) {
+	ctx := c.Request.Context()
+	c.Request = c.Request.WithContext(NewContext(ctx, FromContext(ctx).With(args...)))
+}
+
+// Middleware gives every request a logger derived from base that carries its request id
+// and remote address
+func Middleware(base *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		logger := base.With(slog.String("request_id", id), slog.String("remote_addr", c.ClientIP()))
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), logger))
+		c.Next()
+	}
+}
+
+// Method adds the fully qualified grpc method of the route to the logger of its requests
+func Method(fullMethodName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		With(c, slog.String("method", fullMethodName))
+		c.Next()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Classify tells whose fault err is: "client" for calls the caller got wrong or gave up on,
+// "backend" for failures of the backend or the proxy, and "" for successful calls
+func Classify(err error) string {
+	switch status.Code(err) {
+	case codes.OK:
+		return ""
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.FailedPrecondition,
+		codes.OutOfRange, codes.ResourceExhausted, codes.Aborted:
+		return "client"
+	default:
+		return "backend"
+	}
+}
+
+// Outcome logs how a call ended, at info level when it succeeded or the client gave up,
+// warn when the client was otherwise at fault and error otherwise. err should carry a grpc status
+func Outcome(ctx context.Context, logger *slog.Logger, msg string, err error, elapsed time.Duration, args ...any) {
+	level := slog.LevelInfo
+	args = append(args, slog.String("code", status.Code(err).String()), slog.Duration("duration", elapsed))
+	if class := Classify(err); class != "" {
+		args = append(args, slog.String("error_class", class), slog.String("error", status.Convert(err).Message()))
+		switch {
+		case status.Code(err) == codes.Canceled:
+		case class == "client":
+			level = slog.LevelWarn
+		default:
+			level = slog.LevelError
+		}
+	}
+	logger.Log(ctx, level, msg, args...)
+}
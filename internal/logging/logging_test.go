@@ -0,0 +1,124 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("writes json at the configured level", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := logging.New(logging.Config{Level: "warn", Format: "json"}, &buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		logger.Info("dropped")
+		logger.Warn("kept", "key", "value")
+		var event map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+			t.Fatalf("expected a single json event, got %q: %v\n", buf.String(), err)
+		}
+		if event["msg"] != "kept" || event["key"] != "value" {
+			t.Fatalf("unexpected event %v\n", event)
+		}
+	})
+
+	t.Run("rejects unknown levels and formats", func(t *testing.T) {
+		if _, err := logging.New(logging.Config{Level: "loud"}, &bytes.Buffer{}); err == nil {
+			t.Fatalf("expected an error for an unknown level\n")
+		}
+		if _, err := logging.New(logging.Config{Format: "xml"}, &bytes.Buffer{}); err == nil {
+			t.Fatalf("expected an error for an unknown format\n")
+		}
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	serve := func(t *testing.T, requestID string) (map[string]any, *httptest.ResponseRecorder) {
+		var buf bytes.Buffer
+		logger, _ := logging.New(logging.Config{Format: "json"}, &buf)
+		gin.SetMode(gin.TestMode)
+		app := gin.New()
+		app.Use(logging.Middleware(logger))
+		app.GET("/", logging.Method("/pkg.S/M"), func(c *gin.Context) {
+			logging.FromContext(c.Request.Context()).Info("handled")
+		})
+		req, _ := http.NewRequest("GET", "/", nil)
+		if requestID != "" {
+			req.Header.Set(logging.RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		var event map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+			t.Fatalf("expected a json event, got %q: %v\n", buf.String(), err)
+		}
+		return event, w
+	}
+
+	t.Run("tags events with the request id, remote address and method", func(t *testing.T) {
+		event, w := serve(t, "abc-123")
+		if event["request_id"] != "abc-123" || event["method"] != "/pkg.S/M" {
+			t.Fatalf("unexpected event %v\n", event)
+		}
+		if _, ok := event["remote_addr"]; !ok {
+			t.Fatalf("expected a remote_addr in %v\n", event)
+		}
+		if got := w.Header().Get(logging.RequestIDHeader); got != "abc-123" {
+			t.Fatalf("expected the request id to be echoed, got %q\n", got)
+		}
+	})
+
+	t.Run("generates request ids when missing or invalid", func(t *testing.T) {
+		event, w := serve(t, "bad id\n")
+		id, _ := event["request_id"].(string)
+		if len(id) != 32 || strings.Contains(id, " ") {
+			t.Fatalf("expected a generated request id, got %q\n", id)
+		}
+		if w.Header().Get(logging.RequestIDHeader) != id {
+			t.Fatalf("expected the generated id to be echoed\n")
+		}
+	})
+}
+
+func TestOutcome(t *testing.T) {
+	cases := []struct {
+		err   error
+		level string
+		class string
+	}{
+		{nil, "INFO", ""},
+		{status.Error(codes.Canceled, "client left"), "INFO", "client"},
+		{status.Error(codes.PermissionDenied, "denied"), "WARN", "client"},
+		{status.Error(codes.Unavailable, "down"), "ERROR", "backend"},
+		{errors.New("no status"), "ERROR", "backend"},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		logger, _ := logging.New(logging.Config{Format: "json"}, &buf)
+		logging.Outcome(context.Background(), logger, "unary call", tc.err, 1500*time.Millisecond)
+		var event map[string]any
+		json.Unmarshal(buf.Bytes(), &event)
+		if event["level"] != tc.level || event["code"] != status.Code(tc.err).String() {
+			t.Fatalf("expected %s with code %s for %v, got %v\n", tc.level, status.Code(tc.err), tc.err, event)
+		}
+		if class, _ := event["error_class"].(string); class != tc.class {
+			t.Fatalf("expected error class %q for %v, got %q\n", tc.class, tc.err, class)
+		}
+		if _, ok := event["duration"]; !ok {
+			t.Fatalf("expected a duration in %v\n", event)
+		}
+	}
+}
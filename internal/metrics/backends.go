@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/connectivity"
+)
+
+// BackendConn is the state of the connection to a backend
+type BackendConn struct {
+	Name   string
+	Target string
+	State  connectivity.State
+}
+
+var connStates = []connectivity.State{
+	connectivity.Idle,
+	connectivity.Connecting,
+	connectivity.Ready,
+	connectivity.TransientFailure,
+	connectivity.Shutdown,
+}
+
+type backendCollector struct {
+	desc  *prometheus.Desc
+	conns func() []BackendConn
+}
+
+// NewBackendCollector reports the state of the connections listed by conns at every scrape,
+// as one series per state whose value is 1 for the current state and 0 otherwise
+func NewBackendCollector(conns func() []BackendConn) prometheus.Collector {
+	return &backendCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "backend_connection_state"),
+			"State of the connection to each backend, 1 for the current state.",
+			[]string{"backend", "target", "state"}, nil,
+		),
+		conns: conns,
+	}
+}
+
+func (bc *backendCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bc.desc
+}
+
+func (bc *backendCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, conn := range bc.conns() {
+		for _, state := range connStates {
+			value := 0.0
+			if state == conn.State {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(bc.desc, prometheus.GaugeValue, value, conn.Name, conn.Target, state.String())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"github.com/TylerJGabb/grpc-http-proxy/internal/breaker"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BreakerState is the state of the circuit breaker of a backend method
+type BreakerState struct {
+	Backend string
+	Method  string
+	// State is the name of the state, see breaker.State
+	State string
+}
+
+var breakerStates = []breaker.State{breaker.Closed, breaker.Open, breaker.HalfOpen}
+
+type breakerCollector struct {
+	desc     *prometheus.Desc
+	breakers func() []BreakerState
+}
+
+// NewBreakerCollector reports the state of the circuit breakers listed by breakers at every
+// scrape, as one series per state whose value is 1 for the current state and 0 otherwise
+func NewBreakerCollector(breakers func() []BreakerState) prometheus.Collector {
+	return &breakerCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "circuit_breaker_state"),
+			"State of the circuit breaker of each backend method, 1 for the current state.",
+			[]string{"backend", "method", "state"}, nil,
+		),
+		breakers: breakers,
+	}
+}
+
+func (bc *breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bc.desc
+}
+
+func (bc *breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, b := range bc.breakers() {
+		for _, state := range breakerStates {
+			value := 0.0
+			if state.String() == b.State {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(bc.desc, prometheus.GaugeValue, value, b.Backend, b.Method, state.String())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "grpc_http_proxy"
+
+// codeKey holds the grpc code a call ended with in its gin context, see SetCode
+const codeKey = "metrics.code"
+
+const (
+	// DirectionInbound is a message from the http client to the backend,
+	// DirectionOutbound one from the backend to the http client
+	DirectionInbound  = "inbound"
+	DirectionOutbound = "outbound"
+)
+
+// Metrics holds the collectors of the proxy
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests      *prometheus.CounterVec
+	unaryDuration *prometheus.HistogramVec
+	firstMessage  *prometheus.HistogramVec
+	activeStreams *prometheus.GaugeVec
+	messages      *prometheus.CounterVec
+	messageBytes  *prometheus.HistogramVec
+}
+
+// New registers the collectors of the proxy with registry
+func New(registry *prometheus.Registry) (*Metrics, error) {
+	m := &Metrics{
+		registry: registry,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Requests handled, by route, grpc method, http status and grpc code.",
+		}, []string{"route", "method", "http_status", "grpc_code"}),
+		unaryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "unary_duration_seconds",
+			Help:      "Latency of unary calls, from reading the request to writing the response.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		firstMessage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "stream_first_message_seconds",
+			Help:      "Time from opening a server stream to forwarding its first message.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		activeStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_streams",
+			Help:      "Server streams currently open, by transport.",
+		}, []string{"route", "method", "transport"}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_total",
+			Help:      "Messages forwarded, inbound from http clients or outbound from backends.",
+		}, []string{"route", "method", "direction"}),
+		messageBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "message_size_bytes",
+			Help:      "Size of the http payloads of forwarded messages.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"route", "method", "direction"}),
+	}
+	for _, c := range []prometheus.Collector{m.requests, m.unaryDuration, m.firstMessage, m.activeStreams, m.messages, m.messageBytes} {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Register adds a custom collector to the registry the metrics are served from
+func (m *Metrics) Register(c prometheus.Collector) error {
+	return m.registry.Register(c)
+}
+
+// Handler serves the metrics in the prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// SetCode records the grpc code a call ended with for the request counter
+func SetCode(c *gin.Context, err error) {
+	c.Set(codeKey, status.Code(err))
+}
+
+// Middleware counts every request to a route of a grpc method, methodOf returns the method
+// of a route or "" for routes that are not proxied, e.g. the metrics endpoint itself. Calls
+// that ended before a code was set, e.g. because they were rejected, are counted with the
+// code matching their http status
+func (m *Metrics) Middleware(methodOf func(httpMethod, route string) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		route := c.FullPath()
+		method := methodOf(c.Request.Method, route)
+		if method == "" {
+			return
+		}
+		httpStatus := c.Writer.Status()
+		code, ok := c.Value(codeKey).(codes.Code)
+		if !ok {
+			codeStatus := httpStatus
+			if rejected, ok := serverstream.RejectedStatus(c); ok {
+				codeStatus = rejected
+			}
+			code = grpcerr.CodeFromHTTPStatus(codeStatus)
+		}
+		m.requests.WithLabelValues(route, method, strconv.Itoa(httpStatus), code.String()).Inc()
+	}
+}
+
+// Route records the metrics of the calls of a single route
+type Route struct {
+	m             *Metrics
+	route, method string
+}
+
+func (m *Metrics) Route(route, method string) *Route {
+	return &Route{m: m, route: route, method: method}
+}
+
+// Message records a forwarded message of size bytes
+func (r *Route) Message(direction string, size int) {
+	r.m.messages.WithLabelValues(r.route, r.method, direction).Inc()
+	r.m.messageBytes.WithLabelValues(r.route, r.method, direction).Observe(float64(size))
+}
+
+// UnaryDone records the latency of a unary call
+func (r *Route) UnaryDone(elapsed time.Duration) {
+	r.m.unaryDuration.WithLabelValues(r.route, r.method).Observe(elapsed.Seconds())
+}
+
+// FirstMessage records the time a stream took to forward its first message
+func (r *Route) FirstMessage(sinceOpen time.Duration) {
+	r.m.firstMessage.WithLabelValues(r.route, r.method).Observe(sinceOpen.Seconds())
+}
+
+// StreamOpened and StreamClosed track the streams that are open
+func (r *Route) StreamOpened(transport string) {
+	r.m.activeStreams.WithLabelValues(r.route, r.method, transport).Inc()
+}
+
+func (r *Route) StreamClosed(transport string) {
+	r.m.activeStreams.WithLabelValues(r.route, r.method, transport).Dec()
+}
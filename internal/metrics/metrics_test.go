@@ -0,0 +1,129 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/breaker"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+func newMetrics(t *testing.T) (*metrics.Metrics, *prometheus.Registry) {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n", err)
+	}
+	return m, registry
+}
+
+func TestMiddleware(t *testing.T) {
+	m, registry := newMetrics(t)
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.Use(m.Middleware(func(httpMethod, route string) string {
+		if route == "/call" {
+			return "/pkg.S/M"
+		}
+		return ""
+	}))
+	app.GET("/call", func(c *gin.Context) {
+		if c.Query("fail") != "" {
+			err := status.Error(codes.Unavailable, "down")
+			metrics.SetCode(c, err)
+			c.String(http.StatusServiceUnavailable, "down")
+			return
+		}
+		if c.Query("reject") != "" {
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		metrics.SetCode(c, nil)
+	})
+	app.GET("/other", func(c *gin.Context) {})
+	for _, path := range []string{"/call", "/call?fail=1", "/call?reject=1", "/other"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		app.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := `
+# HELP grpc_http_proxy_requests_total Requests handled, by route, grpc method, http status and grpc code.
+# TYPE grpc_http_proxy_requests_total counter
+grpc_http_proxy_requests_total{grpc_code="OK",http_status="200",method="/pkg.S/M",route="/call"} 1
+grpc_http_proxy_requests_total{grpc_code="ResourceExhausted",http_status="429",method="/pkg.S/M",route="/call"} 1
+grpc_http_proxy_requests_total{grpc_code="Unavailable",http_status="503",method="/pkg.S/M",route="/call"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "grpc_http_proxy_requests_total"); err != nil {
+		t.Fatalf("unexpected request counts: %v\n", err)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	m, registry := newMetrics(t)
+	route := m.Route("/stream", "/pkg.S/Stream")
+	route.StreamOpened("websocket")
+	route.StreamOpened("websocket")
+	route.StreamClosed("websocket")
+	route.FirstMessage(250 * time.Millisecond)
+	route.Message(metrics.DirectionOutbound, 100)
+	route.Message(metrics.DirectionOutbound, 5000)
+
+	expected := `
+# HELP grpc_http_proxy_active_streams Server streams currently open, by transport.
+# TYPE grpc_http_proxy_active_streams gauge
+grpc_http_proxy_active_streams{method="/pkg.S/Stream",route="/stream",transport="websocket"} 1
+# HELP grpc_http_proxy_messages_total Messages forwarded, inbound from http clients or outbound from backends.
+# TYPE grpc_http_proxy_messages_total counter
+grpc_http_proxy_messages_total{direction="outbound",method="/pkg.S/Stream",route="/stream"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "grpc_http_proxy_active_streams", "grpc_http_proxy_messages_total"); err != nil {
+		t.Fatalf("unexpected stream metrics: %v\n", err)
+	}
+	if count := testutil.CollectAndCount(registry, "grpc_http_proxy_stream_first_message_seconds"); count != 1 {
+		t.Fatalf("expected a first message histogram, got %d series\n", count)
+	}
+}
+
+func TestBackendCollector(t *testing.T) {
+	collector := metrics.NewBackendCollector(func() []metrics.BackendConn {
+		return []metrics.BackendConn{{Name: "default", Target: "localhost:9091", State: connectivity.Ready}}
+	})
+	expected := `
+# HELP grpc_http_proxy_backend_connection_state State of the connection to each backend, 1 for the current state.
+# TYPE grpc_http_proxy_backend_connection_state gauge
+grpc_http_proxy_backend_connection_state{backend="default",state="CONNECTING",target="localhost:9091"} 0
+grpc_http_proxy_backend_connection_state{backend="default",state="IDLE",target="localhost:9091"} 0
+grpc_http_proxy_backend_connection_state{backend="default",state="READY",target="localhost:9091"} 1
+grpc_http_proxy_backend_connection_state{backend="default",state="SHUTDOWN",target="localhost:9091"} 0
+grpc_http_proxy_backend_connection_state{backend="default",state="TRANSIENT_FAILURE",target="localhost:9091"} 0
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatalf("unexpected backend states: %v\n", err)
+	}
+}
+
+func TestBreakerCollector(t *testing.T) {
+	collector := metrics.NewBreakerCollector(func() []metrics.BreakerState {
+		return []metrics.BreakerState{{Backend: "localhost:9091", Method: "/pkg.S/Unary", State: breaker.HalfOpen.String()}}
+	})
+	expected := `
+# HELP grpc_http_proxy_circuit_breaker_state State of the circuit breaker of each backend method, 1 for the current state.
+# TYPE grpc_http_proxy_circuit_breaker_state gauge
+grpc_http_proxy_circuit_breaker_state{backend="localhost:9091",method="/pkg.S/Unary",state="closed"} 0
+grpc_http_proxy_circuit_breaker_state{backend="localhost:9091",method="/pkg.S/Unary",state="half-open"} 1
+grpc_http_proxy_circuit_breaker_state{backend="localhost:9091",method="/pkg.S/Unary",state="open"} 0
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatalf("unexpected breaker states: %v\n", err)
+	}
+}
@@ -0,0 +1,446 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/stub"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Config lists the fixture files the mock backend answers from
+type Config struct {
+	// Fixtures are fixture files, or directories whose .json files are fixture files
+	Fixtures []string `json:"fixtures"`
+}
+
+// Fixture describes how a method is answered, a fixture file holds a single fixture.
+// Several fixtures of the same method are tried in the order they were loaded
+type Fixture struct {
+	// Method is the fully qualified method, e.g. /pkg.Service/Method
+	Method string `json:"method"`
+	// Responses are tried in order, the first whose When matches the request answers it
+	Responses []Response `json:"responses"`
+}
+
+// Response is a way of answering a method. Unary methods answer with Response, or fail
+// with Status. Server streams play Stream, then end with Status or EOF without it
+type Response struct {
+	// When matches requests that have the same values as it for every field it sets, as
+	// protojson. Responses without When match every request
+	When map[string]any `json:"when"`
+	// Template executes the strings of Response and of the messages of Stream as
+	// text/template, with the request as protojson in .request, e.g. "hello {{.request.name}}"
+	Template bool `json:"template"`
+	// DelayMs delays the unary response or status
+	DelayMs  int             `json:"delayMs"`
+	Response json.RawMessage `json:"response"`
+	Status   *Status         `json:"status"`
+	Stream   []Step          `json:"stream"`
+}
+
+// Status is a grpc status, its code is a name such as NOT_FOUND or NotFound and its
+// details are protojson with their @type
+type Status struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details"`
+}
+
+// Step is a step of a scripted stream, it sends Message or ends the stream with Status,
+// or without error for EOF, after DelayMs
+type Step struct {
+	DelayMs int             `json:"delayMs"`
+	Message json.RawMessage `json:"message"`
+	Status  *Status         `json:"status"`
+	EOF     bool            `json:"eof"`
+}
+
+// response is a Response ready to answer calls
+type response struct {
+	when    map[string]any
+	delay   time.Duration
+	message *body
+	status  *status.Status
+	steps   []step
+}
+
+type step struct {
+	delay   time.Duration
+	message *body
+	// status ends the stream, with an error unless it is OK
+	status *status.Status
+}
+
+// Server is an in-process grpc server answering calls from fixtures
+type Server struct {
+	*stub.Server
+	responses map[string][]response
+}
+
+// New loads the fixtures of cfg and starts serving them, see stub.Server.Dial
+func New(cfg Config) (*Server, error) {
+	paths, err := fixturePaths(cfg.Fixtures)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("mock needs at least one fixture file")
+	}
+	s := &Server{responses: map[string][]response{}}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var fixture Fixture
+		if err := json.Unmarshal(content, &fixture); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		responses, err := compile(fixture)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		s.responses[fixture.Method] = append(s.responses[fixture.Method], responses...)
+	}
+	s.Server = stub.Serve(s.handle)
+	return s, nil
+}
+
+// Methods returns the number of responses of every mocked method
+func (s *Server) Methods() map[string]int {
+	methods := make(map[string]int, len(s.responses))
+	for method, responses := range s.responses {
+		methods[method] = len(responses)
+	}
+	return methods
+}
+
+// fixturePaths expands the directories of paths to the .json files in them
+func fixturePaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// compile checks the responses of fixture against its method
+func compile(fixture Fixture) ([]response, error) {
+	method, err := stub.FindMethod(fixture.Method)
+	if err != nil {
+		return nil, err
+	}
+	if len(fixture.Responses) == 0 {
+		return nil, fmt.Errorf("%s has no responses", fixture.Method)
+	}
+	responses := make([]response, 0, len(fixture.Responses))
+	for i, r := range fixture.Responses {
+		compiled, err := compileResponse(method, r)
+		if err != nil {
+			return nil, fmt.Errorf("response %d of %s: %w", i+1, fixture.Method, err)
+		}
+		responses = append(responses, compiled)
+	}
+	return responses, nil
+}
+
+func compileResponse(method protoreflect.MethodDescriptor, r Response) (response, error) {
+	compiled := response{when: r.When, delay: milliseconds(r.DelayMs)}
+	var err error
+	if r.Status != nil {
+		if compiled.status, err = newStatus(*r.Status); err != nil {
+			return response{}, err
+		}
+	}
+	if !method.IsStreamingServer() {
+		if len(r.Stream) > 0 {
+			return response{}, errors.New("unary methods cannot stream")
+		}
+		if compiled.status == nil || compiled.status.Code() == codes.OK {
+			compiled.message, err = newBody(method.Output(), r.Response, r.Template)
+		}
+		return compiled, err
+	}
+	if len(r.Response) > 0 {
+		return response{}, errors.New("server streams answer with stream, not response")
+	}
+	for i, s := range r.Stream {
+		st := step{delay: milliseconds(s.DelayMs)}
+		switch {
+		case s.Status != nil:
+			st.status, err = newStatus(*s.Status)
+		case s.EOF:
+			st.status = status.New(codes.OK, "")
+		default:
+			st.message, err = newBody(method.Output(), s.Message, r.Template)
+		}
+		if err != nil {
+			return response{}, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		compiled.steps = append(compiled.steps, st)
+	}
+	return compiled, nil
+}
+
+func newStatus(s Status) (*status.Status, error) {
+	return stub.NewStatus(s.Code, s.Message, s.Details)
+}
+
+func (s *Server) handle(method protoreflect.MethodDescriptor, stream grpc.ServerStream) error {
+	fullMethodName := fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+	request, err := stub.NewMessage(method.Input())
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "mocking %s: %v", fullMethodName, err)
+	}
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	data, err := templateData(request)
+	if err != nil {
+		return status.Errorf(codes.Internal, "rendering request: %v", err)
+	}
+	r, ok := s.match(fullMethodName, data["request"])
+	if !ok {
+		return status.Errorf(codes.NotFound, "no fixture of %s matches the request", fullMethodName)
+	}
+	ctx := stream.Context()
+
+	if !method.IsStreamingServer() {
+		if err := stub.Wait(ctx, r.delay); err != nil {
+			return err
+		}
+		if r.status != nil && r.status.Code() != codes.OK {
+			return r.status.Err()
+		}
+		m, err := r.message.render(data)
+		if err != nil {
+			return status.Errorf(codes.Internal, "rendering response: %v", err)
+		}
+		return stream.SendMsg(m)
+	}
+
+	for _, st := range r.steps {
+		if err := stub.Wait(ctx, st.delay); err != nil {
+			return err
+		}
+		if st.status != nil {
+			return st.status.Err()
+		}
+		m, err := st.message.render(data)
+		if err != nil {
+			return status.Errorf(codes.Internal, "rendering message: %v", err)
+		}
+		if err := stream.SendMsg(m); err != nil {
+			return err
+		}
+	}
+	if r.status != nil {
+		return r.status.Err()
+	}
+	return nil
+}
+
+// match returns the first response of the method whose When matches the request
+func (s *Server) match(fullMethodName string, request any) (response, bool) {
+	fields, _ := request.(map[string]any)
+	for _, r := range s.responses[fullMethodName] {
+		if matches(r.when, fields) {
+			return r, true
+		}
+	}
+	return response{}, false
+}
+
+// matches reports whether every field of when has the same value in request, as json
+func matches(when, request map[string]any) bool {
+	for key, want := range when {
+		got, ok := request[key]
+		if !ok {
+			return false
+		}
+		if !reflect.DeepEqual(normalize(want), normalize(got)) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize renders json numbers as their text, as protojson writes 64 bit integers as
+// strings and fixtures may write them either way
+func normalize(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String()
+	case float64:
+		return fmt.Sprint(v)
+	case map[string]any:
+		normalized := make(map[string]any, len(v))
+		for key, value := range v {
+			normalized[key] = normalize(value)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(v))
+		for i, value := range v {
+			normalized[i] = normalize(value)
+		}
+		return normalized
+	}
+	return v
+}
+
+// templateData holds the request as protojson for the templates of the responses, with
+// the fields that are not set so that they can be used as well
+func templateData(request proto.Message) (map[string]any, error) {
+	payload, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return map[string]any{"request": decoded}, nil
+}
+
+// body is a message of a response, its strings are templates if it is templated
+type body struct {
+	md protoreflect.MessageDescriptor
+	// static is the message of bodies that are not templated
+	static proto.Message
+	// tree is the json of templated bodies, whose strings are parsed templates
+	tree any
+}
+
+func newBody(md protoreflect.MessageDescriptor, payload json.RawMessage, templated bool) (*body, error) {
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+	if !templated {
+		m, err := stub.NewMessage(md)
+		if err != nil {
+			return nil, err
+		}
+		if err := protojson.Unmarshal(payload, m); err != nil {
+			return nil, err
+		}
+		return &body{md: md, static: m}, nil
+	}
+	var decoded any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return nil, err
+	}
+	tree, err := parseTemplates(decoded)
+	if err != nil {
+		return nil, err
+	}
+	return &body{md: md, tree: tree}, nil
+}
+
+func parseTemplates(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return template.New("").Parse(v)
+	case map[string]any:
+		for key, value := range v {
+			parsed, err := parseTemplates(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			v[key] = parsed
+		}
+	case []any:
+		for i, value := range v {
+			parsed, err := parseTemplates(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = parsed
+		}
+	}
+	return v, nil
+}
+
+// render returns the message of the body for a request, see templateData
+func (b *body) render(data map[string]any) (proto.Message, error) {
+	if b.static != nil {
+		return b.static, nil
+	}
+	rendered, err := executeTemplates(b.tree, data)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(rendered)
+	if err != nil {
+		return nil, err
+	}
+	m, err := stub.NewMessage(b.md)
+	if err != nil {
+		return nil, err
+	}
+	return m, protojson.Unmarshal(payload, m)
+}
+
+func executeTemplates(v any, data map[string]any) (any, error) {
+	switch v := v.(type) {
+	case *template.Template:
+		var out strings.Builder
+		if err := v.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		return out.String(), nil
+	case map[string]any:
+		rendered := make(map[string]any, len(v))
+		for key, value := range v {
+			r, err := executeTemplates(value, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []any:
+		rendered := make([]any, len(v))
+		for i, value := range v {
+			r, err := executeTemplates(value, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+	return v, nil
+}
+
+func milliseconds(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,50 @@
+package mock_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/mock"
+	_ "github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+)
+
+func writeFixture(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing fixture: %v\n", err)
+	}
+	return path
+}
+
+func TestNew(t *testing.T) {
+	t.Run("loads fixtures from files and directories", func(t *testing.T) {
+		path := writeFixture(t, `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","responses":[{"response":{"value":1}}]}`)
+		s, err := mock.New(mock.Config{Fixtures: []string{path, filepath.Dir(path)}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		defer s.Stop()
+		if methods := s.Methods(); methods["/tgsbpb.TylerSandboxService/UnaryCallInt"] != 2 {
+			t.Fatalf("unexpected methods %v\n", methods)
+		}
+	})
+
+	t.Run("refuses fixtures that do not fit their method", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"unknown method":     `{"method":"/unknown.Service/Method","responses":[{}]}`,
+			"client streaming":   `{"method":"/tgsbpb.TylerSandboxService/ClientStreamInt","responses":[{}]}`,
+			"no responses":       `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt"}`,
+			"unknown field":      `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","responses":[{"response":{"nope":1}}]}`,
+			"unknown code":       `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","responses":[{"status":{"code":"NOPE"}}]}`,
+			"unary stream":       `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","responses":[{"stream":[{"eof":true}]}]}`,
+			"stream response":    `{"method":"/tgsbpb.TylerSandboxService/ServerStreamInt","responses":[{"response":{"value":1}}]}`,
+			"malformed template": `{"method":"/tgsbpb.TylerSandboxService/UnaryCallString","responses":[{"template":true,"response":{"value":"{{"}}]}`,
+		} {
+			if _, err := mock.New(mock.Config{Fixtures: []string{writeFixture(t, content)}}); err == nil {
+				t.Fatalf("expected an error for %s\n", name)
+			}
+		}
+	})
+}
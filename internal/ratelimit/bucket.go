@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit describes a token bucket, it is refilled at Rate tokens per second up to Burst tokens
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Bucket is a token bucket, it is safe for concurrent use
+type Bucket struct {
+	limit Limit
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket
+func NewBucket(limit Limit) *Bucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &Bucket{
+		limit:  limit,
+		now:    time.Now,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Take removes cost tokens from the bucket if it holds enough of them. If it does not,
+// nothing is removed and the time until enough tokens are available is returned
+func (b *Bucket) Take(cost float64) (ok bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+	if b.tokens >= cost {
+		b.tokens -= cost
+		return true, 0
+	}
+	if b.limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	return false, time.Duration((cost - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// full reports whether the bucket will have refilled completely by now
+func (b *Bucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/glob"
+)
+
+// Key selects what a rule's buckets are keyed by
+type Key string
+
+const (
+	// KeyIP gives every client ip its own bucket
+	KeyIP Key = "ip"
+	// KeyAPIKey gives every API key its own bucket, requests authenticated otherwise are not limited
+	KeyAPIKey Key = "apikey"
+	// KeySubject gives every JWT subject its own bucket, requests authenticated otherwise are not limited
+	KeySubject Key = "subject"
+	// KeyMethod gives every method a bucket shared by all callers
+	KeyMethod Key = "method"
+)
+
+// Rule limits the calls of every key to a token bucket
+type Rule struct {
+	Key   Key   `json:"key"`
+	Limit Limit `json:"limit"`
+	// Methods restricts the rule to the methods matching one of the patterns, empty applies it to every method
+	Methods []string `json:"methods"`
+}
+
+// Config describes the rate limits and stream quotas of the proxy
+type Config struct {
+	Rules []Rule `json:"rules"`
+	// Costs weighs the methods matching a pattern, e.g. an expensive method may cost 10 tokens.
+	// The first matching pattern wins and other methods cost 1. Patterns are tried in sorted order
+	Costs map[string]float64 `json:"costs"`
+	// MaxStreams caps the concurrent streams of every caller, identified by its subject or,
+	// if unauthenticated, its ip. Zero means no cap
+	MaxStreams int `json:"maxStreams"`
+}
+
+type rule struct {
+	key     Key
+	limit   Limit
+	methods []glob.Pattern
+	buckets map[string]*Bucket
+}
+
+type cost struct {
+	pattern glob.Pattern
+	cost    float64
+}
+
+// Limiter enforces a Config, it is safe for concurrent use
+type Limiter struct {
+	rules      []*rule
+	costs      []cost
+	maxStreams int
+	now        func() time.Time
+
+	mu        sync.Mutex
+	streams   map[string]int
+	lastSweep time.Time
+}
+
+// sweepInterval is how often buckets that have refilled completely are dropped
+const sweepInterval = time.Minute
+
+func NewLimiter(cfg Config) (*Limiter, error) {
+	l := &Limiter{
+		maxStreams: cfg.MaxStreams,
+		now:        time.Now,
+		streams:    map[string]int{},
+		lastSweep:  time.Now(),
+	}
+	for _, r := range cfg.Rules {
+		switch r.Key {
+		case KeyIP, KeyAPIKey, KeySubject, KeyMethod:
+		default:
+			return nil, fmt.Errorf("unknown rate limit key %q", r.Key)
+		}
+		if r.Limit.Rate <= 0 {
+			return nil, fmt.Errorf("rate limit keyed by %s needs a positive rate", r.Key)
+		}
+		methods, err := glob.CompileAll(r.Methods)
+		if err != nil {
+			return nil, err
+		}
+		l.rules = append(l.rules, &rule{key: r.Key, limit: r.Limit, methods: methods, buckets: map[string]*Bucket{}})
+	}
+	for _, pattern := range sortedKeys(cfg.Costs) {
+		p, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		l.costs = append(l.costs, cost{pattern: p, cost: cfg.Costs[pattern]})
+	}
+	return l, nil
+}
+
+// Cost returns the number of tokens a call of the method takes
+func (l *Limiter) Cost(fullMethodName string) float64 {
+	for _, c := range l.costs {
+		if c.pattern.Match(fullMethodName) {
+			return c.cost
+		}
+	}
+	return 1
+}
+
+// Take takes the cost of the method from the bucket of every rule that applies to the call.
+// keys resolves the key of the caller for a rule, an empty key exempts the call from the rule.
+// When a bucket is exhausted, it returns false and the time until the call may be retried
+func (l *Limiter) Take(fullMethodName string, keys func(Key) string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep()
+
+	costOfCall := l.Cost(fullMethodName)
+	for _, r := range l.rules {
+		if len(r.methods) > 0 && !glob.MatchAny(r.methods, fullMethodName) {
+			continue
+		}
+		key := fullMethodName
+		if r.key != KeyMethod {
+			key = keys(r.key)
+		}
+		if key == "" {
+			continue
+		}
+		bucket, ok := r.buckets[key]
+		if !ok {
+			bucket = NewBucket(r.limit)
+			bucket.now = l.now
+			bucket.last = l.now()
+			r.buckets[key] = bucket
+		}
+		// earlier rules may already have taken their tokens, a call rejected
+		// by a later rule still counts against them, like any other attempt
+		if ok, retryAfter := bucket.Take(costOfCall); !ok {
+			return false, retryAfter
+		}
+	}
+	return true, 0
+}
+
+// AcquireStream counts a stream of caller against MaxStreams. It returns false if the caller
+// is at its cap, otherwise the stream must be released once it ends
+func (l *Limiter) AcquireStream(caller string) (ok bool, release func()) {
+	if l.maxStreams <= 0 {
+		return true, func() {}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streams[caller] >= l.maxStreams {
+		return false, nil
+	}
+	l.streams[caller]++
+	var once sync.Once
+	return true, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.streams[caller]--; l.streams[caller] <= 0 {
+				delete(l.streams, caller)
+			}
+		})
+	}
+}
+
+// sweep drops buckets that are full again, which are indistinguishable from new ones,
+// so keys like client ips do not accumulate forever
+func (l *Limiter) sweep() {
+	now := l.now()
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for _, r := range l.rules {
+		for key, bucket := range r.buckets {
+			if bucket.full(now) {
+				delete(r.buckets, key)
+			}
+		}
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) now() time.Time {
+	return f.t
+}
+
+func Test_Bucket(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	b := NewBucket(Limit{Rate: 2, Burst: 2})
+	b.now, b.last = clock.now, clock.t
+
+	t.Run("starts full and refills at its rate", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if ok, _ := b.Take(1); !ok {
+				t.Fatalf("Expected take %d to succeed\n", i)
+			}
+		}
+		ok, retryAfter := b.Take(1)
+		if ok {
+			t.Fatalf("Expected the bucket to be empty\n")
+		}
+		if retryAfter != 500*time.Millisecond {
+			t.Fatalf("Expected retry after 500ms, got %v\n", retryAfter)
+		}
+		clock.t = clock.t.Add(500 * time.Millisecond)
+		if ok, _ := b.Take(1); !ok {
+			t.Fatalf("Expected the bucket to have refilled a token\n")
+		}
+	})
+}
+
+func Test_Limiter(t *testing.T) {
+
+	newLimiter := func(t *testing.T, cfg Config) (*Limiter, *fakeClock) {
+		l, err := NewLimiter(cfg)
+		if err != nil {
+			t.Fatalf("did not expect error: %v\n", err)
+		}
+		clock := &fakeClock{t: time.Unix(0, 0)}
+		l.now, l.lastSweep = clock.now, clock.t
+		return l, clock
+	}
+	keys := func(ip string) func(Key) string {
+		return func(key Key) string {
+			if key == KeyIP {
+				return ip
+			}
+			return ""
+		}
+	}
+
+	t.Run("keeps a bucket per key", func(t *testing.T) {
+		l, _ := newLimiter(t, Config{Rules: []Rule{{Key: KeyIP, Limit: Limit{Rate: 1, Burst: 1}}}})
+		if ok, _ := l.Take("/pkg.S/M", keys("1.1.1.1")); !ok {
+			t.Fatalf("Expected the first call to pass\n")
+		}
+		if ok, _ := l.Take("/pkg.S/M", keys("1.1.1.1")); ok {
+			t.Fatalf("Expected the second call of the same ip to be limited\n")
+		}
+		if ok, _ := l.Take("/pkg.S/M", keys("2.2.2.2")); !ok {
+			t.Fatalf("Expected another ip to have its own bucket\n")
+		}
+	})
+
+	t.Run("exempts calls without a key and methods outside the rule", func(t *testing.T) {
+		l, _ := newLimiter(t, Config{Rules: []Rule{{Key: KeySubject, Limit: Limit{Rate: 1, Burst: 1}, Methods: []string{"/pkg.S/*"}}}})
+		for i := 0; i < 3; i++ {
+			if ok, _ := l.Take("/pkg.S/M", keys("1.1.1.1")); !ok {
+				t.Fatalf("Expected calls without a subject to pass\n")
+			}
+		}
+		subject := func(Key) string { return "alice" }
+		l.Take("/other.S/M", subject)
+		if ok, _ := l.Take("/pkg.S/M", subject); !ok {
+			t.Fatalf("Expected calls of other methods not to count\n")
+		}
+	})
+
+	t.Run("weighs methods by cost", func(t *testing.T) {
+		l, _ := newLimiter(t, Config{
+			Rules: []Rule{{Key: KeyMethod, Limit: Limit{Rate: 1, Burst: 10}}},
+			Costs: map[string]float64{"/pkg.S/Expensive": 6},
+		})
+		if ok, _ := l.Take("/pkg.S/Expensive", keys("")); !ok {
+			t.Fatalf("Expected the first expensive call to pass\n")
+		}
+		ok, retryAfter := l.Take("/pkg.S/Expensive", keys(""))
+		if ok {
+			t.Fatalf("Expected the second expensive call to be limited\n")
+		}
+		if retryAfter != 2*time.Second {
+			t.Fatalf("Expected retry after 2s, got %v\n", retryAfter)
+		}
+		if ok, _ := l.Take("/pkg.S/Cheap", keys("")); !ok {
+			t.Fatalf("Expected other methods to have their own bucket\n")
+		}
+	})
+
+	t.Run("drops buckets that have refilled", func(t *testing.T) {
+		l, clock := newLimiter(t, Config{Rules: []Rule{{Key: KeyIP, Limit: Limit{Rate: 1, Burst: 1}}}})
+		l.Take("/pkg.S/M", keys("1.1.1.1"))
+		clock.t = clock.t.Add(2 * sweepInterval)
+		l.Take("/pkg.S/M", keys("2.2.2.2"))
+		if _, ok := l.rules[0].buckets["1.1.1.1"]; ok {
+			t.Fatalf("Expected the refilled bucket to be dropped\n")
+		}
+	})
+
+	t.Run("caps concurrent streams per caller", func(t *testing.T) {
+		l, _ := newLimiter(t, Config{MaxStreams: 1})
+		ok, release := l.AcquireStream("alice")
+		if !ok {
+			t.Fatalf("Expected the first stream to be acquired\n")
+		}
+		if ok, _ := l.AcquireStream("alice"); ok {
+			t.Fatalf("Expected the second stream to be refused\n")
+		}
+		if ok, _ := l.AcquireStream("bob"); !ok {
+			t.Fatalf("Expected other callers to have their own cap\n")
+		}
+		release()
+		release()
+		if ok, _ := l.AcquireStream("alice"); !ok {
+			t.Fatalf("Expected a released stream to free the cap\n")
+		}
+		if l.streams["alice"] != 1 {
+			t.Fatalf("Expected releasing twice to count once, got %d streams\n", l.streams["alice"])
+		}
+	})
+
+	t.Run("refuses unknown keys", func(t *testing.T) {
+		if _, err := NewLimiter(Config{Rules: []Rule{{Key: "header", Limit: Limit{Rate: 1}}}}); err == nil {
+			t.Fatalf("Expected error\n")
+		}
+	})
+}
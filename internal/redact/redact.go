@@ -0,0 +1,91 @@
+package redact
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Redacted replaces the values of redacted fields
+const Redacted = "[REDACTED]"
+
+// Fields renders messages as json with the values of some fields replaced by Redacted,
+// wherever they occur in the message. The zero value redacts nothing
+type Fields struct {
+	names map[string]bool
+}
+
+// New redacts the fields with the given names, which match both the json and the proto
+// name of a field
+func New(names []string) Fields {
+	f := Fields{names: map[string]bool{}}
+	for _, name := range names {
+		f.names[fieldKey(name)] = true
+	}
+	return f
+}
+
+// Has reports whether fields named name are redacted
+func (f Fields) Has(name string) bool {
+	return f.names[fieldKey(name)]
+}
+
+// Message renders m as protojson with its redacted fields replaced
+func (f Fields) Message(m proto.Message) (json.RawMessage, error) {
+	payload, err := protojson.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.names) == 0 {
+		return payload, nil
+	}
+	var decoded any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(f.value(decoded))
+}
+
+func (f Fields) value(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if f.Has(key) {
+				v[key] = Redacted
+			} else {
+				v[key] = f.value(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = f.value(value)
+		}
+	}
+	return v
+}
+
+// URI returns the path and query of u with the values of the redacted query parameters
+// replaced, the other parameters are left as they were sent
+func (f Fields) URI(u *url.URL) string {
+	if len(f.names) == 0 || u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil && f.Has(name) {
+			params[i] = key + "=" + Redacted
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(params, "&")
+	return redacted.RequestURI()
+}
+
+// fieldKey folds the json and proto names of a field, e.g. apiKey and api_key, into the same key
+func fieldKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
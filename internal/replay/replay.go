@@ -0,0 +1,283 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/capture"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/redact"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/stub"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Config describes the capture files calls are answered from and how fast streams are replayed
+type Config struct {
+	// Files are capture files, see capture.Recorder. Records of methods the proxy does not
+	// know are ignored
+	Files []string `json:"files"`
+	// Speed scales the timing of stream messages, 1 by default replays them as they were
+	// captured, 2 twice as fast and 0 sends them without delay
+	Speed *float64 `json:"speed"`
+}
+
+// call is a recorded call that can be answered
+type call struct {
+	// request is the recorded request as decoded json, redacted values match anything
+	request  any
+	response proto.Message
+	messages []message
+	status   *status.Status
+	// open keeps a stream open after its messages until the client leaves, as the
+	// stream was ended by its client when it was captured
+	open bool
+}
+
+type message struct {
+	offset time.Duration
+	value  proto.Message
+}
+
+// Server is an in-process grpc server answering calls from capture files. Requests are
+// matched to the recorded calls of their method, those recorded with the same request
+// taking precedence over those matching through redacted fields, and calls matching
+// several recordings are answered from each of them in turn
+type Server struct {
+	*stub.Server
+	speed float64
+	calls map[string][]*call
+
+	mu   sync.Mutex
+	next map[*call]int
+}
+
+// New loads the capture files of cfg and starts serving them, see Dial
+func New(cfg Config) (*Server, error) {
+	if len(cfg.Files) == 0 {
+		return nil, errors.New("replay needs at least one capture file")
+	}
+	speed := 1.0
+	if cfg.Speed != nil {
+		speed = *cfg.Speed
+	}
+	if speed < 0 {
+		return nil, fmt.Errorf("replay speed %v: must not be negative", speed)
+	}
+	s := &Server{speed: speed, calls: map[string][]*call{}, next: map[*call]int{}}
+	for _, path := range cfg.Files {
+		records, err := capture.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading capture: %w", err)
+		}
+		for i, record := range records {
+			method, err := stub.FindMethod(record.Method)
+			if err != nil {
+				continue
+			}
+			c, err := newCall(method, record)
+			if err != nil {
+				return nil, fmt.Errorf("%s: record %d of %s: %w", path, i+1, record.Method, err)
+			}
+			s.calls[record.Method] = append(s.calls[record.Method], c)
+		}
+	}
+	s.Server = stub.Serve(s.handle)
+	return s, nil
+}
+
+// Methods returns the number of recorded calls of every method that can be replayed
+func (s *Server) Methods() map[string]int {
+	methods := make(map[string]int, len(s.calls))
+	for method, calls := range s.calls {
+		methods[method] = len(calls)
+	}
+	return methods
+}
+
+func (s *Server) handle(method protoreflect.MethodDescriptor, stream grpc.ServerStream) error {
+	fullMethodName := fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+	request, err := stub.NewMessage(method.Input())
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "replaying %s: %v", fullMethodName, err)
+	}
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	c, err := s.match(fullMethodName, request)
+	if err != nil {
+		return err
+	}
+	if !method.IsStreamingServer() {
+		if c.status.Code() != codes.OK {
+			return c.status.Err()
+		}
+		return stream.SendMsg(c.response)
+	}
+
+	opened := time.Now()
+	for _, m := range c.messages {
+		if s.speed > 0 {
+			if err := stub.Wait(stream.Context(), time.Until(opened.Add(time.Duration(float64(m.offset)/s.speed)))); err != nil {
+				return err
+			}
+		}
+		if err := stream.SendMsg(m.value); err != nil {
+			return err
+		}
+	}
+	if c.open {
+		<-stream.Context().Done()
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+	return c.status.Err()
+}
+
+// match returns the next recorded call of the method whose request matches request
+func (s *Server) match(fullMethodName string, request proto.Message) (*call, error) {
+	payload, err := protojson.Marshal(request)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "rendering request: %v", err)
+	}
+	var decoded any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return nil, status.Errorf(codes.Internal, "rendering request: %v", err)
+	}
+	// recordings of the very same request are preferred to those it matches through redacted fields
+	var exact, matches []*call
+	for _, c := range s.calls[fullMethodName] {
+		if reflect.DeepEqual(c.request, decoded) {
+			exact = append(exact, c)
+		} else if matchValue(c.request, decoded) {
+			matches = append(matches, c)
+		}
+	}
+	if len(exact) > 0 {
+		matches = exact
+	}
+	if len(matches) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no recorded call of %s matches the request %s", fullMethodName, payload)
+	}
+	// calls are answered from the recordings of the same request in turn
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := matches[0]
+	c := matches[s.next[first]%len(matches)]
+	s.next[first]++
+	return c, nil
+}
+
+// matchValue reports whether the request value got matches the recorded value want,
+// recorded values that were redacted match any value
+func matchValue(want, got any) bool {
+	if want == redact.Redacted {
+		return true
+	}
+	switch want := want.(type) {
+	case map[string]any:
+		got, ok := got.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if !matchValue(value, got[key]) {
+				return false
+			}
+		}
+		for key := range got {
+			if _, ok := want[key]; !ok {
+				return false
+			}
+		}
+		return true
+	case []any:
+		got, ok := got.([]any)
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !matchValue(want[i], got[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+func newCall(method protoreflect.MethodDescriptor, record capture.Record) (*call, error) {
+	c := &call{}
+	request := record.Request
+	if len(request) == 0 {
+		request = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(request, &c.request); err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	st, err := stub.NewStatus(record.Status.Code, record.Status.Message, record.Status.Details)
+	if err != nil {
+		return nil, err
+	}
+	c.status = st
+	if !method.IsStreamingServer() {
+		if st.Code() == codes.OK {
+			if c.response, err = decodeMessage(method.Output(), record.Response); err != nil {
+				return nil, fmt.Errorf("response: %w", err)
+			}
+		}
+		return c, nil
+	}
+	for i, m := range record.Messages {
+		value, err := decodeMessage(method.Output(), m.Message)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		c.messages = append(c.messages, message{offset: time.Duration(m.OffsetMs * float64(time.Millisecond)), value: value})
+	}
+	c.open = st.Code() == codes.Canceled
+	return c, nil
+}
+
+// decodeMessage decodes a recorded message, fields that were redacted are left unset
+func decodeMessage(md protoreflect.MessageDescriptor, payload json.RawMessage) (proto.Message, error) {
+	m, err := stub.NewMessage(md)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		return m, nil
+	}
+	var decoded any
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return nil, err
+	}
+	if payload, err = json.Marshal(dropRedacted(decoded)); err != nil {
+		return nil, err
+	}
+	return m, protojson.Unmarshal(payload, m)
+}
+
+func dropRedacted(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if value == redact.Redacted {
+				delete(v, key)
+			} else {
+				v[key] = dropRedacted(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = dropRedacted(value)
+		}
+	}
+	return v
+}
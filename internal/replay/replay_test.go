@@ -0,0 +1,56 @@
+package replay_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/replay"
+	_ "github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+)
+
+func writeCapture(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing capture: %v\n", err)
+	}
+	return path
+}
+
+func TestNew(t *testing.T) {
+	t.Run("loads the records of known methods", func(t *testing.T) {
+		path := writeCapture(t, `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","request":{"value":1},"response":{"value":1},"status":{"code":"OK"}}
+{"method":"/tgsbpb.TylerSandboxService/ClientStreamInt","status":{"code":"OK"}}
+{"method":"/unknown.Service/Method","status":{"code":"OK"}}
+`)
+		s, err := replay.New(replay.Config{Files: []string{path}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		defer s.Stop()
+		methods := s.Methods()
+		if len(methods) != 1 || methods["/tgsbpb.TylerSandboxService/UnaryCallInt"] != 1 {
+			t.Fatalf("unexpected methods %v\n", methods)
+		}
+	})
+
+	t.Run("refuses records that cannot be replayed", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"unknown code":     `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","status":{"code":"Nope"}}`,
+			"invalid response": `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","response":{"value":"x"},"status":{"code":"OK"}}`,
+			"invalid json":     `{"method":`,
+		} {
+			if _, err := replay.New(replay.Config{Files: []string{writeCapture(t, content)}}); err == nil {
+				t.Fatalf("expected an error for %s\n", name)
+			}
+		}
+	})
+
+	t.Run("refuses negative speeds", func(t *testing.T) {
+		speed := -1.0
+		if _, err := replay.New(replay.Config{Files: []string{writeCapture(t, "")}, Speed: &speed}); err == nil {
+			t.Fatalf("expected an error\n")
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AttemptsHeader is the response header reporting how many attempts a call took
+const AttemptsHeader = "X-Proxy-Attempts"
+
+// Policy describes how a unary call is retried or hedged.
+// Zero values are replaced by the defaults documented on each field
+type Policy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one. Defaults to 1
+	MaxAttempts int
+	// RetryableCodes are the grpc codes worth another attempt. Defaults to UNAVAILABLE
+	RetryableCodes []codes.Code
+	// InitialBackoff is the wait before the first retry. Defaults to 100ms
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponentially growing wait between retries. Defaults to 5s
+	MaxBackoff time.Duration
+	// BackoffMultiplier is the growth factor of the wait between retries. Defaults to 2
+	BackoffMultiplier float64
+	// Jitter randomizes every wait by up to this fraction of it, between 0 and 1
+	Jitter float64
+	// Budget bounds the total time spent on all attempts and waits, 0 means unbounded
+	Budget time.Duration
+	// Hedge sends a new attempt every HedgeDelay without waiting for the previous ones
+	// to fail, and keeps the first successful response. Only use it for idempotent methods
+	Hedge bool
+	// HedgeDelay is the wait between hedged attempts. Defaults to InitialBackoff
+	HedgeDelay time.Duration
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if len(p.RetryableCodes) == 0 {
+		p.RetryableCodes = []codes.Code{codes.Unavailable}
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 5 * time.Second
+	}
+	if p.BackoffMultiplier <= 0 {
+		p.BackoffMultiplier = 2
+	}
+	if p.HedgeDelay <= 0 {
+		p.HedgeDelay = p.InitialBackoff
+	}
+	return p
+}
+
+func (p Policy) retryable(err error) bool {
+	var perm permanent
+	if errors.As(err, &perm) {
+		return false
+	}
+	return slices.Contains(p.RetryableCodes, status.Code(err))
+}
+
+// permanent marks an error that ends the call whatever its code, see Permanent
+type permanent struct {
+	err error
+}
+
+func (p permanent) Error() string { return p.err.Error() }
+func (p permanent) Unwrap() error { return p.err }
+
+// Permanent makes an attempt failing with err end the call, even if the code of err is
+// retryable, e.g. when the attempt never reached the backend. Do returns err itself
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanent{err}
+}
+
+// unwrapPermanent returns the error wrapped by Permanent, or err if it is not wrapped
+func unwrapPermanent(err error) error {
+	if perm, ok := err.(permanent); ok {
+		return perm.err
+	}
+	return err
+}
+
+// backoff returns the wait after the given attempt, starting at 1
+func (p Policy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.BackoffMultiplier, float64(attempt-1))
+	backoff = math.Min(backoff, float64(p.MaxBackoff))
+	backoff *= 1 + p.Jitter*(2*rand.Float64()-1)
+	return time.Duration(backoff)
+}
+
+// Do invokes call according to the policy and returns the outcome of the attempt that
+// ended the call, together with the number of attempts made.
+func Do[U any](
+	ctx context.Context,
+	policy Policy,
+	call func(ctx context.Context) (U, error),
+) (U, int, error) {
+	p := policy.withDefaults()
+	if p.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Budget)
+		defer cancel()
+	}
+	if p.Hedge {
+		return hedge(ctx, p, call)
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, err := call(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return response, attempt, unwrapPermanent(err)
+		}
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return response, attempt, unwrapPermanent(err)
+		}
+	}
+}
+
+// resetTimer resets a timer that may have fired without its channel being drained
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+type outcome[U any] struct {
+	response U
+	err      error
+}
+
+// hedge sends attempts HedgeDelay apart, or right away once an attempt fails with a
+// retryable code, and returns the first success or non retryable failure. Attempts
+// still in flight at that point are cancelled
+func hedge[U any](
+	ctx context.Context,
+	p Policy,
+	call func(ctx context.Context) (U, error),
+) (U, int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered so attempts that finish after the call returned do not block
+	outcomes := make(chan outcome[U], p.MaxAttempts)
+	launch := func() {
+		go func() {
+			response, err := call(ctx)
+			outcomes <- outcome[U]{response, err}
+		}()
+	}
+
+	launched, pending := 1, 1
+	launch()
+	timer := time.NewTimer(p.HedgeDelay)
+	defer timer.Stop()
+	var last outcome[U]
+	for {
+		select {
+		case <-timer.C:
+			if launched < p.MaxAttempts {
+				launched++
+				pending++
+				launch()
+				timer.Reset(p.HedgeDelay)
+			}
+		case o := <-outcomes:
+			pending--
+			last = o
+			if o.err == nil || !p.retryable(o.err) {
+				return o.response, launched, unwrapPermanent(o.err)
+			}
+			if launched < p.MaxAttempts && ctx.Err() == nil {
+				launched++
+				pending++
+				launch()
+				resetTimer(timer, p.HedgeDelay)
+			} else if pending == 0 {
+				return last.response, launched, unwrapPermanent(last.err)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package retry_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/retry"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingCall fails with code for the first failures attempts, then succeeds
+type failingCall struct {
+	failures int32
+	code     codes.Code
+	delay    time.Duration
+	calls    atomic.Int32
+}
+
+func (f *failingCall) call(ctx context.Context) (string, error) {
+	n := f.calls.Add(1)
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return "", status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if n <= f.failures {
+		return "", status.Error(f.code, "failed")
+	}
+	return "ok", nil
+}
+
+func Test_Do(t *testing.T) {
+
+	t.Run("retries retryable codes until success", func(t *testing.T) {
+		f := &failingCall{failures: 2, code: codes.Unavailable}
+		policy := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+		response, attempts, err := retry.Do(context.Background(), policy, f.call)
+		if err != nil {
+			t.Fatalf("did not expect error: %v\n", err)
+		}
+		if response != "ok" || attempts != 3 {
+			t.Fatalf("expected ok after 3 attempts, got %s after %d\n", response, attempts)
+		}
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		f := &failingCall{failures: 5, code: codes.Unavailable}
+		policy := retry.Policy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+		_, attempts, err := retry.Do(context.Background(), policy, f.call)
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected UNAVAILABLE, got %v\n", err)
+		}
+		if attempts != 2 {
+			t.Fatalf("expected 2 attempts, got %d\n", attempts)
+		}
+	})
+
+	t.Run("does not retry codes that are not retryable", func(t *testing.T) {
+		f := &failingCall{failures: 1, code: codes.InvalidArgument}
+		policy := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+		_, attempts, err := retry.Do(context.Background(), policy, f.call)
+		if status.Code(err) != codes.InvalidArgument || attempts != 1 {
+			t.Fatalf("expected INVALID_ARGUMENT after 1 attempt, got %v after %d\n", err, attempts)
+		}
+	})
+
+	t.Run("does not retry permanent errors whatever their code", func(t *testing.T) {
+		unavailable := status.Error(codes.Unavailable, "circuit breaker is open")
+		calls := 0
+		call := func(ctx context.Context) (string, error) {
+			calls++
+			return "", retry.Permanent(unavailable)
+		}
+		for _, policy := range []retry.Policy{
+			{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			{MaxAttempts: 3, InitialBackoff: time.Millisecond, Hedge: true, HedgeDelay: time.Minute},
+		} {
+			calls = 0
+			_, attempts, err := retry.Do(context.Background(), policy, call)
+			if err != unavailable || attempts != 1 || calls != 1 {
+				t.Fatalf("expected the unwrapped error after 1 attempt, got %v after %d attempts and %d calls\n", err, attempts, calls)
+			}
+		}
+	})
+
+	t.Run("stops retrying once the budget is spent", func(t *testing.T) {
+		f := &failingCall{failures: 100, code: codes.Unavailable}
+		policy := retry.Policy{MaxAttempts: 100, InitialBackoff: 20 * time.Millisecond, Budget: 50 * time.Millisecond}
+		start := time.Now()
+		_, attempts, _ := retry.Do(context.Background(), policy, f.call)
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("expected budget to bound the call, took %v\n", elapsed)
+		}
+		if attempts >= 100 {
+			t.Fatalf("expected budget to stop retries, got %d attempts\n", attempts)
+		}
+	})
+
+	t.Run("hedging returns the first success without waiting for slow attempts", func(t *testing.T) {
+		// every attempt takes 100ms, hedges are sent 10ms apart
+		f := &failingCall{delay: 100 * time.Millisecond}
+		policy := retry.Policy{MaxAttempts: 3, Hedge: true, HedgeDelay: 10 * time.Millisecond}
+		start := time.Now()
+		response, attempts, err := retry.Do(context.Background(), policy, f.call)
+		if err != nil || response != "ok" {
+			t.Fatalf("expected ok, got %s, %v\n", response, err)
+		}
+		if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+			t.Fatalf("expected first attempt to win, took %v\n", elapsed)
+		}
+		if attempts != 3 {
+			t.Fatalf("expected 3 hedged attempts, got %d\n", attempts)
+		}
+	})
+
+	t.Run("hedging sends the next attempt right away after a retryable failure", func(t *testing.T) {
+		f := &failingCall{failures: 1, code: codes.Unavailable}
+		policy := retry.Policy{MaxAttempts: 2, Hedge: true, HedgeDelay: time.Hour}
+		response, attempts, err := retry.Do(context.Background(), policy, f.call)
+		if err != nil || response != "ok" || attempts != 2 {
+			t.Fatalf("expected ok after 2 attempts, got %s, %v after %d\n", response, err, attempts)
+		}
+	})
+}
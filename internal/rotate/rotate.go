@@ -0,0 +1,138 @@
+package rotate
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is an append only file that is rotated once it would grow past a size. The
+// rotated files are kept next to it as path.1, path.2 and so on, path.1 being the
+// most recent, and the oldest is removed once there are more than the configured
+// number of backups. It is safe for concurrent use
+type File struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+const (
+	// DefaultMaxSizeMB and DefaultMaxBackups are used when a Config leaves them unset
+	DefaultMaxSizeMB  = 100
+	DefaultMaxBackups = 5
+)
+
+// Config describes a rotating file the way the logs and captures of the proxy are configured
+type Config struct {
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+// Open opens the file of cfg, zero or less MaxSizeMB and MaxBackups pick the defaults
+func (cfg Config) Open() (*File, error) {
+	if cfg.MaxSizeMB <= 0 {
+		cfg.MaxSizeMB = DefaultMaxSizeMB
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = DefaultMaxBackups
+	}
+	return Open(cfg.File, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups)
+}
+
+// Open opens path for appending, creating it if needed. maxBytes of zero or less never
+// rotates the file, maxBackups of zero or less discards it on rotation
+func Open(path string, maxBytes int64, maxBackups int) (*File, error) {
+	f := &File{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would not fit. p is never split
+// across files, so writing whole lines keeps every file valid line delimited data
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating %s: %w", f.path, err)
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups up by one and starts a new file
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+	if f.maxBackups <= 0 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return f.open()
+	}
+	if err := os.Remove(backup(f.path, f.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backup(f.path, i), backup(f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, backup(f.path, 1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Sync flushes the file to stable storage
+func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return os.ErrClosed
+	}
+	return f.f.Sync()
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+func backup(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
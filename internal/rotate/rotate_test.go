@@ -0,0 +1,86 @@
+package rotate_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/rotate"
+)
+
+func TestFile(t *testing.T) {
+	read := func(t *testing.T, path string) string {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s: %v\n", path, err)
+		}
+		return string(b)
+	}
+
+	t.Run("rotates before a write that does not fit and keeps the newest backups", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		f, err := rotate.Open(path, 8, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		defer f.Close()
+		for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n"} {
+			if _, err := f.Write([]byte(line)); err != nil {
+				t.Fatalf("unexpected error: %v\n", err)
+			}
+		}
+		if got := read(t, path); got != "dddd\n" {
+			t.Fatalf("expected current file to hold the last line, got %q\n", got)
+		}
+		if got := read(t, path+".1"); got != "cccc\n" {
+			t.Fatalf("expected first backup to hold cccc, got %q\n", got)
+		}
+		if got := read(t, path+".2"); got != "bbbb\n" {
+			t.Fatalf("expected second backup to hold bbbb, got %q\n", got)
+		}
+		if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+			t.Fatalf("expected no third backup, got %v\n", err)
+		}
+	})
+
+	t.Run("appends to an existing file and counts its size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		if err := os.WriteFile(path, []byte("aaaaaa\n"), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		f, err := rotate.Open(path, 10, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		defer f.Close()
+		f.Write([]byte("bbbb\n"))
+		if got := read(t, path+".1"); got != "aaaaaa\n" {
+			t.Fatalf("expected the existing content to be rotated, got %q\n", got)
+		}
+	})
+
+	t.Run("writes after close fail", func(t *testing.T) {
+		f, err := rotate.Open(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		f.Close()
+		if _, err := f.Write([]byte("x\n")); err == nil {
+			t.Fatalf("expected an error writing to a closed file\n")
+		}
+	})
+
+	t.Run("config defaults the size and backups", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		f, err := rotate.Config{File: path}.Open()
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		defer f.Close()
+		f.Write([]byte("aaaa\n"))
+		f.Write([]byte("bbbb\n"))
+		if got := read(t, path); got != "aaaa\nbbbb\n" {
+			t.Fatalf("expected the file not to be rotated, got %q\n", got)
+		}
+	})
+}
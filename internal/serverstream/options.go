@@ -0,0 +1,346 @@
+package serverstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/breaker"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/codec"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/intercept"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type proxyConfig struct {
+	codec           codec.Codec
+	breaker         *breaker.Breaker
+	subprotocols    []string
+	goingAway       <-chan struct{}
+	goingAwayReason string
+	checkOrigin     func(r *http.Request) bool
+	requestChecks   []func(c *gin.Context, request proto.Message) error
+	completions     []func(c *gin.Context, request proto.Message, err error, elapsed time.Duration)
+	observers       []Observer
+	registry        *Registry
+	method          string
+	messageHooks    []func(c *gin.Context, n int64, message proto.Message)
+	interceptors    intercept.Chain
+}
+
+// Observer is told about the life of every stream, nil funcs are skipped
+type Observer struct {
+	// Opened is called with the request once the stream is open and Closed once it has ended.
+	// Streams that are refused are never opened
+	Opened func(request proto.Message)
+	Closed func()
+	// Message is called for every message forwarded to the client with its position in the
+	// stream starting at 1, its size and the time since the stream was opened. It may be
+	// called from a goroutine of its own
+	Message func(n int64, size int, sinceOpen time.Duration)
+}
+
+type OptFunc func(*proxyConfig)
+
+func newProxyConfig(opts []OptFunc) *proxyConfig {
+	cfg := &proxyConfig{codec: codec.JSON{}}
+	for _, optFunc := range opts {
+		optFunc(cfg)
+	}
+	return cfg
+}
+
+// WithCodec sets the codec used to encode the messages sent to the client,
+// protojson is used by default
+func WithCodec(c codec.Codec) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.codec = c
+	}
+}
+
+// WithGoingAway makes the proxy close the websocket with CloseGoingAway and the
+// given reason as soon as goingAway is closed, e.g. when the server is shutting down
+func WithGoingAway(goingAway <-chan struct{}, reason string) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.goingAway = goingAway
+		pc.goingAwayReason = reason
+	}
+}
+
+// WithSubprotocols sets the websocket subprotocols the proxy selects from, in order of preference
+func WithSubprotocols(protocols ...string) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.subprotocols = protocols
+	}
+}
+
+// WithCheckOrigin sets the websocket origin check, by default only same-origin browsers may connect
+func WithCheckOrigin(checkOrigin func(r *http.Request) bool) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.checkOrigin = checkOrigin
+	}
+}
+
+// WithRequestCheck runs check on the parsed request before the stream is opened, e.g. to
+// validate or authorize it. Checks run in the order they are added. A check failing with a
+// grpc status refuses the stream with the matching http status, or close code for websockets
+func WithRequestCheck(check func(c *gin.Context, request proto.Message) error) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.requestChecks = append(pc.requestChecks, check)
+	}
+}
+
+// WithCompletion calls complete once the stream has ended, or was refused, with the parsed
+// request, the error the stream ended with and how long it lasted. request is nil when it
+// could not be parsed, err is nil for streams the server ended and carries a grpc status otherwise
+func WithCompletion(complete func(c *gin.Context, request proto.Message, err error, elapsed time.Duration)) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.completions = append(pc.completions, complete)
+	}
+}
+
+// WithObserver adds an observer of the streams
+func WithObserver(o Observer) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.observers = append(pc.observers, o)
+	}
+}
+
+// WithMessageHook calls hook with every message forwarded to the client and its position in
+// the stream starting at 1. The message is reused once hook returns, so it must not be kept,
+// and hook may be called from a goroutine of its own
+func WithMessageHook(hook func(c *gin.Context, n int64, message proto.Message)) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.messageHooks = append(pc.messageHooks, hook)
+	}
+}
+
+// WithInterceptor adds an interceptor of the stages of the streams, interceptors run in the
+// order they are added. Its Response func is not used
+func WithInterceptor(i intercept.Interceptor) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.interceptors = append(pc.interceptors, i)
+	}
+}
+
+// WithRegistry tracks the open streams of the route in r, as streams of method
+func WithRegistry(r *Registry, method string) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.registry = r
+		pc.method = method
+	}
+}
+
+// WithBreaker fails streams fast while the breaker is open and records how streams end
+func WithBreaker(b *breaker.Breaker) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.breaker = b
+	}
+}
+
+// allow reports whether the breaker, if any, lets the stream be opened
+func (pc *proxyConfig) allow() error {
+	if pc.breaker == nil {
+		return nil
+	}
+	return pc.breaker.Allow()
+}
+
+// recordOutcome reports to the breaker, if any, how the stream ended. Streams are long
+// lived, so only their outcome counts, not their duration, and every stream the breaker
+// allowed must report once, so that half-open probes are released
+func (pc *proxyConfig) recordOutcome(err error) {
+	if pc.breaker == nil {
+		return
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	pc.breaker.Record(err, time.Duration(0))
+}
+
+// checkRequest runs the request checks until one fails
+func (pc *proxyConfig) checkRequest(c *gin.Context, request proto.Message) error {
+	for _, check := range pc.requestChecks {
+		if err := check(c, request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// beforeCall runs the interceptors of a request that has been accepted, before the stream is opened
+func (pc *proxyConfig) beforeCall(c *gin.Context, request proto.Message) error {
+	if err := pc.interceptors.BeforeCall(c, request); err != nil {
+		return err
+	}
+	return pc.interceptors.StreamMessage(c, intercept.Inbound, 1, request)
+}
+
+const (
+	initiatorClient = "client"
+	initiatorServer = "server"
+	initiatorProxy  = "proxy"
+)
+
+// streamEnding is how a stream ended, and which side ended it
+type streamEnding struct {
+	err       error
+	initiator string
+	// closeCode is the code the proxy closed the websocket with, when err does not imply it
+	closeCode int
+}
+
+// terminatedEnding is the ending of a stream that was terminated through its registry
+func terminatedEnding(stream *activeStream) (streamEnding, bool) {
+	if !stream.wasTerminated() {
+		return streamEnding{}, false
+	}
+	return streamEnding{err: stream.terminateErr, initiator: initiatorProxy}, true
+}
+
+// summaryKey holds the Summary of a stream in its gin context
+const summaryKey = "serverstream.summary"
+
+// Summary describes a stream that was opened, once it has ended
+type Summary struct {
+	// Transport is websocket or sse
+	Transport string
+	// Initiator is the side that ended the stream: client, server or proxy
+	Initiator string
+	// CloseCode is the code the websocket was closed with, 0 for server-sent events
+	CloseCode int
+	// Err is the grpc status the stream ended with, nil when the server ended it
+	Err error
+	// the request is the only message in, its size is that of the message
+	MessagesIn, BytesIn int64
+	// messages out are counted as forwarded to the client, with the size of their payloads
+	MessagesOut, BytesOut int64
+	Duration              time.Duration
+}
+
+// StreamSummary returns the summary of the stream of the request, if one was opened and has ended
+func StreamSummary(c *gin.Context) (Summary, bool) {
+	summary, ok := c.Get(summaryKey)
+	if !ok {
+		return Summary{}, false
+	}
+	return summary.(Summary), true
+}
+
+// open tracks the stream in the registry, if any, and tells the observers it is open
+func (pc *proxyConfig) open(c *gin.Context, request proto.Message, transport string) *activeStream {
+	stream := newActiveStream(c, pc.method, transport)
+	if pc.registry != nil {
+		pc.registry.add(stream)
+	}
+	for _, o := range pc.observers {
+		if o.Opened != nil {
+			o.Opened(request)
+		}
+	}
+	return stream
+}
+
+// forwarded tells the message hooks and the observers the nth message has been forwarded
+func (pc *proxyConfig) forwarded(c *gin.Context, message proto.Message, n int64, size int, openedAt time.Time) {
+	for _, hook := range pc.messageHooks {
+		hook(c, n, message)
+	}
+	for _, o := range pc.observers {
+		if o.Message != nil {
+			o.Message(n, size, time.Since(openedAt))
+		}
+	}
+}
+
+// refuse logs and reports a stream that was refused or failed before any message was forwarded
+func (pc *proxyConfig) refuse(c *gin.Context, logger *slog.Logger, request proto.Message, err error, start time.Time) {
+	elapsed := time.Since(start)
+	logging.Outcome(c.Request.Context(), logger, "stream refused", err, elapsed)
+	pc.complete(c, request, err, elapsed)
+}
+
+// finish logs, summarizes and reports a stream that has ended, closeCode is 0 for transports
+// other than websockets
+func (pc *proxyConfig) finish(
+	c *gin.Context,
+	logger *slog.Logger,
+	request proto.Message,
+	transport string,
+	ending streamEnding,
+	closeCode int,
+	stream *activeStream,
+	start time.Time,
+) {
+	if pc.registry != nil {
+		pc.registry.remove(stream, ending.initiator)
+	}
+	elapsed := time.Since(start)
+	err := streamOutcome(ending.err)
+	summary := Summary{
+		Transport:   transport,
+		Initiator:   ending.initiator,
+		CloseCode:   closeCode,
+		Err:         err,
+		MessagesIn:  1,
+		BytesIn:     int64(proto.Size(request)),
+		MessagesOut: stream.messages.Load(),
+		BytesOut:    stream.bytes.Load(),
+		Duration:    elapsed,
+	}
+	c.Set(summaryKey, summary)
+	logArgs := []any{
+		slog.String("initiator", summary.Initiator),
+		slog.Int64("messages", summary.MessagesOut),
+		slog.Int64("bytes", summary.BytesOut),
+		slog.String("close_reason", closeReason(ending.err)),
+	}
+	if closeCode != 0 {
+		logArgs = append(logArgs, slog.Int("close_code", closeCode))
+	}
+	logging.Outcome(c.Request.Context(), logger, "stream closed", err, elapsed, logArgs...)
+	for _, o := range pc.observers {
+		if o.Closed != nil {
+			o.Closed()
+		}
+	}
+	pc.interceptors.StreamClose(c, request, err, elapsed)
+	pc.complete(c, request, err, elapsed)
+}
+
+// complete reports the outcome of the stream to the completion hooks
+func (pc *proxyConfig) complete(c *gin.Context, request proto.Message, err error, elapsed time.Duration) {
+	for _, complete := range pc.completions {
+		complete(c, request, err, elapsed)
+	}
+}
+
+// closeReason describes the error a stream ended with the way clients are told about it
+func closeReason(err error) string {
+	switch {
+	case errors.Is(err, io.EOF):
+		return "server stream ended"
+	case errors.Is(err, context.Canceled):
+		return "context cancelled"
+	}
+	return status.Convert(err).Message()
+}
+
+// streamOutcome maps the error a stream ended with to a grpc status, a stream the server
+// ended is a success and one whose context was cancelled, e.g. by the client leaving, is CANCELLED
+func streamOutcome(err error) error {
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return nil
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package serverstream
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/gin-gonic/gin"
+)
+
+// StreamInfo describes an open stream
+type StreamInfo struct {
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	Transport  string    `json:"transport"`
+	Subject    string    `json:"subject,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+	StartedAt  time.Time `json:"startedAt"`
+	// the request is the only message in, messages out are those forwarded to the client so far
+	MessagesIn  int64 `json:"messagesIn"`
+	MessagesOut int64 `json:"messagesOut"`
+	BytesOut    int64 `json:"bytesOut"`
+}
+
+// Stats aggregates the streams of a registry since it was created
+type Stats struct {
+	Active            int              `json:"active"`
+	ActiveByTransport map[string]int   `json:"activeByTransport"`
+	Opened            int64            `json:"opened"`
+	Closed            int64            `json:"closed"`
+	Terminated        int64            `json:"terminated"`
+	ClosedBy          map[string]int64 `json:"closedBy"`
+	// MessagesOut and BytesOut count what was forwarded by streams that have closed
+	MessagesOut int64 `json:"messagesOut"`
+	BytesOut    int64 `json:"bytesOut"`
+}
+
+// Registry tracks the open streams of the routes it is given to, see WithRegistry,
+// so they can be listed and terminated. It is safe for concurrent use
+type Registry struct {
+	mu         sync.Mutex
+	nextID     uint64
+	streams    map[string]*activeStream
+	opened     int64
+	closed     int64
+	terminated int64
+	closedBy   map[string]int64
+	messages   int64
+	bytes      int64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{streams: map[string]*activeStream{}, closedBy: map[string]int64{}}
+}
+
+// List returns the open streams, oldest first
+func (r *Registry) List() []StreamInfo {
+	r.mu.Lock()
+	infos := make([]StreamInfo, 0, len(r.streams))
+	for _, s := range r.streams {
+		infos = append(infos, s.snapshot())
+	}
+	r.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].StartedAt.Equal(infos[j].StartedAt) {
+			return infos[i].StartedAt.Before(infos[j].StartedAt)
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// Terminate ends the open stream with the given id. The stream ends with err, which should
+// carry a grpc status, and websockets are closed with closeCode, or the code matching err
+// when it is 0. It reports whether the stream was open and not already being terminated
+func (r *Registry) Terminate(id string, err error, closeCode int) bool {
+	r.mu.Lock()
+	s, ok := r.streams[id]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if closeCode == 0 {
+		closeCode = grpcerr.CloseCode(err)
+	}
+	return s.terminate(err, closeCode)
+}
+
+// Stats returns the aggregate statistics of the streams
+func (r *Registry) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := Stats{
+		Active:            len(r.streams),
+		ActiveByTransport: map[string]int{},
+		Opened:            r.opened,
+		Closed:            r.closed,
+		Terminated:        r.terminated,
+		ClosedBy:          map[string]int64{},
+		MessagesOut:       r.messages,
+		BytesOut:          r.bytes,
+	}
+	for _, s := range r.streams {
+		stats.ActiveByTransport[s.info.Transport]++
+	}
+	for initiator, n := range r.closedBy {
+		stats.ClosedBy[initiator] = n
+	}
+	return stats
+}
+
+func (r *Registry) add(s *activeStream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	s.info.ID = strconv.FormatUint(r.nextID, 10)
+	r.streams[s.info.ID] = s
+	r.opened++
+}
+
+func (r *Registry) remove(s *activeStream, initiator string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams, s.info.ID)
+	r.closed++
+	r.closedBy[initiator]++
+	r.messages += s.messages.Load()
+	r.bytes += s.bytes.Load()
+	if s.wasTerminated() {
+		r.terminated++
+	}
+}
+
+// activeStream is a stream that has been opened, with the messages and bytes forwarded
+// to the client and whether it was terminated
+type activeStream struct {
+	info     StreamInfo
+	messages atomic.Int64
+	bytes    atomic.Int64
+
+	terminated    chan struct{}
+	terminateOnce sync.Once
+	// set before terminated is closed
+	terminateErr       error
+	terminateCloseCode int
+}
+
+func newActiveStream(c *gin.Context, method, transport string) *activeStream {
+	s := &activeStream{
+		info: StreamInfo{
+			Method:     method,
+			Route:      c.FullPath(),
+			Transport:  transport,
+			RemoteAddr: c.ClientIP(),
+			StartedAt:  time.Now(),
+			MessagesIn: 1,
+		},
+		terminated: make(chan struct{}),
+	}
+	if identity, ok := auth.FromContext(c.Request.Context()); ok {
+		s.info.Subject = identity.Subject
+	}
+	return s
+}
+
+// add counts a forwarded message of size bytes and returns its position in the stream
+func (s *activeStream) add(size int) int64 {
+	s.bytes.Add(int64(size))
+	return s.messages.Add(1)
+}
+
+func (s *activeStream) snapshot() StreamInfo {
+	info := s.info
+	info.MessagesOut = s.messages.Load()
+	info.BytesOut = s.bytes.Load()
+	return info
+}
+
+// terminate asks the stream to end, it reports false if it was already asked to
+func (s *activeStream) terminate(err error, closeCode int) bool {
+	asked := false
+	s.terminateOnce.Do(func() {
+		s.terminateErr, s.terminateCloseCode = err, closeCode
+		close(s.terminated)
+		asked = true
+	})
+	return asked
+}
+
+func (s *activeStream) wasTerminated() bool {
+	select {
+	case <-s.terminated:
+		return true
+	default:
+		return false
+	}
+}
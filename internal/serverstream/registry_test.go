@@ -0,0 +1,79 @@
+package serverstream_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream/testutils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func Test_Registry(t *testing.T) {
+
+	t.Run("lists open streams and terminates them with the chosen status and close code", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
+		parseRequest := func(c *gin.Context) (*wrapperspb.StringValue, error) {
+			return &wrapperspb.StringValue{}, nil
+		}
+		registry := serverstream.NewRegistry()
+		summaries := make(chan serverstream.Summary, 1)
+		handler := func(c *gin.Context) {
+			serverstream.ServerStreamProxy(
+				c,
+				mockedOpenStreamFunc.Func,
+				parseRequest,
+				&wrapperspb.StringValue{},
+				serverstream.WithRegistry(registry, "/pkg.S/Stream"),
+			)
+			summary, _ := serverstream.StreamSummary(c)
+			summaries <- summary
+		}
+
+		events, closeFunc, err := testutils.OpenWebsocket(handler)
+		defer closeFunc()
+		if err != nil {
+			t.Fatalf("failed to open websocket: %v\n", err)
+		}
+		mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Value: wrapperspb.String("value-0")})
+		<-events
+
+		streams := registry.List()
+		if len(streams) != 1 {
+			t.Fatalf("expected a single open stream, got %v\n", streams)
+		}
+		if stream := streams[0]; stream.Method != "/pkg.S/Stream" || stream.Transport != "websocket" || stream.MessagesOut != 1 {
+			t.Fatalf("unexpected stream %+v\n", stream)
+		}
+
+		if !registry.Terminate(streams[0].ID, status.Error(codes.PermissionDenied, "misbehaving"), 4403) {
+			t.Fatalf("expected the stream to be terminated\n")
+		}
+		var websocketEvent testutils.WebsocketEvent
+		select {
+		case websocketEvent = <-events:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out waiting for event from websocket\n")
+		}
+		if !websocket.IsCloseError(websocketEvent.Err, 4403) {
+			t.Fatalf("expected close code 4403, got %v\n", websocketEvent.Err)
+		}
+
+		summary := <-summaries
+		if summary.Initiator != "proxy" || summary.CloseCode != 4403 || status.Code(summary.Err) != codes.PermissionDenied {
+			t.Fatalf("unexpected summary %+v\n", summary)
+		}
+		stats := registry.Stats()
+		if stats.Active != 0 || stats.Opened != 1 || stats.Terminated != 1 || stats.ClosedBy["proxy"] != 1 || stats.MessagesOut != 1 {
+			t.Fatalf("unexpected stats %+v\n", stats)
+		}
+		if registry.Terminate(streams[0].ID, status.Error(codes.Canceled, ""), 0) {
+			t.Fatalf("expected a closed stream not to be terminated\n")
+		}
+	})
+}
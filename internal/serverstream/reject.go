@@ -0,0 +1,66 @@
+package serverstream
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// maxCloseReason is the longest reason that fits a close frame after its 2 byte code
+const maxCloseReason = 123
+
+// rejectedStatusKey holds the http status a request was rejected with in its gin context
+const rejectedStatusKey = "serverstream.rejectedStatus"
+
+// RejectedStatus returns the http status the request was rejected with, websocket requests
+// are answered with 101 and a close frame instead, so their response status does not tell
+func RejectedStatus(c *gin.Context) (int, bool) {
+	httpStatus, ok := c.Get(rejectedStatusKey)
+	if !ok {
+		return 0, false
+	}
+	return httpStatus.(int), true
+}
+
+// Reject refuses a stream before it is opened. Browsers cannot read the status of a
+// failed websocket handshake, so websocket requests are upgraded and immediately closed
+// with closeCode and reason, any other request is answered with httpStatus and reason.
+// Only same-origin browsers are upgraded, see Rejecter
+func Reject(c *gin.Context, httpStatus int, closeCode int, reason string) {
+	Rejecter(nil)(c, httpStatus, closeCode, reason)
+}
+
+// Rejecter returns a Reject that upgrades the websockets of the origins allowed by checkOrigin,
+// which should be the check the streams themselves are upgraded with, see WithCheckOrigin
+func Rejecter(checkOrigin func(r *http.Request) bool) func(c *gin.Context, httpStatus int, closeCode int, reason string) {
+	return func(c *gin.Context, httpStatus int, closeCode int, reason string) {
+		c.Abort()
+		c.Set(rejectedStatusKey, httpStatus)
+		if !websocket.IsWebSocketUpgrade(c.Request) {
+			c.String(httpStatus, reason)
+			return
+		}
+		// browsers fail handshakes that do not select one of the offered subprotocols,
+		// which would hide the close code, so whatever the client offered is accepted
+		upgrader := websocket.Upgrader{Subprotocols: websocket.Subprotocols(c.Request), CheckOrigin: checkOrigin}
+		logger := logging.FromContext(c.Request.Context())
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			logger.Warn("upgrading rejected websocket failed", slog.Any("error", err))
+			return
+		}
+		closeConnection(conn, closeCode, truncateReason(reason), logger)
+	}
+}
+
+// truncateReason cuts reason to the 123 bytes a close frame carries
+func truncateReason(reason string) string {
+	if len(reason) > maxCloseReason {
+		return strings.ToValidUTF8(reason[:maxCloseReason], "")
+	}
+	return reason
+}
@@ -0,0 +1,215 @@
+package serverstream
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/codec"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/intercept"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	eventMessage   = "message"
+	eventEnd       = "end"
+	eventError     = "error"
+	eventGoingAway = "goingaway"
+)
+
+// writeEvent writes a single server-sent event and flushes it to the client.
+// multi-line data is split over several data fields, as required by the spec
+func writeEvent(w gin.ResponseWriter, event string, data string) error {
+	var sb strings.Builder
+	sb.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// handleEventStreamError sends the event matching how the stream ended
+func handleEventStreamError(err error, w gin.ResponseWriter, wentAway bool, goingAwayReason string) {
+	if wentAway {
+		writeEvent(w, eventGoingAway, goingAwayReason)
+	} else if errors.Is(err, context.Canceled) {
+		return
+	} else if errors.Is(err, io.EOF) {
+		writeEvent(w, eventEnd, "server stream ended")
+	} else if grpcStatus, ok := status.FromError(err); ok {
+		if grpcStatus.Code() != codes.Canceled {
+			writeEvent(w, eventError, grpcStatus.Message())
+		}
+	} else {
+		writeEvent(w, eventError, err.Error())
+	}
+}
+
+// ServerSentEventsProxy proxies a grpc server stream over server-sent events instead of
+// a websocket. Every message is sent as a "message" event. The response ends with an
+// "end" event when the server stream ends, an "error" event when it fails, or a
+// "goingaway" event when the proxy is shutting down. Payloads of binary codecs are
+// base64 encoded.
+func ServerSentEventsProxy[T, S proto.Message, U GrpcClientStream](
+	c *gin.Context,
+	openStreamFunc func(context.Context, T, ...grpc.CallOption) (U, error),
+	parseRequest func(c *gin.Context) (T, error),
+	streamResponse S,
+	opts ...OptFunc,
+) {
+	cfg := newProxyConfig(opts)
+	logger := logging.FromContext(c.Request.Context()).With(slog.String("transport", "sse"))
+	start := time.Now()
+	// refused answers a stream refused with the grpc status of err
+	refused := func(request proto.Message, err error) {
+		cfg.refuse(c, logger, request, err, start)
+		if body := grpcerr.StatusJSON(err); body != nil {
+			c.Data(grpcerr.HTTPStatus(err), codec.ContentTypeJSON, body)
+			return
+		}
+		c.String(grpcerr.HTTPStatus(err), status.Convert(err).Message())
+	}
+	if err := cfg.interceptors.BeforeDecode(c); err != nil {
+		refused(nil, err)
+		return
+	}
+	incomingRequest, err := parseRequest(c)
+	if err != nil {
+		cfg.refuse(c, logger, nil, status.Errorf(codes.InvalidArgument, "parsing request: %v", err), start)
+		c.String(400, err.Error())
+		return
+	}
+	if err := cfg.interceptors.AfterDecode(c, incomingRequest); err != nil {
+		refused(incomingRequest, err)
+		return
+	}
+	if err := cfg.checkRequest(c, incomingRequest); err != nil {
+		refused(incomingRequest, err)
+		return
+	}
+	// the interceptors run before the breaker is asked, so that a refused request takes no slot
+	if err := cfg.beforeCall(c, incomingRequest); err != nil {
+		refused(incomingRequest, err)
+		return
+	}
+	if err := cfg.allow(); err != nil {
+		cfg.refuse(c, logger, incomingRequest, err, start)
+		c.Header("Retry-After", strconv.Itoa(cfg.breaker.RetryAfterSeconds()))
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	// unlike the websocket proxy, the stream is consumed on the handler goroutine, so going
+	// away and termination interrupt it by stopping the supervisor, cancelling its context
+	sup := NewSupervisor(c.Request.Context())
+	defer sup.Wait()
+	defer sup.Stop()
+	stream, err := openStreamFunc(sup.Context(), incomingRequest)
+	if err != nil {
+		cfg.recordOutcome(err)
+		cfg.refuse(c, logger, incomingRequest, err, start)
+		c.String(500, err.Error())
+		return
+	}
+
+	var wentAway atomic.Bool
+	if cfg.goingAway != nil {
+		sup.Go(func(ctx context.Context) {
+			select {
+			case <-cfg.goingAway:
+				wentAway.Store(true)
+				sup.Stop()
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	// the stream is tracked before the client learns it is open
+	active := cfg.open(c, incomingRequest, "sse")
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(200)
+	c.Writer.Flush()
+	logger.Info("stream opened")
+	openedAt := time.Now()
+	sup.Go(func(ctx context.Context) {
+		select {
+		case <-active.terminated:
+			logger.Info("stream terminated")
+			sup.Stop()
+		case <-ctx.Done():
+		}
+	})
+	// the watchers are stopped and waited for before the stream is finished
+	finish := func(ending streamEnding) {
+		sup.Stop()
+		sup.Wait()
+		cfg.finish(c, logger, incomingRequest, "sse", ending, 0, active, start)
+	}
+
+	for n := int64(1); ; n++ {
+		if err := stream.RecvMsg(streamResponse); err != nil {
+			cfg.recordOutcome(err)
+			if ending, ok := terminatedEnding(active); ok {
+				writeEvent(c.Writer, eventError, status.Convert(ending.err).Message())
+				finish(ending)
+				return
+			}
+			ending := streamEnding{err: err, initiator: initiatorServer}
+			if wentAway.Load() {
+				ending = streamEnding{err: status.Error(codes.Unavailable, cfg.goingAwayReason), initiator: initiatorProxy}
+			} else if c.Request.Context().Err() != nil {
+				ending.initiator = initiatorClient
+			}
+			handleEventStreamError(err, c.Writer, wentAway.Load(), cfg.goingAwayReason)
+			finish(ending)
+			return
+		}
+		if err := cfg.interceptors.StreamMessage(c, intercept.Outbound, n, streamResponse); err != nil {
+			// the backend answered, an interceptor ended the stream
+			cfg.recordOutcome(nil)
+			writeEvent(c.Writer, eventError, status.Convert(err).Message())
+			finish(streamEnding{err: err, initiator: initiatorProxy})
+			return
+		}
+		responsePayload, err := cfg.codec.Marshal(streamResponse)
+		if err != nil {
+			// the backend answered, the proxy failed
+			cfg.recordOutcome(nil)
+			writeEvent(c.Writer, eventError, err.Error())
+			ending := streamEnding{err: status.Errorf(codes.Internal, "marshalling response: %v", err), initiator: initiatorProxy}
+			finish(ending)
+			return
+		}
+		data := string(responsePayload)
+		if cfg.codec.Binary() {
+			data = base64.StdEncoding.EncodeToString(responsePayload)
+		}
+		if err := writeEvent(c.Writer, eventMessage, data); err != nil {
+			ending := streamEnding{err: status.Errorf(codes.Canceled, "writing to client: %v", err), initiator: initiatorClient}
+			cfg.recordOutcome(ending.err)
+			finish(ending)
+			return
+		}
+		cfg.forwarded(c, streamResponse, active.add(len(responsePayload)), len(responsePayload), openedAt)
+		logger.Debug("message forwarded", slog.Int("bytes", len(responsePayload)))
+	}
+}
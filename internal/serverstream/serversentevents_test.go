@@ -0,0 +1,124 @@
+package serverstream_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream/testutils"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func Test_ServerSentEventsProxy(t *testing.T) {
+
+	t.Run("proxies server sent messages as events and ends with end event", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
+		parseRequest := func(c *gin.Context) (*wrapperspb.StringValue, error) {
+			return &wrapperspb.StringValue{}, nil
+		}
+
+		app := gin.New()
+		app.GET("/test", func(c *gin.Context) {
+			serverstream.ServerSentEventsProxy(
+				c,
+				mockedOpenStreamFunc.Func,
+				parseRequest,
+				&wrapperspb.StringValue{},
+			)
+		})
+		s := httptest.NewServer(app)
+		defer s.Close()
+
+		go func() {
+			mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Value: wrapperspb.String("value-0")})
+			mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Err: io.EOF})
+		}()
+
+		resp, err := http.Get(s.URL + "/test")
+		if err != nil {
+			t.Fatalf("failed to open event stream: %v\n", err)
+		}
+		defer resp.Body.Close()
+		if resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("expected content type text/event-stream, got %s\n", resp.Header.Get("Content-Type"))
+		}
+
+		var lines []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				lines = append(lines, scanner.Text())
+			}
+		}
+
+		expectedPayload, _ := protojson.Marshal(wrapperspb.String("value-0"))
+		expected := []string{
+			"event: message",
+			"data: " + string(expectedPayload),
+			"event: end",
+			"data: server stream ended",
+		}
+		if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+			t.Fatalf("expected events\n%s\ngot\n%s\n", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+		}
+	})
+
+	t.Run("summarizes the stream once it has ended", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
+		parseRequest := func(c *gin.Context) (*wrapperspb.StringValue, error) {
+			return wrapperspb.String("request"), nil
+		}
+
+		summaries := make(chan serverstream.Summary, 1)
+		app := gin.New()
+		app.GET("/test", func(c *gin.Context) {
+			serverstream.ServerSentEventsProxy(
+				c,
+				mockedOpenStreamFunc.Func,
+				parseRequest,
+				&wrapperspb.StringValue{},
+			)
+			summary, _ := serverstream.StreamSummary(c)
+			summaries <- summary
+		})
+		s := httptest.NewServer(app)
+		defer s.Close()
+
+		go func() {
+			mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Value: wrapperspb.String("value-0")})
+			mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Value: wrapperspb.String("value-1")})
+			mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Err: io.EOF})
+		}()
+
+		resp, err := http.Get(s.URL + "/test")
+		if err != nil {
+			t.Fatalf("failed to open event stream: %v\n", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		summary := <-summaries
+		payload, _ := protojson.Marshal(wrapperspb.String("value-0"))
+		expected := serverstream.Summary{
+			Transport:   "sse",
+			Initiator:   "server",
+			MessagesIn:  1,
+			BytesIn:     int64(proto.Size(wrapperspb.String("request"))),
+			MessagesOut: 2,
+			BytesOut:    int64(2 * len(payload)),
+		}
+		summary.Duration = 0
+		if summary != expected {
+			t.Fatalf("expected summary %+v, got %+v\n", expected, summary)
+		}
+	})
+}
@@ -3,42 +3,58 @@ package serverstream
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
 	"strings"
+	"time"
 
+	"github.com/TylerJGabb/grpc-http-proxy/internal/codec"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/intercept"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
-func awaitClosedConnection(conn *websocket.Conn) {
+// awaitClosedConnection reads from the connection until it is closed, and returns the
+// close code the client sent, or 0 if it did not send a close frame
+func awaitClosedConnection(conn *websocket.Conn, logger *slog.Logger) int {
 	for {
 		_, _, err := conn.NextReader()
 		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-				fmt.Printf("client closed connection\n")
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				logger.Debug("client closed connection", slog.Int("close_code", closeErr.Code))
+				return closeErr.Code
 			} else if strings.Contains(err.Error(), "use of closed network connection") {
-				fmt.Printf("connection already closed\n")
+				logger.Debug("connection already closed")
 			} else {
-				fmt.Printf("unexpected error from websocket client: %v\n", err)
+				logger.Debug("websocket read failed", slog.Any("error", err))
 			}
-			return
+			return 0
 		}
 	}
 }
 
-func closeConnection(conn *websocket.Conn, closeCode int, msg string) {
-	err := conn.WriteMessage(
+// closeWriteTimeout bounds how long writing a close frame may block
+const closeWriteTimeout = time.Second
+
+// closeConnection uses WriteControl rather than WriteMessage because it may be
+// called concurrently with the proxy loop writing messages, and gorilla only
+// allows control frames to be written concurrently with other writes
+func closeConnection(conn *websocket.Conn, closeCode int, msg string, logger *slog.Logger) {
+	err := conn.WriteControl(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(closeCode, msg),
+		time.Now().Add(closeWriteTimeout),
 	)
 	if err != nil {
-		fmt.Printf("error writing close message to websocket connection: %v\n", err)
+		logger.Debug("writing close frame failed", slog.Int("close_code", closeCode), slog.Any("error", err))
 	}
 	conn.Close()
 }
@@ -47,51 +63,96 @@ type GrpcClientStream interface {
 	RecvMsg(m any) error
 }
 
-func handleStreamError(err error, conn *websocket.Conn) {
+// handleStreamError closes the connection with the close code matching how the stream ended
+func handleStreamError(err error, conn *websocket.Conn, logger *slog.Logger) {
 	if errors.Is(err, context.Canceled) {
-		fmt.Printf("proxy loop context cancelled\n")
+		return
 	} else if errors.Is(err, io.EOF) {
-		fmt.Printf("grpc serverstream ended\n")
-		closeConnection(conn, websocket.CloseNormalClosure, "server stream ended")
+		closeConnection(conn, websocket.CloseNormalClosure, "server stream ended", logger)
 	} else if grpcStatus, ok := status.FromError(err); ok {
-		if grpcStatus.Code() == codes.Canceled {
-			fmt.Printf("grpc serverstream cancelled\n")
-		} else {
-			fmt.Printf("grpc error: %v\n", grpcStatus.Message())
-			closeConnection(conn, websocket.CloseInternalServerErr, grpcStatus.Message())
+		if grpcStatus.Code() != codes.Canceled {
+			closeConnection(conn, websocket.CloseInternalServerErr, grpcStatus.Message(), logger)
 		}
 	} else {
-		fmt.Printf("error receiving response from stream: %v\n", err)
-		closeConnection(conn, websocket.CloseInternalServerErr, err.Error())
+		closeConnection(conn, websocket.CloseInternalServerErr, err.Error(), logger)
 	}
 }
 
 func proxyLoop(
+	c *gin.Context,
 	conn *websocket.Conn,
 	stream GrpcClientStream,
 	streamResponse proto.Message,
+	cfg *proxyConfig,
+	logger *slog.Logger,
+	active *activeStream,
+	openedAt time.Time,
+	ended chan<- streamEnding,
 ) {
+	messageType := websocket.TextMessage
+	if cfg.codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
 	// this go function will return out and die when the stream's context is done
 	// we passed the gin's request context to the openStreamFunc which means that the stream
-	// will close when the request is done
-	defer fmt.Printf("proxy loop is done\n")
-	for {
+	// will close when the request is done.
+	// how the loop ended is sent to ended before the connection is closed, so that it is
+	// known to the handler once the connection is
+	for n := int64(1); ; n++ {
 		// blocks until a message is received, context is done, or an error occurs
 		if err := stream.RecvMsg(streamResponse); err != nil {
-			handleStreamError(err, conn)
+			cfg.recordOutcome(err)
+			ended <- streamEnding{err: err, initiator: initiatorServer}
+			handleStreamError(err, conn, logger)
+			return
+		}
+		if err := cfg.interceptors.StreamMessage(c, intercept.Outbound, n, streamResponse); err != nil {
+			// the backend answered, an interceptor ended the stream
+			cfg.recordOutcome(nil)
+			ending := streamEnding{err: err, initiator: initiatorProxy, closeCode: grpcerr.CloseCode(err)}
+			ended <- ending
+			closeConnection(conn, ending.closeCode, truncateReason(status.Convert(err).Message()), logger)
 			return
 		}
-		responsePayload, err := protojson.Marshal(streamResponse)
+		responsePayload, err := cfg.codec.Marshal(streamResponse)
 		if err != nil {
-			fmt.Printf("error marshalling response: %v\n", err)
-			closeConnection(conn, websocket.CloseInternalServerErr, err.Error())
+			// the backend answered, the proxy failed
+			cfg.recordOutcome(nil)
+			ended <- streamEnding{err: status.Errorf(codes.Internal, "marshalling response: %v", err), initiator: initiatorProxy}
+			closeConnection(conn, websocket.CloseInternalServerErr, err.Error(), logger)
 			return
 		}
-		err = conn.WriteMessage(websocket.TextMessage, responsePayload)
+		err = conn.WriteMessage(messageType, responsePayload)
 		if err != nil {
-			fmt.Printf("error writing response to websocket connection: %v\n", err)
+			ending := streamEnding{err: status.Errorf(codes.Canceled, "writing to client: %v", err), initiator: initiatorClient}
+			cfg.recordOutcome(ending.err)
+			ended <- ending
 			return
 		}
+		cfg.forwarded(c, streamResponse, active.add(len(responsePayload)), len(responsePayload), openedAt)
+		logger.Debug("message forwarded", slog.Int("bytes", len(responsePayload)))
+	}
+}
+
+// awaitGoingAway closes the connection with CloseGoingAway once goingAway is closed.
+// It returns when either that happens or ctx is done
+func awaitGoingAway(ctx context.Context, conn *websocket.Conn, goingAway <-chan struct{}, reason string, logger *slog.Logger) {
+	select {
+	case <-goingAway:
+		logger.Debug("server going away, closing websocket connection")
+		closeConnection(conn, websocket.CloseGoingAway, reason, logger)
+	case <-ctx.Done():
+	}
+}
+
+// awaitTermination closes the connection with the close code and status the stream was
+// terminated with, see Registry.Terminate. It returns when either that happens or ctx is done
+func awaitTermination(ctx context.Context, conn *websocket.Conn, active *activeStream, logger *slog.Logger) {
+	select {
+	case <-active.terminated:
+		logger.Info("stream terminated, closing websocket connection")
+		closeConnection(conn, active.terminateCloseCode, truncateReason(status.Convert(active.terminateErr).Message()), logger)
+	case <-ctx.Done():
 	}
 }
 
@@ -100,38 +161,144 @@ func ServerStreamProxy[T, S proto.Message, U GrpcClientStream](
 	openStreamFunc func(context.Context, T, ...grpc.CallOption) (U, error),
 	parseRequest func(c *gin.Context) (T, error),
 	streamResponse S,
+	opts ...OptFunc,
 ) {
-	fmt.Printf("beginning server stream proxy %p\n", c.Request.Context())
+	cfg := newProxyConfig(opts)
+	logger := logging.FromContext(c.Request.Context()).With(slog.String("transport", "websocket"))
+	start := time.Now()
+	reject := Rejecter(cfg.checkOrigin)
+	// refused answers a stream refused with the grpc status of err
+	refused := func(request proto.Message, err error) {
+		cfg.refuse(c, logger, request, err, start)
+		if body := grpcerr.StatusJSON(err); body != nil && !websocket.IsWebSocketUpgrade(c.Request) {
+			c.Data(grpcerr.HTTPStatus(err), codec.ContentTypeJSON, body)
+			return
+		}
+		reject(c, grpcerr.HTTPStatus(err), grpcerr.CloseCode(err), status.Convert(err).Message())
+	}
+	if err := cfg.interceptors.BeforeDecode(c); err != nil {
+		refused(nil, err)
+		return
+	}
 	incomingRequest, err := parseRequest(c)
 	if err != nil {
-		fmt.Printf("error parsing request: %v\n", err)
+		cfg.refuse(c, logger, nil, status.Errorf(codes.InvalidArgument, "parsing request: %v", err), start)
 		c.String(400, err.Error())
 		return
 	}
-	stream, err := openStreamFunc(c.Request.Context(), incomingRequest)
+	if err := cfg.interceptors.AfterDecode(c, incomingRequest); err != nil {
+		refused(incomingRequest, err)
+		return
+	}
+	if err := cfg.checkRequest(c, incomingRequest); err != nil {
+		refused(incomingRequest, err)
+		return
+	}
+	// the interceptors run before the breaker is asked, so that a refused request takes no slot
+	if err := cfg.beforeCall(c, incomingRequest); err != nil {
+		refused(incomingRequest, err)
+		return
+	}
+	if err := cfg.allow(); err != nil {
+		cfg.refuse(c, logger, incomingRequest, err, start)
+		reject(c, http.StatusServiceUnavailable, websocket.CloseTryAgainLater, err.Error())
+		return
+	}
+	// the stream and its goroutines are owned by a supervisor, which is stopped and waited
+	// for before returning, so that none of them outlives the request
+	sup := NewSupervisor(c.Request.Context())
+	defer sup.Wait()
+	defer sup.Stop()
+	stream, err := openStreamFunc(sup.Context(), incomingRequest)
 	if err != nil {
-		fmt.Printf("error opening stream: %v\n", err)
+		cfg.recordOutcome(err)
+		cfg.refuse(c, logger, incomingRequest, err, start)
 		c.String(500, err.Error())
 		return
 	}
-	upgrader := websocket.Upgrader{}
+	upgrader := websocket.Upgrader{Subprotocols: cfg.subprotocols, CheckOrigin: cfg.checkOrigin}
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
+		// the client failed the handshake, which says nothing about the backend
+		cfg.recordOutcome(status.Error(codes.Canceled, "upgrading connection failed"))
+		cfg.refuse(c, logger, incomingRequest, status.Errorf(codes.InvalidArgument, "upgrading connection: %v", err), start)
 		c.String(500, err.Error())
 		return
 	}
 	defer conn.Close()
-	go proxyLoop(conn, stream, streamResponse)
+	logger.Info("stream opened", slog.String("subprotocol", conn.Subprotocol()))
+	active := cfg.open(c, incomingRequest, "websocket")
+	ended := make(chan streamEnding, 1)
+	openedAt := time.Now()
+	sup.Go(func(context.Context) {
+		proxyLoop(c, conn, stream, streamResponse, cfg, logger, active, openedAt, ended)
+	})
+	if cfg.goingAway != nil {
+		sup.Go(func(ctx context.Context) {
+			awaitGoingAway(ctx, conn, cfg.goingAway, cfg.goingAwayReason, logger)
+		})
+	}
+	sup.Go(func(ctx context.Context) {
+		awaitTermination(ctx, conn, active, logger)
+	})
 
 	// we await a closed connection before returning
-	// two actors can close the stream
+	// three actors can close the stream
 	// 1. the client can close their connection, by ending the websocket connection
 	// 2. the server can close the stream, inside of the proxy loop
+	// 3. the proxy itself can close the stream when it is going away, or terminated
+	clientCloseCode := awaitClosedConnection(conn, logger)
+	ending, terminated := terminatedEnding(active)
+	code := active.terminateCloseCode
+	if !terminated {
+		ending = streamEnd(ended, cfg.goingAway, cfg.goingAwayReason)
+		code = closeCode(ending, clientCloseCode)
+	}
 
-	// once returned, the parent context (inside of gin.Context) will be done
-	// killing the goroutine that is running the proxy loop.
+	// how the stream ended is known, so its goroutines are stopped: the proxy loop returns
+	// once the stream's context is done, and the others once the connection is closed
+	conn.Close()
+	sup.Stop()
+	sup.Wait()
+	cfg.finish(c, logger, incomingRequest, "websocket", ending, code, active, start)
+}
 
-	// if the proxy loop was the one that closed the connection,
-	// it is a given that the goroutine is already dead
-	awaitClosedConnection(conn)
+// closeCode returns the code the websocket was closed with: the client's when it closed
+// the connection, and the one the proxy sent otherwise, see handleStreamError
+func closeCode(ending streamEnding, clientCloseCode int) int {
+	switch {
+	case ending.closeCode != 0:
+		return ending.closeCode
+	case clientCloseCode != 0 && ending.initiator == initiatorClient:
+		return clientCloseCode
+	case ending.initiator == initiatorClient:
+		return websocket.CloseAbnormalClosure
+	case errors.Is(ending.err, io.EOF):
+		return websocket.CloseNormalClosure
+	case ending.initiator == initiatorProxy && status.Code(ending.err) == codes.Unavailable:
+		return websocket.CloseGoingAway
+	case status.Code(ending.err) == codes.Canceled:
+		// the proxy does not close these, so the client did
+		if clientCloseCode != 0 {
+			return clientCloseCode
+		}
+		return websocket.CloseAbnormalClosure
+	}
+	return websocket.CloseInternalServerErr
+}
+
+// streamEnd returns how the proxy loop ended. A loop that is still running when the
+// connection has closed was cut short by the client, or by the proxy going away
+func streamEnd(ended <-chan streamEnding, goingAway <-chan struct{}, reason string) streamEnding {
+	select {
+	case ending := <-ended:
+		return ending
+	default:
+	}
+	select {
+	case <-goingAway:
+		return streamEnding{err: status.Error(codes.Unavailable, reason), initiator: initiatorProxy}
+	default:
+		return streamEnding{err: status.Error(codes.Canceled, "client closed connection"), initiator: initiatorClient}
+	}
 }
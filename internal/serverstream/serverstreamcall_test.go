@@ -5,21 +5,28 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/TylerJGabb/grpc-http-proxy/internal/breaker"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/intercept"
 	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream/testutils"
 
 	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func Test_ServerStreamProxy(t *testing.T) {
 
 	t.Run("proxies all server sent messages over the socket connection", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
 
 		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
 
@@ -81,6 +88,7 @@ func Test_ServerStreamProxy(t *testing.T) {
 	})
 
 	t.Run("propagates server error to client with CloseInternalServerErr close frame", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
 
 		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
 
@@ -141,7 +149,82 @@ func Test_ServerStreamProxy(t *testing.T) {
 		}
 	})
 
+	t.Run("interceptors rewrite messages and end the stream with the close code of their status", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+
+		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
+		parseRequest := func(c *gin.Context) (*wrapperspb.StringValue, error) {
+			return &wrapperspb.StringValue{Value: "request"}, nil
+		}
+		closed := make(chan error, 1)
+		interceptor := intercept.Interceptor{
+			StreamMessage: func(c *gin.Context, direction intercept.Direction, n int64, message proto.Message) error {
+				value := message.(*wrapperspb.StringValue)
+				if direction == intercept.Inbound {
+					value.Value = "intercepted-" + value.Value
+					return nil
+				}
+				if value.Value == "secret" {
+					return status.Error(codes.PermissionDenied, "secret message")
+				}
+				value.Value = fmt.Sprintf("%d-%s", n, value.Value)
+				return nil
+			},
+			StreamClose: func(c *gin.Context, request proto.Message, err error, elapsed time.Duration) {
+				closed <- err
+			},
+		}
+		handler := func(c *gin.Context) {
+			serverstream.ServerStreamProxy(
+				c,
+				mockedOpenStreamFunc.Func,
+				parseRequest,
+				&wrapperspb.StringValue{},
+				serverstream.WithInterceptor(interceptor),
+			)
+		}
+
+		events, closeFunc, err := testutils.OpenWebsocket(handler)
+		defer closeFunc()
+		if err != nil {
+			t.Fatalf("failed to open websocket: %v\n", err)
+		}
+		if mockedOpenStreamFunc.ReceivedRequest.Value != "intercepted-request" {
+			t.Fatalf("expected the intercepted request, got %s\n", mockedOpenStreamFunc.ReceivedRequest.Value)
+		}
+
+		mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Value: &wrapperspb.StringValue{Value: "hello"}})
+		var websocketEvent testutils.WebsocketEvent
+		select {
+		case websocketEvent = <-events:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out waiting for message from websocket\n")
+		}
+		if string(websocketEvent.Payload) != `"1-hello"` {
+			t.Fatalf("expected the rewritten message, got %s %v\n", websocketEvent.Payload, websocketEvent.Err)
+		}
+
+		mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Value: &wrapperspb.StringValue{Value: "secret"}})
+		select {
+		case websocketEvent = <-events:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out waiting for event from websocket\n")
+		}
+		if !websocket.IsCloseError(websocketEvent.Err, 4403) || !strings.Contains(websocketEvent.Err.Error(), "secret message") {
+			t.Fatalf("expected a 4403 close frame, got %v\n", websocketEvent.Err)
+		}
+		select {
+		case err := <-closed:
+			if status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("expected the stream to close with PERMISSION_DENIED, got %v\n", err)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out waiting for the stream to close\n")
+		}
+	})
+
 	t.Run("if client closes connection, handler dies", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
 		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
 
 		expectedValueFromRequest := "parsed-value-from-request"
@@ -151,7 +234,7 @@ func Test_ServerStreamProxy(t *testing.T) {
 			}, nil
 		}
 
-		dead := make(chan bool, 1)
+		dead := make(chan serverstream.Summary, 1)
 		handler := func(c *gin.Context) {
 			serverstream.ServerStreamProxy(
 				c,
@@ -159,7 +242,8 @@ func Test_ServerStreamProxy(t *testing.T) {
 				parseRequest,
 				&wrapperspb.StringValue{},
 			)
-			dead <- true
+			summary, _ := serverstream.StreamSummary(c)
+			dead <- summary
 		}
 
 		events, closeFunc, err := testutils.OpenWebsocket(handler)
@@ -189,14 +273,20 @@ func Test_ServerStreamProxy(t *testing.T) {
 			t.Fatalf("expected error from websocket, got nil\n")
 		}
 
+		var summary serverstream.Summary
 		select {
-		case <-dead:
+		case summary = <-dead:
 		case <-time.After(2 * time.Second):
 			t.Fatalf("expected handler to be dead after client closes connection\n")
 		}
+		// the client went away without a close frame
+		if summary.Initiator != "client" || summary.CloseCode != websocket.CloseAbnormalClosure {
+			t.Fatalf("expected the client to have closed the stream abnormally, got %+v\n", summary)
+		}
 	})
 
 	t.Run("if the server ends the stream, normal close frame is sent", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
 		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
 
 		expectedValueFromRequest := "parsed-value-from-request"
@@ -248,6 +338,7 @@ func Test_ServerStreamProxy(t *testing.T) {
 	})
 
 	t.Run("if open stream fails, ws handshake fails with 500", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
 		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock(
 			testutils.WithErrorWhenStreamOpened(errors.New("open stream failed")),
 		)
@@ -274,6 +365,7 @@ func Test_ServerStreamProxy(t *testing.T) {
 	})
 
 	t.Run("if request parser fails, ws handshake fails with 400", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
 		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
 
 		parseRequest := func(c *gin.Context) (*wrapperspb.StringValue, error) {
@@ -296,4 +388,183 @@ func Test_ServerStreamProxy(t *testing.T) {
 			t.Fatalf("expected websocket handshake to fail with ErrBadHandshake, got %v\n", err)
 		}
 	})
+
+	t.Run("if the proxy is going away, going away close frame is sent", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
+
+		parseRequest := func(c *gin.Context) (*wrapperspb.StringValue, error) {
+			return &wrapperspb.StringValue{}, nil
+		}
+
+		goingAway := make(chan struct{})
+		dead := make(chan bool, 1)
+		handler := func(c *gin.Context) {
+			serverstream.ServerStreamProxy(
+				c,
+				mockedOpenStreamFunc.Func,
+				parseRequest,
+				&wrapperspb.StringValue{},
+				serverstream.WithGoingAway(goingAway, "retry after 5s"),
+			)
+			dead <- true
+		}
+
+		events, closeFunc, err := testutils.OpenWebsocket(handler)
+		defer closeFunc()
+		if err != nil {
+			t.Fatalf("failed to open websocket: %v\n", err)
+		}
+
+		close(goingAway)
+		var websocketEvent testutils.WebsocketEvent
+		select {
+		case websocketEvent = <-events:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out waiting for event from websocket\n")
+		}
+
+		if !websocket.IsCloseError(websocketEvent.Err, websocket.CloseGoingAway) {
+			t.Fatalf("expected close frame to be CloseGoingAway, got %v\n", websocketEvent.Err)
+		}
+
+		if !strings.Contains(websocketEvent.Err.Error(), "retry after 5s") {
+			t.Fatalf("expected close reason to contain retry hint, got '%s'\n", websocketEvent.Err.Error())
+		}
+
+		select {
+		case <-dead:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected handler to be dead after going away\n")
+		}
+	})
+
+	t.Run("streams refused or ended by interceptors are settled with the breaker", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		parseRequest := func(c *gin.Context) (*wrapperspb.StringValue, error) {
+			return &wrapperspb.StringValue{Value: "request"}, nil
+		}
+		b := breaker.New(breaker.Config{MinRequests: 1, OpenDuration: time.Millisecond, ProbeTimeout: time.Minute})
+		b.Allow()
+		b.Record(status.Error(codes.Unavailable, "backend is melting down"), 0)
+		time.Sleep(5 * time.Millisecond)
+		if b.State() != breaker.HalfOpen {
+			t.Fatalf("expected the breaker to be half open, got %s\n", b.State())
+		}
+
+		var refuse atomic.Bool
+		refuse.Store(true)
+		interceptor := intercept.Interceptor{
+			BeforeCall: func(c *gin.Context, request proto.Message) error {
+				if refuse.Load() {
+					return status.Error(codes.PermissionDenied, "refused")
+				}
+				return nil
+			},
+			StreamMessage: func(c *gin.Context, direction intercept.Direction, n int64, message proto.Message) error {
+				if direction == intercept.Outbound {
+					return status.Error(codes.PermissionDenied, "secret message")
+				}
+				return nil
+			},
+		}
+		open := func(mockedOpenStreamFunc *testutils.OpenStreamFuncMock) (<-chan testutils.WebsocketEvent, func(), error) {
+			return testutils.OpenWebsocket(func(c *gin.Context) {
+				serverstream.ServerStreamProxy(
+					c,
+					mockedOpenStreamFunc.Func,
+					parseRequest,
+					&wrapperspb.StringValue{},
+					serverstream.WithBreaker(b),
+					serverstream.WithInterceptor(interceptor),
+				)
+			})
+		}
+
+		refused := testutils.NewOpenStreamFuncMock()
+		events, closeFunc, err := open(&refused)
+		if err == nil {
+			select {
+			case <-events:
+			case <-time.After(1 * time.Second):
+				t.Fatalf("timed out waiting for the refusal\n")
+			}
+		}
+		closeFunc()
+		if refused.ReceivedRequest != nil {
+			t.Fatalf("expected the refused stream not to be opened\n")
+		}
+
+		// the refusal left the probe slot free, so the next stream is let through
+		refuse.Store(false)
+		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
+		events, closeFunc, err = open(&mockedOpenStreamFunc)
+		defer closeFunc()
+		if err != nil {
+			t.Fatalf("failed to open websocket: %v\n", err)
+		}
+		if mockedOpenStreamFunc.ReceivedRequest == nil {
+			t.Fatalf("expected the probe to be let through\n")
+		}
+		mockedOpenStreamFunc.SimulateServerSideMessage(testutils.TestMessage{Value: &wrapperspb.StringValue{Value: "secret"}})
+		var websocketEvent testutils.WebsocketEvent
+		select {
+		case websocketEvent = <-events:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out waiting for event from websocket\n")
+		}
+		if !websocket.IsCloseError(websocketEvent.Err, 4403) {
+			t.Fatalf("expected a 4403 close frame, got %v\n", websocketEvent.Err)
+		}
+		// the backend answered the probe, so the breaker closes
+		deadline := time.Now().Add(time.Second)
+		for b.State() != breaker.Closed && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if b.State() != breaker.Closed {
+			t.Fatalf("expected the breaker to close, got %s\n", b.State())
+		}
+	})
+
+	t.Run("if the breaker is open, try again later close frame is sent", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		mockedOpenStreamFunc := testutils.NewOpenStreamFuncMock()
+
+		parseRequest := func(c *gin.Context) (*wrapperspb.StringValue, error) {
+			return &wrapperspb.StringValue{}, nil
+		}
+
+		b := breaker.New(breaker.Config{MinRequests: 1})
+		b.Allow()
+		b.Record(status.Error(codes.Unavailable, "backend is melting down"), 0)
+		handler := func(c *gin.Context) {
+			serverstream.ServerStreamProxy(
+				c,
+				mockedOpenStreamFunc.Func,
+				parseRequest,
+				&wrapperspb.StringValue{},
+				serverstream.WithBreaker(b),
+			)
+		}
+
+		events, closeFunc, err := testutils.OpenWebsocket(handler)
+		defer closeFunc()
+		if err != nil {
+			t.Fatalf("failed to open websocket: %v\n", err)
+		}
+
+		var websocketEvent testutils.WebsocketEvent
+		select {
+		case websocketEvent = <-events:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out waiting for event from websocket\n")
+		}
+
+		if !websocket.IsCloseError(websocketEvent.Err, websocket.CloseTryAgainLater) {
+			t.Fatalf("expected close frame to be CloseTryAgainLater, got %v\n", websocketEvent.Err)
+		}
+		if mockedOpenStreamFunc.ReceivedRequest != nil {
+			t.Fatalf("expected stream not to be opened while the breaker is open\n")
+		}
+	})
 }
@@ -0,0 +1,80 @@
+package serverstream
+
+import (
+	"context"
+	"sync"
+)
+
+// Supervisor owns the goroutines of a stream. They are started with Go and share a
+// context that Stop cancels, after which Done is closed once every one of them has
+// returned. The handlers stop and wait for their supervisor before returning, so no
+// goroutine of a stream outlives its request
+type Supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	running int
+	stopped bool
+	done    chan struct{}
+}
+
+// NewSupervisor returns a supervisor whose context is derived from parent
+func NewSupervisor(parent context.Context) *Supervisor {
+	ctx, cancel := context.WithCancel(parent)
+	return &Supervisor{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// Context is done once the supervisor is stopped or its parent is done
+func (s *Supervisor) Context() context.Context {
+	return s.ctx
+}
+
+// Go runs f on a goroutine of its own with the supervisor's context. f must return
+// once the context is done. Nothing is started once the supervisor is stopped
+func (s *Supervisor) Go(f func(ctx context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.running++
+	go func() {
+		defer s.exited()
+		f(s.ctx)
+	}()
+}
+
+// Stop cancels the context of the supervised goroutines, it may be called more than once
+// and from the goroutines themselves
+func (s *Supervisor) Stop() {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	if s.running == 0 {
+		close(s.done)
+	}
+}
+
+// Done is closed once the supervisor is stopped and all of its goroutines have returned
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait blocks until Done is closed
+func (s *Supervisor) Wait() {
+	<-s.done
+}
+
+func (s *Supervisor) exited() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	if s.running == 0 && s.stopped {
+		close(s.done)
+	}
+}
@@ -0,0 +1,63 @@
+package serverstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream/testutils"
+)
+
+func Test_Supervisor(t *testing.T) {
+
+	t.Run("is done once stopped and its goroutines have returned", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		sup := serverstream.NewSupervisor(context.Background())
+		release := make(chan struct{})
+		sup.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			<-release
+		})
+		sup.Stop()
+		select {
+		case <-sup.Done():
+			t.Fatalf("expected the supervisor not to be done while a goroutine runs\n")
+		case <-time.After(10 * time.Millisecond):
+		}
+		close(release)
+		select {
+		case <-sup.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the supervisor to be done\n")
+		}
+	})
+
+	t.Run("can be stopped from its goroutines and starts nothing once stopped", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		sup := serverstream.NewSupervisor(context.Background())
+		sup.Go(func(context.Context) {
+			sup.Stop()
+		})
+		sup.Wait()
+		sup.Go(func(context.Context) {
+			t.Errorf("expected nothing to start once stopped\n")
+		})
+		sup.Stop()
+		if sup.Context().Err() == nil {
+			t.Fatalf("expected the context to be cancelled\n")
+		}
+	})
+
+	t.Run("is cancelled with its parent", func(t *testing.T) {
+		testutils.CheckGoroutineLeaks(t)
+		parent, cancel := context.WithCancel(context.Background())
+		sup := serverstream.NewSupervisor(parent)
+		sup.Go(func(ctx context.Context) {
+			<-ctx.Done()
+		})
+		cancel()
+		sup.Stop()
+		sup.Wait()
+	})
+}
@@ -3,11 +3,15 @@ package testutils
 import (
 	"context"
 	"net/http/httptest"
+	"runtime"
 	"strings"
+	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
@@ -22,10 +26,22 @@ type TestMessage struct {
 // SimulateServerSideMessage method
 type GrpcClientStreamMock struct {
 	serverStream chan TestMessage
+	// ctx is the context the stream was opened with, like a grpc stream
+	// RecvMsg returns once it is done
+	ctx context.Context
 }
 
 func (s GrpcClientStreamMock) RecvMsg(m any) error {
-	testMessage := <-s.serverStream
+	done := context.Background().Done()
+	if s.ctx != nil {
+		done = s.ctx.Done()
+	}
+	var testMessage TestMessage
+	select {
+	case testMessage = <-s.serverStream:
+	case <-done:
+		return status.FromContextError(s.ctx.Err()).Err()
+	}
 	if testMessage.Err != nil {
 		return testMessage.Err
 	}
@@ -64,7 +80,7 @@ func NewOpenStreamFuncMock(opts ...OpenStreamFuncMockOptFunc) OpenStreamFuncMock
 }
 
 func (m *OpenStreamFuncMock) Func(
-	_ context.Context,
+	ctx context.Context,
 	req *wrapperspb.StringValue,
 	_ ...grpc.CallOption,
 ) (*GrpcClientStreamMock, error) {
@@ -72,7 +88,7 @@ func (m *OpenStreamFuncMock) Func(
 	if m.errorWhenStreamOpened != nil {
 		return nil, m.errorWhenStreamOpened
 	}
-	return &m.GrpcClientStreamMock, nil
+	return &GrpcClientStreamMock{serverStream: m.serverStream, ctx: ctx}, nil
 }
 
 type WebsocketEvent struct {
@@ -118,3 +134,46 @@ func OpenWebsocket(handler func(c *gin.Context)) (
 	}()
 	return
 }
+
+// proxyFrame prefixes the stack frames of the serverstream package, but not those of
+// its tests or of this package
+const proxyFrame = "github.com/TylerJGabb/grpc-http-proxy/internal/serverstream."
+
+// CheckGoroutineLeaks fails t if, once it and its cleanups before this one have run,
+// goroutines of the serverstream package are still running. Stopping them is
+// asynchronous, so they are given a second to return
+func CheckGoroutineLeaks(t testing.TB) {
+	t.Helper()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(time.Second)
+		for {
+			leaked := proxyGoroutines()
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d proxy goroutines leaked:\n%s\n", len(leaked), strings.Join(leaked, "\n\n"))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func proxyGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var leaked []string
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, proxyFrame) {
+			leaked = append(leaked, g)
+		}
+	}
+	return leaked
+}
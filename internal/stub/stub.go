@@ -0,0 +1,146 @@
+package stub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Handler answers a call of method, whose request is yet to be received from stream
+type Handler func(method protoreflect.MethodDescriptor, stream grpc.ServerStream) error
+
+// Server is an in-process grpc server standing in for a backend. It answers the unary and
+// server streaming methods of every service linked into the binary with its handler,
+// and is reached through Dial rather than the network
+type Server struct {
+	listener *bufconn.Listener
+	server   *grpc.Server
+}
+
+// Serve starts a server answering calls with handle
+func Serve(handle Handler) *Server {
+	s := &Server{listener: bufconn.Listen(1 << 20)}
+	s.server = grpc.NewServer(grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		fullMethodName, ok := grpc.MethodFromServerStream(stream)
+		if !ok {
+			return status.Error(codes.Internal, "no method in stream")
+		}
+		method, err := FindMethod(fullMethodName)
+		if err != nil {
+			return status.Error(codes.Unimplemented, err.Error())
+		}
+		return handle(method, stream)
+	}))
+	go s.server.Serve(s.listener)
+	return s
+}
+
+// Dial connects to the server, see grpc.WithContextDialer
+func (s *Server) Dial(ctx context.Context, _ string) (net.Conn, error) {
+	return s.listener.DialContext(ctx)
+}
+
+// Stop closes the connections to the server and ends the calls it is answering
+func (s *Server) Stop() {
+	s.server.Stop()
+}
+
+// FindMethod looks up the descriptor of a fully qualified method, e.g. /pkg.Service/Method.
+// Client streaming methods are not supported
+func FindMethod(fullMethodName string) (protoreflect.MethodDescriptor, error) {
+	service, name, ok := strings.Cut(strings.TrimPrefix(fullMethodName, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("malformed method name %q", fullMethodName)
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name))
+	if md == nil {
+		return nil, fmt.Errorf("service %s has no method %s", service, name)
+	}
+	if md.IsStreamingClient() {
+		return nil, fmt.Errorf("client streaming method %s is not supported", fullMethodName)
+	}
+	return md, nil
+}
+
+// NewMessage returns an empty message of the type md describes
+func NewMessage(md protoreflect.MessageDescriptor) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
+
+// ParseCode parses the name of a code, either as written by codes.Code.String, e.g.
+// NotFound, or in the canonical form, e.g. NOT_FOUND. Empty is OK
+func ParseCode(name string) (codes.Code, error) {
+	if name == "" {
+		return codes.OK, nil
+	}
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if code.String() == name {
+			return code, nil
+		}
+	}
+	var code codes.Code
+	if err := code.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+		return 0, fmt.Errorf("unknown status code %q", name)
+	}
+	return code, nil
+}
+
+// NewStatus builds a status from the name of its code, its message and its details
+// written as protojson, e.g. {"@type": "type.googleapis.com/google.rpc.ErrorInfo", ...}
+func NewStatus(code, message string, details []json.RawMessage) (*status.Status, error) {
+	c, err := ParseCode(code)
+	if err != nil {
+		return nil, err
+	}
+	st := &spb.Status{Code: int32(c), Message: message}
+	for i, detail := range details {
+		a := &anypb.Any{}
+		if err := protojson.Unmarshal(detail, a); err != nil {
+			return nil, fmt.Errorf("status detail %d: %w", i+1, err)
+		}
+		st.Details = append(st.Details, a)
+	}
+	return status.FromProto(st), nil
+}
+
+// Wait returns after d, or with the status of ctx once it is done
+func Wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
@@ -0,0 +1,308 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const instrumentationName = "github.com/TylerJGabb/grpc-http-proxy"
+
+// Config describes where spans are exported and how many are kept
+type Config struct {
+	// Exporter is otlp or stdout
+	Exporter string `json:"exporter"`
+	// Endpoint is the host:port of the otlp collector, the OTEL_EXPORTER_OTLP_* variables apply when empty
+	Endpoint string `json:"endpoint"`
+	// Insecure sends spans to the collector without TLS
+	Insecure bool `json:"insecure"`
+	// ServiceName is the service.name of the spans, grpc-http-proxy by default
+	ServiceName string `json:"serviceName"`
+	// SampleRate is the fraction of traces started by the proxy that are sampled, 1 by default.
+	// Traces started by the caller follow the caller's sampling decision
+	SampleRate float64 `json:"sampleRate"`
+	// MessageSampleRate is the fraction of stream messages recorded as span events, 1 by default
+	MessageSampleRate *float64 `json:"messageSampleRate"`
+}
+
+// NewProvider returns a provider batching spans to the exporter described by cfg,
+// stdout writes to w
+func NewProvider(cfg Config, w io.Writer) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(cfg.Exporter) {
+	case "otlp":
+		var opts []otlptracegrpc.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		// the client connects lazily, so this does not wait for the collector
+		exporter, err = otlptracegrpc.New(context.Background(), opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("trace exporter %q: must be otlp or stdout", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("trace exporter: %w", err)
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "grpc-http-proxy"
+	}
+	sampleRate := cfg.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRate))),
+	), nil
+}
+
+// Tracer traces proxied calls: a server span for every http request or websocket
+// session, and a client span for every grpc call made while handling it
+type Tracer struct {
+	tracer            trace.Tracer
+	propagator        propagation.TextMapPropagator
+	messageSampleRate float64
+}
+
+// New returns a tracer creating its spans with provider, messageSampleRate is the
+// fraction of stream messages recorded as events of the client span
+func New(provider trace.TracerProvider, messageSampleRate float64) *Tracer {
+	return &Tracer{
+		tracer:            provider.Tracer(instrumentationName),
+		propagator:        propagation.TraceContext{},
+		messageSampleRate: messageSampleRate,
+	}
+}
+
+// Middleware starts the server span of a request, continuing the trace of the traceparent
+// header if the caller sent one. The span lasts until the handler returns, which for
+// websockets is the end of the session. The trace id is added to the request's logger
+func (t *Tracer) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := t.propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		attrs := []attribute.KeyValue{
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("client.address", c.ClientIP()),
+		}
+		if userAgent := c.Request.UserAgent(); userAgent != "" {
+			attrs = append(attrs, attribute.String("user_agent.original", userAgent))
+		}
+		if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			attrs = append(attrs, attribute.String("network.protocol.name", "websocket"))
+		}
+		ctx, span := t.tracer.Start(ctx, c.Request.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		if sc := span.SpanContext(); sc.IsValid() {
+			logging.With(c, "trace_id", sc.TraceID().String())
+		}
+
+		c.Next()
+
+		if route := c.FullPath(); route != "" {
+			span.SetName(c.Request.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+		httpStatus := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", httpStatus))
+		if httpStatus >= http.StatusInternalServerError {
+			span.SetStatus(otelcodes.Error, http.StatusText(httpStatus))
+		}
+	}
+}
+
+// DialOptions add the client spans to the calls made on a grpc connection
+func (t *Tracer) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(t.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(t.streamInterceptor),
+	}
+}
+
+// startClientSpan starts the span of a call to method and propagates it in the outgoing metadata
+func (t *Tracer) startClientSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	ctx, span := t.tracer.Start(ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", name),
+		),
+	)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	t.propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// endClientSpan records the grpc status of a call and ends its span
+func endClientSpan(span trace.Span, err error) {
+	st := status.Convert(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(st.Code())))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, st.Message())
+	}
+	span.End()
+}
+
+func (t *Tracer) unaryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	ctx, span := t.startClientSpan(ctx, method)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	endClientSpan(span, err)
+	return err
+}
+
+func (t *Tracer) streamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	ctx, span := t.startClientSpan(ctx, method)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		endClientSpan(span, err)
+		return nil, err
+	}
+	ts := &tracedStream{ClientStream: cs, tracer: t, span: span}
+	// the caller may stop receiving without seeing the end of the stream, e.g. when
+	// the http client goes away, so the span also ends with the call's context
+	ts.stop = context.AfterFunc(ctx, func() { ts.end(ctx.Err()) })
+	return ts, nil
+}
+
+// tracedStream records the messages of a stream as events and ends its span with the stream
+type tracedStream struct {
+	grpc.ClientStream
+	tracer *Tracer
+	span   trace.Span
+	stop   func() bool
+
+	mu       sync.Mutex
+	sent     int64
+	received int64
+	endOnce  sync.Once
+}
+
+func (ts *tracedStream) SendMsg(m any) error {
+	err := ts.ClientStream.SendMsg(m)
+	if err == nil {
+		ts.mu.Lock()
+		ts.sent++
+		id := ts.sent
+		ts.mu.Unlock()
+		ts.message("SENT", id, m)
+	}
+	return err
+}
+
+func (ts *tracedStream) RecvMsg(m any) error {
+	err := ts.ClientStream.RecvMsg(m)
+	if err != nil {
+		ts.stop()
+		if err == io.EOF {
+			ts.end(nil)
+		} else {
+			ts.end(err)
+		}
+		return err
+	}
+	ts.mu.Lock()
+	ts.received++
+	id := ts.received
+	ts.mu.Unlock()
+	ts.message("RECEIVED", id, m)
+	return nil
+}
+
+// message records a message as an event of the stream's span, if it is sampled
+func (ts *tracedStream) message(messageType string, id int64, m any) {
+	rate := ts.tracer.messageSampleRate
+	if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("rpc.message.type", messageType),
+		attribute.Int64("rpc.message.id", id),
+	}
+	if msg, ok := m.(proto.Message); ok {
+		attrs = append(attrs, attribute.Int("rpc.message.uncompressed_size", proto.Size(msg)))
+	}
+	ts.span.AddEvent("message", trace.WithAttributes(attrs...))
+}
+
+func (ts *tracedStream) end(err error) {
+	ts.endOnce.Do(func() {
+		ts.mu.Lock()
+		ts.span.SetAttributes(
+			attribute.Int64("rpc.grpc.messages_sent", ts.sent),
+			attribute.Int64("rpc.grpc.messages_received", ts.received),
+		)
+		ts.mu.Unlock()
+		endClientSpan(ts.span, err)
+	})
+}
+
+// metadataCarrier lets the propagator write to grpc metadata
+type metadataCarrier metadata.MD
+
+func (mc metadataCarrier) Get(key string) string {
+	if values := metadata.MD(mc).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (mc metadataCarrier) Set(key, value string) {
+	metadata.MD(mc).Set(key, value)
+}
+
+func (mc metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for key := range mc {
+		keys = append(keys, key)
+	}
+	return keys
+}
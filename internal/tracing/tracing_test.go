@@ -0,0 +1,73 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddleware(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := tracing.New(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), 1)
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.Use(tracer.Middleware())
+	app.GET("/items/:id", func(c *gin.Context) {
+		c.Status(http.StatusBadGateway)
+	})
+
+	req, _ := http.NewRequest("GET", "/items/7", nil)
+	app.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected a single span, got %d\n", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /items/:id" {
+		t.Fatalf("expected the span to be named after the route, got %s\n", span.Name)
+	}
+	if span.Status.Code != codes.Error {
+		t.Fatalf("expected a server error to fail the span, got %v\n", span.Status)
+	}
+	attrs := attribute.NewSet(span.Attributes...)
+	if value, _ := attrs.Value("http.response.status_code"); value.AsInt64() != http.StatusBadGateway {
+		t.Fatalf("expected the status code attribute, got %v\n", value)
+	}
+	if value, _ := attrs.Value("url.path"); value.AsString() != "/items/7" {
+		t.Fatalf("expected the path attribute, got %v\n", value)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	t.Run("writes spans to stdout", func(t *testing.T) {
+		var out bytes.Buffer
+		provider, err := tracing.NewProvider(tracing.Config{Exporter: "stdout", ServiceName: "test"}, &out)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+		_, span := provider.Tracer("test").Start(context.Background(), "work")
+		span.End()
+		if err := provider.Shutdown(context.Background()); err != nil {
+			t.Fatalf("unexpected error shutting down: %v\n", err)
+		}
+		if !bytes.Contains(out.Bytes(), []byte(`"Name":"work"`)) {
+			t.Fatalf("expected the span to be written, got %s\n", out.String())
+		}
+	})
+
+	t.Run("rejects unknown exporters", func(t *testing.T) {
+		if _, err := tracing.NewProvider(tracing.Config{Exporter: "zipkin"}, nil); err == nil {
+			t.Fatalf("expected an error\n")
+		}
+	})
+}
@@ -2,13 +2,23 @@ package unary
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"io"
+	"log/slog"
+	"strconv"
+	"time"
 
+	"github.com/TylerJGabb/grpc-http-proxy/internal/breaker"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/codec"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/intercept"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/retry"
 	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
-	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -16,33 +26,229 @@ type ProxyMessage interface {
 	tgsbpb.UnaryCallIntRequest | tgsbpb.UnaryCallStringRequest
 }
 
+type proxyConfig struct {
+	codec         codec.Codec
+	retryPolicy   *retry.Policy
+	breaker       *breaker.Breaker
+	requestChecks []func(c *gin.Context, request proto.Message) error
+	completions   []func(c *gin.Context, request proto.Message, err error, elapsed time.Duration)
+	observers     []Observer
+	responseHooks []func(c *gin.Context, response proto.Message)
+	interceptors  intercept.Chain
+}
+
+// Observer is told the size of the payloads of every call, nil funcs are skipped
+type Observer struct {
+	// Request is called with the size of the request body once it has been decoded
+	Request func(size int)
+	// Response is called with the size of the response body before it is written
+	Response func(size int)
+}
+
+type OptFunc func(*proxyConfig)
+
+// WithCodec sets the codec used to decode the request body and encode the response,
+// protojson is used by default
+func WithCodec(c codec.Codec) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.codec = c
+	}
+}
+
+// WithRetryPolicy retries or hedges the call according to policy and reports the
+// number of attempts in the retry.AttemptsHeader response header
+func WithRetryPolicy(policy retry.Policy) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.retryPolicy = &policy
+	}
+}
+
+// WithBreaker fails calls fast while the breaker is open and records the outcome of every attempt
+func WithBreaker(b *breaker.Breaker) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.breaker = b
+	}
+}
+
+// WithRequestCheck runs check on the decoded request before the call, e.g. to validate or
+// authorize it. Checks run in the order they are added. A check failing with a grpc status
+// is answered with the matching http status, and with the status as json if it has details
+func WithRequestCheck(check func(c *gin.Context, request proto.Message) error) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.requestChecks = append(pc.requestChecks, check)
+	}
+}
+
+// WithCompletion calls complete once the call has been answered, with the decoded request,
+// the error the call failed with and the time it took. request is nil when the body could not
+// be decoded, err is nil for successful calls and carries a grpc status otherwise
+func WithCompletion(complete func(c *gin.Context, request proto.Message, err error, elapsed time.Duration)) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.completions = append(pc.completions, complete)
+	}
+}
+
+// WithObserver adds an observer of the payloads of the calls
+func WithObserver(o Observer) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.observers = append(pc.observers, o)
+	}
+}
+
+// WithResponseHook calls hook with the response of every successful call before it is written
+func WithResponseHook(hook func(c *gin.Context, response proto.Message)) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.responseHooks = append(pc.responseHooks, hook)
+	}
+}
+
+// WithInterceptor adds an interceptor of the stages of the calls, interceptors run in the
+// order they are added. Its stream funcs are not used
+func WithInterceptor(i intercept.Interceptor) OptFunc {
+	return func(pc *proxyConfig) {
+		pc.interceptors = append(pc.interceptors, i)
+	}
+}
+
+// writeError answers a call that was refused with the http status of err, and with its
+// status as json if it has details or its message otherwise, as streams do
+func writeError(c *gin.Context, err error) {
+	if body := grpcerr.StatusJSON(err); body != nil {
+		c.Data(grpcerr.HTTPStatus(err), codec.ContentTypeJSON, body)
+		return
+	}
+	c.String(grpcerr.HTTPStatus(err), "%s", status.Convert(err).Message())
+}
+
+// guardedCall wraps callFunc so that every attempt goes through the breaker, if any. An
+// open breaker ends the call, retrying into it would only delay the failure
+func guardedCall[T, U proto.Message](
+	cfg *proxyConfig,
+	request T,
+	callFunc func(context.Context, T, ...grpc.CallOption) (U, error),
+) func(context.Context) (U, error) {
+	return func(ctx context.Context) (U, error) {
+		if cfg.breaker == nil {
+			return callFunc(ctx, request)
+		}
+		if err := cfg.breaker.Allow(); err != nil {
+			var zero U
+			return zero, retry.Permanent(err)
+		}
+		start := time.Now()
+		response, err := callFunc(ctx, request)
+		cfg.breaker.Record(err, time.Since(start))
+		return response, err
+	}
+}
+
 func ProxyRequest[T, U proto.Message](
 	c *gin.Context,
 	emptyRequest T,
 	callFunc func(context.Context, T, ...grpc.CallOption) (U, error),
+	opts ...OptFunc,
 ) {
+	cfg := &proxyConfig{codec: codec.JSON{}}
+	for _, optFunc := range opts {
+		optFunc(cfg)
+	}
+	start := time.Now()
+	var (
+		request  proto.Message
+		outcome  error
+		attempts int
+	)
+	// every call ends with a single event, whichever step it failed at
+	defer func() {
+		elapsed := time.Since(start)
+		logArgs := []any{}
+		if cfg.retryPolicy != nil {
+			logArgs = append(logArgs, slog.Int("attempts", attempts))
+		}
+		logging.Outcome(c.Request.Context(), logging.FromContext(c.Request.Context()), "unary call", outcome, elapsed, logArgs...)
+		for _, complete := range cfg.completions {
+			complete(c, request, outcome, elapsed)
+		}
+	}()
+
+	if err := cfg.interceptors.BeforeDecode(c); err != nil {
+		outcome = err
+		writeError(c, err)
+		return
+	}
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		fmt.Printf("error reading request body: %v", err)
+		outcome = status.Errorf(codes.Unknown, "reading request body: %v", err)
 		return
 	}
 
-	if err := protojson.Unmarshal(body, emptyRequest); err != nil {
-		fmt.Printf("error unmarshalling request body: %v", err)
+	if err := cfg.codec.Unmarshal(body, emptyRequest); err != nil {
+		outcome = status.Errorf(codes.InvalidArgument, "unmarshalling request body: %v", err)
 		c.String(400, "error unmarshalling request body: %v", err)
+		return
+	}
+	request = emptyRequest
+	for _, o := range cfg.observers {
+		if o.Request != nil {
+			o.Request(len(body))
+		}
+	}
+	if err := cfg.interceptors.AfterDecode(c, emptyRequest); err != nil {
+		outcome = err
+		writeError(c, err)
+		return
+	}
+	for _, check := range cfg.requestChecks {
+		if err := check(c, emptyRequest); err != nil {
+			outcome = err
+			writeError(c, err)
+			return
+		}
+	}
+	if err := cfg.interceptors.BeforeCall(c, emptyRequest); err != nil {
+		outcome = err
+		writeError(c, err)
+		return
 	}
 
-	response, err := callFunc(c, emptyRequest)
+	// a single attempt unless a retry policy is configured
+	policy := retry.Policy{}
+	if cfg.retryPolicy != nil {
+		policy = *cfg.retryPolicy
+	}
+	var response U
+	response, attempts, err = retry.Do(c.Request.Context(), policy, guardedCall(cfg, emptyRequest, callFunc))
+	if cfg.retryPolicy != nil {
+		c.Header(retry.AttemptsHeader, strconv.Itoa(attempts))
+	}
+	if errors.Is(err, breaker.ErrOpen) {
+		c.Header("Retry-After", strconv.Itoa(cfg.breaker.RetryAfterSeconds()))
+	}
 	if err != nil {
-		fmt.Printf("error proxying request: %v", err)
-		c.String(500, "error calling proxying: %v", err)
+		outcome = err
+		c.String(grpcerr.HTTPStatus(err), "error calling proxying: %v", err)
+		return
 	}
 
-	responseBody, err := protojson.Marshal(response)
+	if err := cfg.interceptors.Response(c, response); err != nil {
+		outcome = err
+		writeError(c, err)
+		return
+	}
+	responseBody, err := cfg.codec.Marshal(response)
 	if err != nil {
-		fmt.Printf("error marshalling response: %v", err)
+		outcome = status.Errorf(codes.Internal, "marshalling response: %v", err)
 		c.String(500, "error marshalling response: %v", err)
+		return
 	}
 
-	c.Data(200, "application/json", responseBody)
+	for _, hook := range cfg.responseHooks {
+		hook(c, response)
+	}
+	for _, o := range cfg.observers {
+		if o.Response != nil {
+			o.Response(len(responseBody))
+		}
+	}
+	c.Data(200, cfg.codec.ContentType(), responseBody)
 }
@@ -4,13 +4,21 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/breaker"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/intercept"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/retry"
 	"github.com/TylerJGabb/grpc-http-proxy/internal/unary"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -108,4 +116,148 @@ func Test_ProxyRequest(t *testing.T) {
 		}
 	})
 
+	t.Run("retries unavailable backend and reports attempts", func(t *testing.T) {
+		app := gin.New()
+		calls := 0
+		callFunc := func(_ context.Context, v *wrapperspb.StringValue, _ ...grpc.CallOption) (*wrapperspb.StringValue, error) {
+			calls++
+			if calls < 2 {
+				return nil, status.Error(codes.Unavailable, "backend is deploying")
+			}
+			return v, nil
+		}
+		policy := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+		app.POST("/test", func(c *gin.Context) {
+			unary.ProxyRequest(c, &wrapperspb.StringValue{}, callFunc, unary.WithRetryPolicy(policy))
+		})
+		req, _ := http.NewRequest("POST", "/test", bytes.NewBufferString(`"abcd"`))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusOK, w.Code)
+		}
+		if w.Header().Get(retry.AttemptsHeader) != "2" {
+			t.Fatalf("Expected %s header to be 2, got %s\n", retry.AttemptsHeader, w.Header().Get(retry.AttemptsHeader))
+		}
+	})
+
+	t.Run("open breaker fails fast with 503", func(t *testing.T) {
+		app := gin.New()
+		mockedCallFunc := &mockCallFunc{}
+		b := breaker.New(breaker.Config{MinRequests: 1})
+		b.Allow()
+		b.Record(status.Error(codes.Unavailable, "backend is melting down"), 0)
+		app.POST("/test", func(c *gin.Context) {
+			unary.ProxyRequest(c, &wrapperspb.StringValue{}, mockedCallFunc.callFunc, unary.WithBreaker(b))
+		})
+		req, _ := http.NewRequest("POST", "/test", bytes.NewBufferString(`"abcd"`))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusServiceUnavailable, w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Fatalf("Expected Retry-After header to be set\n")
+		}
+		if mockedCallFunc.receivedValue != "" {
+			t.Fatalf("Expected callFunc not to be called, it received %s\n", mockedCallFunc.receivedValue)
+		}
+	})
+
+	t.Run("open breaker is not retried", func(t *testing.T) {
+		app := gin.New()
+		mockedCallFunc := &mockCallFunc{}
+		b := breaker.New(breaker.Config{MinRequests: 1})
+		b.Allow()
+		b.Record(status.Error(codes.Unavailable, "backend is melting down"), 0)
+		policy := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Second}
+		app.POST("/test", func(c *gin.Context) {
+			unary.ProxyRequest(c, &wrapperspb.StringValue{}, mockedCallFunc.callFunc, unary.WithBreaker(b), unary.WithRetryPolicy(policy))
+		})
+		req, _ := http.NewRequest("POST", "/test", bytes.NewBufferString(`"abcd"`))
+		w := httptest.NewRecorder()
+		start := time.Now()
+		app.ServeHTTP(w, req)
+		if elapsed := time.Since(start); elapsed >= policy.InitialBackoff {
+			t.Fatalf("Expected the call to fail without backing off, took %v\n", elapsed)
+		}
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("Expected a 503 with Retry-After, got %d %v\n", w.Code, w.Header())
+		}
+		if w.Header().Get(retry.AttemptsHeader) != "1" {
+			t.Fatalf("Expected %s header to be 1, got %s\n", retry.AttemptsHeader, w.Header().Get(retry.AttemptsHeader))
+		}
+		if mockedCallFunc.receivedValue != "" {
+			t.Fatalf("Expected callFunc not to be called, it received %s\n", mockedCallFunc.receivedValue)
+		}
+	})
+
+	t.Run("interceptors rewrite the request and response in order", func(t *testing.T) {
+		app := gin.New()
+		mockedCallFunc := &mockCallFunc{valueToReturn: "response"}
+		var stages []string
+		appendValue := func(stage string) func(c *gin.Context, m proto.Message) error {
+			return func(c *gin.Context, m proto.Message) error {
+				stages = append(stages, stage)
+				m.(*wrapperspb.StringValue).Value += "-" + stage
+				return nil
+			}
+		}
+		interceptor := intercept.Interceptor{
+			BeforeDecode: func(c *gin.Context) error {
+				stages = append(stages, "beforeDecode")
+				return nil
+			},
+			AfterDecode: appendValue("afterDecode"),
+			BeforeCall:  appendValue("beforeCall"),
+			Response:    appendValue("response"),
+		}
+		app.POST("/test", func(c *gin.Context) {
+			unary.ProxyRequest(c, &wrapperspb.StringValue{}, mockedCallFunc.callFunc, unary.WithInterceptor(interceptor))
+		})
+		req, _ := http.NewRequest("POST", "/test", bytes.NewBufferString(`"abcd"`))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != `"response-response"` {
+			t.Fatalf("Expected the intercepted response, got %d %s\n", w.Code, w.Body.String())
+		}
+		if mockedCallFunc.receivedValue != "abcd-afterDecode-beforeCall" {
+			t.Fatalf("Expected callFunc to receive the intercepted request, got %s\n", mockedCallFunc.receivedValue)
+		}
+		if strings.Join(stages, ",") != "beforeDecode,afterDecode,beforeCall,response" {
+			t.Fatalf("Unexpected stages %v\n", stages)
+		}
+	})
+
+	t.Run("interceptor rejecting the request answers with its status", func(t *testing.T) {
+		app := gin.New()
+		mockedCallFunc := &mockCallFunc{}
+		var completed error
+		interceptor := intercept.Interceptor{
+			BeforeCall: func(c *gin.Context, request proto.Message) error {
+				return status.Error(codes.PermissionDenied, "not today")
+			},
+		}
+		app.POST("/test", func(c *gin.Context) {
+			unary.ProxyRequest(c, &wrapperspb.StringValue{}, mockedCallFunc.callFunc,
+				unary.WithInterceptor(interceptor),
+				unary.WithCompletion(func(c *gin.Context, request proto.Message, err error, elapsed time.Duration) {
+					completed = err
+				}),
+			)
+		})
+		req, _ := http.NewRequest("POST", "/test", bytes.NewBufferString(`"abcd"`))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden || w.Body.String() != "not today" {
+			t.Fatalf("Expected status code %d with the status message, got %d %q\n", http.StatusForbidden, w.Code, w.Body.String())
+		}
+		if mockedCallFunc.receivedValue != "" {
+			t.Fatalf("Expected callFunc not to be called, it received %s\n", mockedCallFunc.receivedValue)
+		}
+		if status.Code(completed) != codes.PermissionDenied {
+			t.Fatalf("Expected the call to complete with PERMISSION_DENIED, got %v\n", completed)
+		}
+	})
+
 }
@@ -0,0 +1,307 @@
+// Package validate checks request messages against the field constraints declared with
+// protoc-gen-validate options, e.g. [(validate.rules).string.min_len = 1]
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/envoyproxy/protoc-gen-validate/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Message validates m and its nested messages. The supported constraints are message
+// required, the const, lt, lte, gt, gte, in and not_in rules of numbers, the const, len,
+// min_len, max_len, pattern, prefix, suffix, contains, in and not_in rules of strings,
+// the const, defined_only, in and not_in rules of enums, and min_items, max_items and
+// items of repeated fields. In strict mode, fields unknown to the descriptor are rejected too,
+// which only the proto codec keeps, as protojson refuses them while decoding.
+// Violations are returned as an INVALID_ARGUMENT status carrying a google.rpc.BadRequest
+func Message(m proto.Message, strict bool) error {
+	var violations []*errdetails.BadRequest_FieldViolation
+	check(m.ProtoReflect(), "", strict, &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("invalid request: %s %s", fieldOrRoot(violations[0].Field), violations[0].Description))
+	withDetails, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+func fieldOrRoot(field string) string {
+	if field == "" {
+		return "request"
+	}
+	return field
+}
+
+func check(m protoreflect.Message, path string, strict bool, violations *[]*errdetails.BadRequest_FieldViolation) {
+	add := func(field, description string) {
+		*violations = append(*violations, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+	}
+	if strict && len(m.GetUnknown()) > 0 {
+		add(path, "has unknown fields")
+	}
+
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		fieldPath := join(path, fd.JSONName())
+		rules, _ := proto.GetExtension(fd.Options(), validate.E_Rules).(*validate.FieldRules)
+		// only the member of a oneof that is set is checked, which covers proto3 optional
+		// fields as they are members of synthetic oneofs
+		if fd.ContainingOneof() != nil && !m.Has(fd) {
+			continue
+		}
+
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() {
+			if !m.Has(fd) {
+				if rules.GetMessage().GetRequired() {
+					add(fieldPath, "is required")
+				}
+				continue
+			}
+			if !rules.GetMessage().GetSkip() {
+				check(m.Get(fd).Message(), fieldPath, strict, violations)
+			}
+			continue
+		}
+
+		if fd.IsList() {
+			list := m.Get(fd).List()
+			if repeated := rules.GetRepeated(); repeated != nil {
+				if repeated.MinItems != nil && uint64(list.Len()) < repeated.GetMinItems() {
+					add(fieldPath, fmt.Sprintf("must have at least %d items", repeated.GetMinItems()))
+				}
+				if repeated.MaxItems != nil && uint64(list.Len()) > repeated.GetMaxItems() {
+					add(fieldPath, fmt.Sprintf("must have at most %d items", repeated.GetMaxItems()))
+				}
+			}
+			for j := 0; j < list.Len(); j++ {
+				itemPath := fmt.Sprintf("%s[%d]", fieldPath, j)
+				if fd.Message() != nil {
+					check(list.Get(j).Message(), itemPath, strict, violations)
+					continue
+				}
+				if items := rules.GetRepeated().GetItems(); items != nil {
+					for _, description := range checkScalar(fd, items, list.Get(j)) {
+						add(itemPath, description)
+					}
+				}
+			}
+			continue
+		}
+
+		if fd.IsMap() {
+			if fd.MapValue().Message() != nil {
+				m.Get(fd).Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+					check(value.Message(), fmt.Sprintf("%s[%v]", fieldPath, key.Interface()), strict, violations)
+					return true
+				})
+			}
+			continue
+		}
+
+		if rules != nil {
+			for _, description := range checkScalar(fd, rules, m.Get(fd)) {
+				add(fieldPath, description)
+			}
+		}
+	}
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// checkScalar applies the rules of a number, string or enum value
+func checkScalar(fd protoreflect.FieldDescriptor, rules *validate.FieldRules, v protoreflect.Value) []string {
+	typeRules := rules.ProtoReflect()
+	oneof := typeRules.Descriptor().Oneofs().ByName("type")
+	set := typeRules.WhichOneof(oneof)
+	if set == nil {
+		return nil
+	}
+	// rules for another type than the field's are ignored, like protoc-gen-validate refuses them
+	switch {
+	case fd.Kind() == protoreflect.BoolKind || fd.Kind() == protoreflect.BytesKind:
+		return nil
+	case fd.Kind() == protoreflect.StringKind && set.Name() == "string":
+		return checkString(rules.GetString_(), v.String())
+	case fd.Kind() == protoreflect.EnumKind && set.Name() == "enum":
+		return checkEnum(fd, rules.GetEnum(), v.Enum())
+	case string(set.Name()) == fd.Kind().String():
+		return checkNumber(fd.Kind(), typeRules.Get(set).Message(), v)
+	default:
+		return nil
+	}
+}
+
+// checkNumber applies numeric rules, which have the same field names for every numeric type
+func checkNumber(kind protoreflect.Kind, r protoreflect.Message, v protoreflect.Value) []string {
+	var descriptions []string
+	get := func(name string) (protoreflect.Value, bool) {
+		fd := r.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil || !r.Has(fd) {
+			return protoreflect.Value{}, false
+		}
+		return r.Get(fd), true
+	}
+	for _, c := range []struct {
+		name        string
+		fails       func(cmp int) bool
+		description string
+	}{
+		{"const", func(cmp int) bool { return cmp != 0 }, "must equal %v"},
+		{"lt", func(cmp int) bool { return cmp >= 0 }, "must be less than %v"},
+		{"lte", func(cmp int) bool { return cmp > 0 }, "must be at most %v"},
+		{"gt", func(cmp int) bool { return cmp <= 0 }, "must be greater than %v"},
+		{"gte", func(cmp int) bool { return cmp < 0 }, "must be at least %v"},
+	} {
+		if bound, ok := get(c.name); ok && c.fails(compare(kind, v, bound)) {
+			descriptions = append(descriptions, fmt.Sprintf(c.description, bound.Interface()))
+		}
+	}
+	if in, ok := get("in"); ok && in.List().Len() > 0 && !listContains(kind, in.List(), v) {
+		descriptions = append(descriptions, fmt.Sprintf("must be one of %v", listString(in.List())))
+	}
+	if notIn, ok := get("not_in"); ok && listContains(kind, notIn.List(), v) {
+		descriptions = append(descriptions, fmt.Sprintf("must not be one of %v", listString(notIn.List())))
+	}
+	return descriptions
+}
+
+func compare(kind protoreflect.Kind, a, b protoreflect.Value) int {
+	switch kind {
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return cmp3(a.Float(), b.Float())
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+		return cmp3(a.Uint(), b.Uint())
+	default:
+		return cmp3(a.Int(), b.Int())
+	}
+}
+
+func cmp3[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func listContains(kind protoreflect.Kind, list protoreflect.List, v protoreflect.Value) bool {
+	for i := 0; i < list.Len(); i++ {
+		if compare(kind, v, list.Get(i)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func listString(list protoreflect.List) string {
+	items := make([]string, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		items = append(items, fmt.Sprint(list.Get(i).Interface()))
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+var patterns sync.Map
+
+func checkString(r *validate.StringRules, s string) []string {
+	var descriptions []string
+	length := uint64(utf8.RuneCountInString(s))
+	if r.Const != nil && s != r.GetConst() {
+		descriptions = append(descriptions, fmt.Sprintf("must equal %q", r.GetConst()))
+	}
+	if r.Len != nil && length != r.GetLen() {
+		descriptions = append(descriptions, fmt.Sprintf("must be %d characters long", r.GetLen()))
+	}
+	if r.MinLen != nil && length < r.GetMinLen() {
+		descriptions = append(descriptions, fmt.Sprintf("must be at least %d characters long", r.GetMinLen()))
+	}
+	if r.MaxLen != nil && length > r.GetMaxLen() {
+		descriptions = append(descriptions, fmt.Sprintf("must be at most %d characters long", r.GetMaxLen()))
+	}
+	if r.Pattern != nil {
+		re, ok := patterns.Load(r.GetPattern())
+		if !ok {
+			compiled, err := regexp.Compile(r.GetPattern())
+			if err != nil {
+				return append(descriptions, fmt.Sprintf("has an invalid pattern %q", r.GetPattern()))
+			}
+			re, _ = patterns.LoadOrStore(r.GetPattern(), compiled)
+		}
+		if !re.(*regexp.Regexp).MatchString(s) {
+			descriptions = append(descriptions, fmt.Sprintf("must match %s", r.GetPattern()))
+		}
+	}
+	if r.Prefix != nil && !strings.HasPrefix(s, r.GetPrefix()) {
+		descriptions = append(descriptions, fmt.Sprintf("must start with %q", r.GetPrefix()))
+	}
+	if r.Suffix != nil && !strings.HasSuffix(s, r.GetSuffix()) {
+		descriptions = append(descriptions, fmt.Sprintf("must end with %q", r.GetSuffix()))
+	}
+	if r.Contains != nil && !strings.Contains(s, r.GetContains()) {
+		descriptions = append(descriptions, fmt.Sprintf("must contain %q", r.GetContains()))
+	}
+	if len(r.GetIn()) > 0 && !containsString(r.GetIn(), s) {
+		descriptions = append(descriptions, fmt.Sprintf("must be one of %q", r.GetIn()))
+	}
+	if containsString(r.GetNotIn(), s) {
+		descriptions = append(descriptions, fmt.Sprintf("must not be one of %q", r.GetNotIn()))
+	}
+	return descriptions
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func checkEnum(fd protoreflect.FieldDescriptor, r *validate.EnumRules, n protoreflect.EnumNumber) []string {
+	var descriptions []string
+	if r.Const != nil && int32(n) != r.GetConst() {
+		descriptions = append(descriptions, fmt.Sprintf("must equal %d", r.GetConst()))
+	}
+	if r.GetDefinedOnly() && fd.Enum().Values().ByNumber(n) == nil {
+		descriptions = append(descriptions, fmt.Sprintf("must be a defined %s value", fd.Enum().Name()))
+	}
+	if len(r.GetIn()) > 0 && !containsInt32(r.GetIn(), int32(n)) {
+		descriptions = append(descriptions, fmt.Sprintf("must be one of %v", r.GetIn()))
+	}
+	if containsInt32(r.GetNotIn(), int32(n)) {
+		descriptions = append(descriptions, fmt.Sprintf("must not be one of %v", r.GetNotIn()))
+	}
+	return descriptions
+}
+
+func containsInt32(values []int32, n int32) bool {
+	for _, v := range values {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package validate_test
+
+import (
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/validate"
+	pgv "github.com/envoyproxy/protoc-gen-validate/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, rules *pgv.FieldRules) *descriptorpb.FieldDescriptorProto {
+	opts := &descriptorpb.FieldOptions{}
+	if rules != nil {
+		proto.SetExtension(opts, pgv.E_Rules, rules)
+	}
+	fd := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Type:     typ.Enum(),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Options:  opts,
+	}
+	if typeName != "" {
+		fd.TypeName = proto.String(typeName)
+	}
+	return fd
+}
+
+// requestDescriptor builds a message with validation rules, as protoc would from
+//
+//	message Request {
+//	  string name = 1 [(validate.rules).string = {min_len: 1, pattern: "^[a-z]+$"}];
+//	  int32 count = 2 [(validate.rules).int32 = {gte: 1, lte: 10}];
+//	  Color color = 3 [(validate.rules).enum.defined_only = true];
+//	  Inner inner = 4 [(validate.rules).message.required = true];
+//	  repeated string tags = 5 [(validate.rules).repeated = {max_items: 2, items: {string: {in: ["a", "b"]}}}];
+//	  oneof contact {
+//	    string email = 6 [(validate.rules).string.contains = "@"];
+//	    string phone = 7 [(validate.rules).string.min_len = 5];
+//	  }
+//	  optional string nickname = 8 [(validate.rules).string.min_len = 2];
+//	}
+func requestDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	tags := field("tags", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", &pgv.FieldRules{Type: &pgv.FieldRules_Repeated{Repeated: &pgv.RepeatedRules{
+		MaxItems: proto.Uint64(2),
+		Items:    &pgv.FieldRules{Type: &pgv.FieldRules_String_{String_: &pgv.StringRules{In: []string{"a", "b"}}}},
+	}}})
+	tags.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	email := field("email", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", &pgv.FieldRules{Type: &pgv.FieldRules_String_{String_: &pgv.StringRules{Contains: proto.String("@")}}})
+	email.OneofIndex = proto.Int32(0)
+	phone := field("phone", 7, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", &pgv.FieldRules{Type: &pgv.FieldRules_String_{String_: &pgv.StringRules{MinLen: proto.Uint64(5)}}})
+	phone.OneofIndex = proto.Int32(0)
+	nickname := field("nickname", 8, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", &pgv.FieldRules{Type: &pgv.FieldRules_String_{String_: &pgv.StringRules{MinLen: proto.Uint64(2)}}})
+	nickname.OneofIndex = proto.Int32(1)
+	nickname.Proto3Optional = proto.Bool(true)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("validate_test.proto"),
+		Package:    proto.String("validatetest"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"validate/validate.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Color"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("COLOR_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("RED"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Inner"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", &pgv.FieldRules{Type: &pgv.FieldRules_String_{String_: &pgv.StringRules{Prefix: proto.String("id-")}}}),
+			}},
+			{Name: proto.String("Request"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", &pgv.FieldRules{Type: &pgv.FieldRules_String_{String_: &pgv.StringRules{MinLen: proto.Uint64(1), Pattern: proto.String("^[a-z]+$")}}}),
+				field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", &pgv.FieldRules{Type: &pgv.FieldRules_Int32{Int32: &pgv.Int32Rules{Gte: proto.Int32(1), Lte: proto.Int32(10)}}}),
+				field("color", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".validatetest.Color", &pgv.FieldRules{Type: &pgv.FieldRules_Enum{Enum: &pgv.EnumRules{DefinedOnly: proto.Bool(true)}}}),
+				field("inner", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".validatetest.Inner", &pgv.FieldRules{Message: &pgv.MessageRules{Required: proto.Bool(true)}}),
+				tags,
+				email,
+				phone,
+				nickname,
+			}, OneofDecl: []*descriptorpb.OneofDescriptorProto{
+				{Name: proto.String("contact")},
+				{Name: proto.String("_nickname")},
+			}},
+		},
+	}
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("failed to build test descriptor: %v\n", err)
+	}
+	return fd.Messages().ByName("Request")
+}
+
+func Test_Message(t *testing.T) {
+	md := requestDescriptor(t)
+	parse := func(t *testing.T, payload string) proto.Message {
+		m := dynamicpb.NewMessage(md)
+		if err := protojson.Unmarshal([]byte(payload), m); err != nil {
+			t.Fatalf("failed to parse %s: %v\n", payload, err)
+		}
+		return m
+	}
+	violations := func(t *testing.T, err error) map[string]string {
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Expected INVALID_ARGUMENT, got %v\n", err)
+		}
+		found := map[string]string{}
+		for _, detail := range status.Convert(err).Details() {
+			if br, ok := detail.(*errdetails.BadRequest); ok {
+				for _, v := range br.FieldViolations {
+					found[v.Field] = v.Description
+				}
+			}
+		}
+		return found
+	}
+
+	t.Run("accepts valid requests", func(t *testing.T) {
+		m := parse(t, `{"name":"abc","count":3,"color":"RED","inner":{"id":"id-1"},"tags":["a"]}`)
+		if err := validate.Message(m, true); err != nil {
+			t.Fatalf("did not expect error: %v\n", err)
+		}
+	})
+
+	t.Run("reports one violation per problem", func(t *testing.T) {
+		m := parse(t, `{"name":"ABC","count":11,"color":7,"tags":["a","c","b"]}`)
+		found := violations(t, validate.Message(m, false))
+		expected := map[string]string{
+			"name":    "must match ^[a-z]+$",
+			"count":   "must be at most 10",
+			"color":   "must be a defined Color value",
+			"inner":   "is required",
+			"tags":    "must have at most 2 items",
+			"tags[1]": `must be one of ["a" "b"]`,
+		}
+		if len(found) != len(expected) {
+			t.Fatalf("Expected %d violations, got %v\n", len(expected), found)
+		}
+		for field, description := range expected {
+			if found[field] != description {
+				t.Fatalf("Expected %s to %s, got %q\n", field, description, found[field])
+			}
+		}
+	})
+
+	t.Run("validates nested messages", func(t *testing.T) {
+		m := parse(t, `{"name":"abc","count":1,"inner":{"id":"x"}}`)
+		if found := violations(t, validate.Message(m, false)); found["inner.id"] != `must start with "id-"` {
+			t.Fatalf("Expected a violation of inner.id, got %v\n", found)
+		}
+	})
+
+	t.Run("checks only the oneof member and optional fields that are set", func(t *testing.T) {
+		m := parse(t, `{"name":"abc","count":1,"inner":{"id":"id-1"},"email":"a@b"}`)
+		if err := validate.Message(m, false); err != nil {
+			t.Fatalf("did not expect error for the unset phone and nickname: %v\n", err)
+		}
+		m = parse(t, `{"name":"abc","count":1,"inner":{"id":"id-1"},"phone":"123","nickname":""}`)
+		found := violations(t, validate.Message(m, false))
+		if len(found) != 2 || found["phone"] != "must be at least 5 characters long" || found["nickname"] == "" {
+			t.Fatalf("Expected violations of phone and nickname only, got %v\n", found)
+		}
+	})
+
+	t.Run("rejects unknown fields in strict mode only", func(t *testing.T) {
+		m := parse(t, `{"name":"abc","count":1,"inner":{"id":"id-1"}}`)
+		m.ProtoReflect().SetUnknown(protoreflect.RawFields{0x78, 0x01}) // field 15, varint 1
+		if err := validate.Message(m, false); err != nil {
+			t.Fatalf("did not expect error outside strict mode: %v\n", err)
+		}
+		if found := violations(t, validate.Message(m, true)); found[""] != "has unknown fields" {
+			t.Fatalf("Expected a violation for the unknown fields, got %v\n", found)
+		}
+	})
+}
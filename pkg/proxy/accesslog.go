@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"os"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/accesslog"
+)
+
+// AccessLogConfig describes the access log, see WithAccessLog
+type AccessLogConfig = accesslog.Config
+
+// AccessLog writes an entry per request, see NewAccessLog
+type AccessLog = accesslog.Logger
+
+// AccessLogEntry is a single line of the access log
+type AccessLogEntry = accesslog.Entry
+
+// NewAccessLog returns the access log of cfg, written to os.Stdout unless cfg names a file
+func NewAccessLog(cfg AccessLogConfig) (*AccessLog, error) {
+	return accesslog.New(cfg, os.Stdout)
+}
+
+// WithAccessLog writes an entry to l for every request in the common, combined or json
+// format. Streams are logged once they end, with their duration, the messages and bytes
+// in and out, the websocket close code and whether the client, the server or the proxy
+// ended them. The log is closed by Shutdown
+func WithAccessLog(
+	l *AccessLog,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.accessLog = l
+	}
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+)
+
+func Test_AccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := NewAccessLog(AccessLogConfig{Format: "json", File: path})
+	if err != nil {
+		t.Fatalf("did not expect error creating access log: %v\n", err)
+	}
+	hps := NewHttpProxyServer("localhost:0", WithAccessLog(l))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	if err := RegisterServerStream(hps, "/stream", openEndedStream, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+		WithStreamTransport(TransportServerSentEvents)); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/unary", strings.NewReader(`{"value":1}`)),
+		httptest.NewRequest("GET", "/stream?value=3&access_token=secret-token", nil),
+	} {
+		hps.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("did not expect error closing access log: %v\n", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("did not expect error opening access log: %v\n", err)
+	}
+	defer f.Close()
+	var entries []AccessLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AccessLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("did not expect error decoding %s: %v\n", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d\n", len(entries))
+	}
+
+	unary := entries[0]
+	if unary.Route != "/unary" || unary.Status != http.StatusOK || unary.Bytes == 0 || unary.RequestID == "" || unary.Stream != nil {
+		t.Fatalf("Unexpected unary entry %+v\n", unary)
+	}
+	stream := entries[1]
+	if stream.URI != "/stream?value=3&access_token=[REDACTED]" || strings.Contains(stream.URI, "secret-token") {
+		t.Fatalf("Expected the access token to be redacted, got %s\n", stream.URI)
+	}
+	if stream.Route != "/stream" || stream.Stream == nil {
+		t.Fatalf("Expected a stream entry, got %+v\n", stream)
+	}
+	if s := stream.Stream; s.Transport != "sse" || s.Initiator != "server" || s.Code != "OK" || s.MessagesIn != 1 || s.MessagesOut != 0 {
+		t.Fatalf("Unexpected stream details %+v\n", s)
+	}
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StreamInfo describes an open stream, see ActiveStreams
+type StreamInfo = serverstream.StreamInfo
+
+// StreamStats aggregates the streams the proxy has served, see StreamStats
+type StreamStats = serverstream.Stats
+
+// AdminConfig is the file based configuration of the admin listener
+type AdminConfig struct {
+	// Address is the host:port the admin listener binds to, e.g. localhost:9090
+	Address string `json:"address"`
+	// TokenFile holds the bearer token admin requests must carry, without it they are not authenticated
+	TokenFile string `json:"tokenFile"`
+}
+
+// TerminateRequest is the body of a request terminating a stream
+type TerminateRequest struct {
+	// Code is the grpc code the stream ends with, e.g. PERMISSION_DENIED, ABORTED by default
+	Code string `json:"code"`
+	// Message is the message of the status, and the reason of the websocket close frame
+	Message string `json:"message"`
+	// CloseCode is the websocket close code, the one matching Code by default
+	CloseCode int `json:"closeCode"`
+}
+
+// AdminStats is the body of the stats endpoint of the admin listener
+type AdminStats struct {
+	Streams         StreamStats            `json:"streams"`
+	Backends        []AdminBackendStatus   `json:"backends"`
+	CircuitBreakers []CircuitBreakerStatus `json:"circuitBreakers,omitempty"`
+}
+
+// AdminBackendStatus is the state of the connection to a backend
+type AdminBackendStatus struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	State  string `json:"state"`
+}
+
+// WithAdmin serves the admin api on its own listener at address, started by RunBlocking
+// and stopped by Shutdown. It answers
+//
+//	GET  /streams                 the open streams, see ActiveStreams
+//	POST /streams/:id/terminate   ends a stream, with a TerminateRequest body
+//	GET  /stats                   the stream statistics and the state of the backends
+//	GET  /metrics                 the metrics of WithMetrics, at the path of WithMetricsPath
+//
+// The api is not authenticated unless WithAdminToken is used, so address should only be
+// reachable by operators
+func WithAdmin(
+	address string,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.adminAddress = address
+	}
+}
+
+// WithAdminToken requires admin requests to carry token as a bearer token
+func WithAdminToken(
+	token string,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.adminToken = token
+	}
+}
+
+// ActiveStreams returns the streams that are open, oldest first
+func (hps *HttpProxyServer) ActiveStreams() []StreamInfo {
+	return hps.streams.List()
+}
+
+// TerminateStream ends the open stream with the given id with err, which should carry a grpc
+// status. Websockets are closed with closeCode, or the code matching err when it is 0, and
+// server-sent events end with an error event. It reports whether the stream was open
+func (hps *HttpProxyServer) TerminateStream(id string, err error, closeCode int) bool {
+	return hps.streams.Terminate(id, err, closeCode)
+}
+
+// StreamStats returns the aggregate statistics of the streams the proxy has served
+func (hps *HttpProxyServer) StreamStats() StreamStats {
+	return hps.streams.Stats()
+}
+
+// AdminHandler returns the admin api as an http.Handler, for serving it from a listener
+// of one's own instead of WithAdmin
+func (hps *HttpProxyServer) AdminHandler() http.Handler {
+	app := gin.New()
+	if hps.adminToken != "" {
+		app.Use(hps.requireAdminToken)
+	}
+	app.GET("/streams", func(c *gin.Context) {
+		c.JSON(http.StatusOK, hps.ActiveStreams())
+	})
+	app.POST("/streams/:id/terminate", hps.terminateStream)
+	app.GET("/stats", func(c *gin.Context) {
+		stats := AdminStats{Streams: hps.StreamStats(), CircuitBreakers: hps.CircuitBreakers()}
+		for _, conn := range hps.backendConns() {
+			stats.Backends = append(stats.Backends, AdminBackendStatus{Name: conn.Name, Target: conn.Target, State: conn.State.String()})
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+	if hps.metrics != nil {
+		app.GET(hps.metricsPath, gin.WrapH(hps.metrics.Handler()))
+	}
+	return app
+}
+
+func (hps *HttpProxyServer) requireAdminToken(c *gin.Context) {
+	token := auth.BearerToken(c.Request, "")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(hps.adminToken)) != 1 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+// sendableCloseCode reports whether an endpoint may send code in a close frame, RFC 6455
+// reserves 1004-1006 and 1015, and leaves the rest below 3000 unassigned
+func sendableCloseCode(code int) bool {
+	switch {
+	case code >= websocket.CloseNormalClosure && code <= websocket.CloseUnsupportedData:
+		return true
+	case code >= websocket.CloseInvalidFramePayloadData && code <= 1014:
+		return true
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
+
+func (hps *HttpProxyServer) terminateStream(c *gin.Context) {
+	req := TerminateRequest{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	code := codes.Aborted
+	if req.Code != "" {
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(req.Code))); err != nil || code == codes.OK {
+			c.String(http.StatusBadRequest, "unknown grpc code %q", req.Code)
+			return
+		}
+	}
+	// 0 picks the close code matching the grpc code
+	if req.CloseCode != 0 && !sendableCloseCode(req.CloseCode) {
+		c.String(http.StatusBadRequest, "close code %d: must be 1000-1003, 1007-1014 or 3000-4999", req.CloseCode)
+		return
+	}
+	if req.Message == "" {
+		req.Message = "stream terminated by an administrator"
+	}
+	if !hps.TerminateStream(c.Param("id"), status.Error(code, req.Message), req.CloseCode) {
+		c.String(http.StatusNotFound, "no open stream %s", c.Param("id"))
+		return
+	}
+	hps.logger.Info("stream terminated by admin",
+		slog.String("stream", c.Param("id")), slog.String("code", code.String()), slog.String("message", req.Message))
+	c.Status(http.StatusAccepted)
+}
+
+// runAdmin serves the admin api at its address until Shutdown closes it
+func (hps *HttpProxyServer) runAdmin() error {
+	listener, err := net.Listen("tcp", hps.adminAddress)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: hps.AdminHandler()}
+	hps.mu.Lock()
+	if hps.shutdown {
+		hps.mu.Unlock()
+		listener.Close()
+		return http.ErrServerClosed
+	}
+	hps.adminServer = server
+	hps.mu.Unlock()
+	hps.logger.Info("serving admin api", slog.String("addr", listener.Addr().String()))
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			hps.logger.Error("admin api stopped", slog.Any("error", err))
+		}
+	}()
+	return nil
+}
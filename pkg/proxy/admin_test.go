@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"google.golang.org/grpc"
+)
+
+// openStream is a server stream that stays open until its context is done
+type openStream struct {
+	ctx context.Context
+}
+
+func (s openStream) RecvMsg(any) error {
+	<-s.ctx.Done()
+	return s.ctx.Err()
+}
+
+func openOpenStream(
+	ctx context.Context,
+	_ *tgsbpb.ServerStreamIntRequest,
+	_ ...grpc.CallOption,
+) (openStream, error) {
+	return openStream{ctx: ctx}, nil
+}
+
+func Test_Admin(t *testing.T) {
+	hps := NewHttpProxyServer("localhost:0", WithAdmin("localhost:0"), WithAdminToken("admin-secret"))
+	if err := RegisterServerStream(hps, "/stream", openOpenStream, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+		WithStreamTransport(TransportServerSentEvents)); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	s := httptest.NewServer(hps.Handler())
+	defer s.Close()
+	admin := hps.AdminHandler()
+
+	serveAdmin := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("requires the admin token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest("GET", "/streams", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusUnauthorized, w.Code)
+		}
+	})
+
+	t.Run("lists and terminates a stream", func(t *testing.T) {
+		resp, err := http.Get(s.URL + "/stream?value=1")
+		if err != nil {
+			t.Fatalf("did not expect error opening stream: %v\n", err)
+		}
+		defer resp.Body.Close()
+
+		var streams []StreamInfo
+		json.Unmarshal(serveAdmin("GET", "/streams", "").Body.Bytes(), &streams)
+		if len(streams) != 1 || streams[0].Method != "/tgsbpb.TylerSandboxService/ServerStreamInt" || streams[0].Route != "/stream" {
+			t.Fatalf("Expected the open stream to be listed, got %+v\n", streams)
+		}
+
+		if w := serveAdmin("POST", "/streams/"+streams[0].ID+"/terminate", `{"code":"UNKNOWN_CODE"}`); w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected unknown codes to be refused, got %d\n", w.Code)
+		}
+		for _, closeCode := range []int{999, 1004, 1005, 1006, 1015, 2999, 5000, 70000} {
+			if w := serveAdmin("POST", "/streams/"+streams[0].ID+"/terminate", fmt.Sprintf(`{"closeCode":%d}`, closeCode)); w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected close code %d to be refused, got %d\n", closeCode, w.Code)
+			}
+		}
+		// valid codes get past the check, to the lookup of the stream
+		for _, closeCode := range []int{1000, 1001, 1002, 1003, 1007, 1008, 1011, 1012, 1013, 1014, 3000, 4999} {
+			if w := serveAdmin("POST", "/streams/unknown/terminate", fmt.Sprintf(`{"closeCode":%d}`, closeCode)); w.Code != http.StatusNotFound {
+				t.Fatalf("Expected close code %d to be accepted, got %d: %s\n", closeCode, w.Code, w.Body.String())
+			}
+		}
+		w := serveAdmin("POST", "/streams/"+streams[0].ID+"/terminate", `{"code":"PERMISSION_DENIED","message":"misbehaving"}`)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d, got %d: %s\n", http.StatusAccepted, w.Code, w.Body.String())
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(body), "event: error\ndata: misbehaving") {
+			t.Fatalf("Expected an error event, got %s\n", body)
+		}
+
+		// the stream is unregistered once its handler has returned
+		deadline := time.Now().Add(time.Second)
+		var stats AdminStats
+		for time.Now().Before(deadline) {
+			json.Unmarshal(serveAdmin("GET", "/stats", "").Body.Bytes(), &stats)
+			if stats.Streams.Active == 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if stats.Streams.Active != 0 || stats.Streams.Terminated != 1 || stats.Streams.ClosedBy["proxy"] != 1 {
+			t.Fatalf("Unexpected stats %+v\n", stats.Streams)
+		}
+		if w := serveAdmin("POST", "/streams/"+streams[0].ID+"/terminate", ""); w.Code != http.StatusNotFound {
+			t.Fatalf("Expected closed streams not to be found, got %d\n", w.Code)
+		}
+	})
+}
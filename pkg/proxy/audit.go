@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/audit"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/grpcerr"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// AuditConfig describes the audit log, see WithAudit
+type AuditConfig = audit.Config
+
+// AuditLogger writes audit events to a rotating file, see NewAuditLogger
+type AuditLogger = audit.Logger
+
+// AuditEvent is a single line of the audit log
+type AuditEvent = audit.Event
+
+// NewAuditLogger opens the audit log of cfg
+func NewAuditLogger(cfg AuditConfig) (*AuditLogger, error) {
+	return audit.New(cfg)
+}
+
+// WithAudit records every call of an audited method to logger as a json line, with the
+// caller's identity, the grpc code the call ended with, its latency and its redacted request.
+// Unary calls are recorded once answered and server streams once they end. Calls refused
+// without valid credentials, outside the caller's scopes or over a rate limit are recorded
+// with the code they were refused with and without a request. The logger is closed by Shutdown
+func WithAudit(
+	logger *AuditLogger,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.audit = logger
+	}
+}
+
+// auditHook records a call of an audited method once it has ended or was refused
+type auditHook func(c *gin.Context, request proto.Message, err error, elapsed time.Duration)
+
+// auditCall returns the completion hook of the method's routes, or nil if it is not audited
+func (hps *HttpProxyServer) auditCall(fullMethodName string, stream bool) auditHook {
+	if hps.audit == nil || !hps.audit.Audits(fullMethodName) {
+		return nil
+	}
+	return func(c *gin.Context, request proto.Message, err error, elapsed time.Duration) {
+		st := status.Convert(err)
+		ev := AuditEvent{
+			Time:      time.Now().UTC(),
+			Method:    fullMethodName,
+			Stream:    stream,
+			RemoteIP:  c.ClientIP(),
+			Code:      grpcerr.CodeName(st.Code()),
+			Message:   st.Message(),
+			LatencyMs: float64(elapsed.Microseconds()) / 1000,
+		}
+		if identity, ok := auth.FromContext(c.Request.Context()); ok {
+			ev.Subject, ev.Scheme = identity.Subject, identity.Scheme
+		}
+		if err := hps.audit.Record(ev, request); err != nil {
+			logging.FromContext(c.Request.Context()).Error("writing audit event failed", slog.Any("error", err))
+		}
+	}
+}
+
+// auditRefusal records a call of an audited route refused before its handler ran, e.g. by
+// authentication or a rate limit. The identity of the caller is recorded if it is known
+func (hps *HttpProxyServer) auditRefusal(c *gin.Context, httpStatus int, reason string) {
+	complete, ok := hps.auditedRoutes[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		return
+	}
+	complete(c, nil, status.Error(grpcerr.CodeFromHTTPStatus(httpStatus), reason), 0)
+}
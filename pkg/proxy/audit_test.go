@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"google.golang.org/grpc"
+)
+
+// endedStream is a server stream that ends without sending a message
+type endedStream struct{}
+
+func (endedStream) RecvMsg(any) error {
+	return io.EOF
+}
+
+func openEndedStream(
+	_ context.Context,
+	_ *tgsbpb.ServerStreamIntRequest,
+	_ ...grpc.CallOption,
+) (endedStream, error) {
+	return endedStream{}, nil
+}
+
+func Test_Audit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewAuditLogger(AuditConfig{
+		File:    path,
+		Methods: []string{"/tgsbpb.TylerSandboxService/UnaryCallInt", "/tgsbpb.TylerSandboxService/ServerStream*"},
+		Redact:  []string{"send_period_seconds"},
+	})
+	if err != nil {
+		t.Fatalf("did not expect error creating audit logger: %v\n", err)
+	}
+	authenticator, err := NewAPIKeyAuthenticator(APIKeyConfig{Keys: []APIKey{
+		{Name: "ci", Hash: HashAPIKey("secret")},
+		{Name: "viewer", Hash: HashAPIKey("viewer-secret"), Methods: []string{"/tgsbpb.TylerSandboxService/ServerStream*"}},
+	}})
+	if err != nil {
+		t.Fatalf("did not expect error creating authenticator: %v\n", err)
+	}
+	policy, err := NewAuthorizationPolicy(AuthorizationConfig{
+		Rules: []AuthorizationRule{{Name: "small-values", Effect: AuthorizationAllow, When: `request.value < 10`}},
+	})
+	if err != nil {
+		t.Fatalf("did not expect error creating policy: %v\n", err)
+	}
+	hps := NewHttpProxyServer("localhost:0", WithAuthentication(authenticator), WithAuthorization(policy), WithAudit(logger))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	if err := RegisterServerStream(hps, "/stream", openEndedStream, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+		WithStreamTransport(TransportServerSentEvents)); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	serveWithKey := func(req *http.Request, key string) {
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		hps.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve := func(req *http.Request) {
+		serveWithKey(req, "secret")
+	}
+
+	post := func(body string) *http.Request {
+		req, _ := http.NewRequest("POST", "/unary", bytes.NewBufferString(body))
+		return req
+	}
+	serve(post(`{"value":1}`))
+	serve(post(`{"value":50}`))
+	req, _ := http.NewRequest("GET", "/stream?value=2&sendPeriodSeconds=4", nil)
+	serve(req)
+	serveWithKey(post(`{"value":1}`), "")
+	serveWithKey(post(`{"value":1}`), "viewer-secret")
+	if err := hps.Shutdown(context.Background()); err != nil {
+		t.Fatalf("did not expect error shutting down: %v\n", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("did not expect error opening audit log: %v\n", err)
+	}
+	defer f.Close()
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("audit line %q is not json: %v\n", scanner.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 audit events, got %d\n", len(events))
+	}
+
+	t.Run("records the caller and outcome of unary calls", func(t *testing.T) {
+		allowed, denied := events[0], events[1]
+		if allowed.Method != "/tgsbpb.TylerSandboxService/UnaryCallInt" || allowed.Subject != "ci" || allowed.Scheme != "apikey" {
+			t.Fatalf("unexpected caller in %+v\n", allowed)
+		}
+		if allowed.Code != "OK" || denied.Code != "PERMISSION_DENIED" {
+			t.Fatalf("expected OK then PERMISSION_DENIED, got %s and %s\n", allowed.Code, denied.Code)
+		}
+		if string(denied.Request) != `{"value":50}` {
+			t.Fatalf("expected the denied request to be recorded, got %s\n", denied.Request)
+		}
+	})
+
+	t.Run("records streams once they end with their request redacted", func(t *testing.T) {
+		stream := events[2]
+		if !stream.Stream || stream.Code != "OK" || stream.Method != "/tgsbpb.TylerSandboxService/ServerStreamInt" {
+			t.Fatalf("unexpected stream event %+v\n", stream)
+		}
+		var request map[string]any
+		json.Unmarshal(stream.Request, &request)
+		if request["sendPeriodSeconds"] != "[REDACTED]" || request["value"] != float64(2) {
+			t.Fatalf("expected sendPeriodSeconds to be redacted, got %s\n", stream.Request)
+		}
+	})
+
+	t.Run("records calls refused by authentication and scopes without their request", func(t *testing.T) {
+		unauthenticated, forbidden := events[3], events[4]
+		if unauthenticated.Method != "/tgsbpb.TylerSandboxService/UnaryCallInt" || unauthenticated.Code != "UNAUTHENTICATED" || unauthenticated.Subject != "" {
+			t.Fatalf("unexpected unauthenticated event %+v\n", unauthenticated)
+		}
+		if forbidden.Code != "PERMISSION_DENIED" || forbidden.Subject != "viewer" || forbidden.Scheme != "apikey" {
+			t.Fatalf("unexpected forbidden event %+v\n", forbidden)
+		}
+		if unauthenticated.Request != nil || forbidden.Request != nil {
+			t.Fatalf("expected refused calls to be recorded without a request\n")
+		}
+	})
+}
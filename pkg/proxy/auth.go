@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/ratelimit"
+)
+
+// Authenticator verifies the credentials of a request, see WithAuthentication
+type Authenticator = auth.Authenticator
+
+// Identity is the authenticated caller of a request, see IdentityFromContext
+type Identity = auth.Identity
+
+// JWTConfig describes how bearer JWTs are verified and which claims are forwarded to the backend
+type JWTConfig = auth.JWTConfig
+
+// defaultCredentialQueryParams are where bearer tokens and API keys are sent by default,
+// see JWTConfig.QueryParam and APIKeyConfig.QueryParam
+var defaultCredentialQueryParams = []string{"access_token", "api_key"}
+
+// credentialQueryParams are the query parameters that may carry credentials, whose values
+// are kept out of logs and captures
+func (hps *HttpProxyServer) credentialQueryParams() []string {
+	return append(append([]string{}, defaultCredentialQueryParams...), auth.QueryParams(hps.authenticators)...)
+}
+
+// NewJWTAuthenticator returns an authenticator verifying bearer JWTs against the keys of a
+// local JWKS or PEM file. The token is read from the Authorization header or, for websocket
+// handshakes, from a query parameter or the subprotocol offered after "bearer"
+func NewJWTAuthenticator(cfg JWTConfig) (Authenticator, error) {
+	return auth.NewJWTAuthenticator(cfg)
+}
+
+// IdentityFromContext returns the authenticated caller of the request ctx belongs to
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	return auth.FromContext(ctx)
+}
+
+// APIKeyConfig describes where API keys are read from requests and which keys are valid
+type APIKeyConfig = auth.APIKeyConfig
+
+// APIKey is a key as stored in config, with its method scopes and rate limit
+type APIKey = auth.APIKey
+
+// RateLimit is a token bucket refilled at Rate tokens per second up to Burst tokens
+type RateLimit = ratelimit.Limit
+
+// NewAPIKeyAuthenticator returns an authenticator accepting the configured API keys from a
+// header or query parameter. Callers are limited to the methods and rate of their key
+func NewAPIKeyAuthenticator(cfg APIKeyConfig) (Authenticator, error) {
+	return auth.NewAPIKeyAuthenticator(cfg)
+}
+
+// HashAPIKey returns the hash to store in config for key
+func HashAPIKey(key string) string {
+	return auth.HashAPIKey(key)
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/authz"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
+)
+
+// AuthorizationConfig is an ordered list of rules deciding which calls are allowed, see authz.Expr
+// for the expressions rules are conditioned on
+type AuthorizationConfig = authz.Config
+
+// AuthorizationRule allows or denies the calls of some methods for which its expression holds
+type AuthorizationRule = authz.Rule
+
+// AuthorizationPolicy is a compiled AuthorizationConfig, see NewAuthorizationPolicy
+type AuthorizationPolicy = authz.Policy
+
+const (
+	AuthorizationAllow = authz.Allow
+	AuthorizationDeny  = authz.Deny
+)
+
+// NewAuthorizationPolicy compiles the rules of cfg
+func NewAuthorizationPolicy(cfg AuthorizationConfig) (*AuthorizationPolicy, error) {
+	return authz.New(cfg)
+}
+
+// WithAuthorization checks every call against policy once its request has been decoded and
+// before it reaches the backend. Denied calls fail with PERMISSION_DENIED, answered with 403
+// or close code 4403 for websockets
+func WithAuthorization(
+	policy *AuthorizationPolicy,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.authz = policy
+	}
+}
+
+// authorize returns the request check of the method's routes, or nil without a policy
+func (hps *HttpProxyServer) authorize(fullMethodName string) func(c *gin.Context, request proto.Message) error {
+	if hps.authz == nil {
+		return nil
+	}
+	return func(c *gin.Context, request proto.Message) error {
+		in := authz.Input{
+			Method:  fullMethodName,
+			IP:      c.ClientIP(),
+			Header:  c.Request.Header,
+			Request: request,
+		}
+		if identity, ok := auth.FromContext(c.Request.Context()); ok {
+			in.Subject, in.Scheme, in.Claims = identity.Subject, identity.Scheme, identity.Claims
+		}
+		return hps.authz.Check(c.Request.Context(), in)
+	}
+}
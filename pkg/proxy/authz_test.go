@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Authorization(t *testing.T) {
+	policy, err := NewAuthorizationPolicy(AuthorizationConfig{
+		Rules: []AuthorizationRule{{Name: "small-values", Effect: AuthorizationAllow, When: `request.value < 10`}},
+	})
+	if err != nil {
+		t.Fatalf("did not expect error creating policy: %v\n", err)
+	}
+	hps := NewHttpProxyServer("localhost:0", WithAuthorization(policy))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	call := func(body string) int {
+		req, _ := http.NewRequest("POST", "/unary", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("allows calls the policy allows", func(t *testing.T) {
+		if code := call(`{"value":1}`); code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusOK, code)
+		}
+	})
+
+	t.Run("denies other calls before they reach the backend", func(t *testing.T) {
+		if code := call(`{"value":50}`); code != http.StatusForbidden {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusForbidden, code)
+		}
+	})
+}
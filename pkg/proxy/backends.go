@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/creds"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DefaultBackend is the name of the backend passed to NewHttpProxyServer
+const DefaultBackend = "default"
+
+// TokenSource produces the tokens sent to a backend, see NewRefreshingCredentials
+type TokenSource = creds.TokenSource
+
+// Token is an access token and the time it expires
+type Token = creds.Token
+
+// StaticToken is a TokenSource for a fixed service token
+type StaticToken = creds.StaticToken
+
+// ClientCredentials is a TokenSource fetching tokens with the OAuth2 client credentials grant
+type ClientCredentials = creds.ClientCredentials
+
+// SignedJWT is a TokenSource minting JWTs signed with the proxy's private key
+type SignedJWT = creds.SignedJWT
+
+// NewRefreshingCredentials returns per-RPC credentials sending the tokens of source as bearer
+// tokens, refreshed in the background before they expire. requireTLS refuses to send them over
+// connections without transport security. The refresh stops when the server is shut down
+func NewRefreshingCredentials(source TokenSource, requireTLS bool) credentials.PerRPCCredentials {
+	return creds.NewRefreshing(source, requireTLS)
+}
+
+// BackendTLSConfig describes the client certificate, CA bundle and server name of a backend connection
+type BackendTLSConfig = creds.TLSFiles
+
+// NewReloadingTLSCredentials returns transport credentials for a backend that reload the files
+// of cfg when they are rotated. New connections pick up the new certificates, established
+// connections and their streams are not interrupted
+func NewReloadingTLSCredentials(cfg BackendTLSConfig) (credentials.TransportCredentials, error) {
+	return creds.NewReloadingTLS(cfg)
+}
+
+type backend struct {
+	target                 string
+	authority              string
+	transportCredentials   credentials.TransportCredentials
+	perRPCCredentials      credentials.PerRPCCredentials
+	passthroughCallerToken bool
+	conn                   *grpc.ClientConn
+}
+
+type BackendOptFunc func(*backend)
+
+// WithBackendTransportCredentials sets the transport security of the connection to the backend,
+// which is insecure by default
+func WithBackendTransportCredentials(
+	creds credentials.TransportCredentials,
+) BackendOptFunc {
+	return func(b *backend) {
+		b.transportCredentials = creds
+	}
+}
+
+// WithBackendAuthority overrides the :authority of calls to the backend, which defaults to its target
+func WithBackendAuthority(
+	authority string,
+) BackendOptFunc {
+	return func(b *backend) {
+		b.authority = authority
+	}
+}
+
+// WithBackendPerRPCCredentials attaches creds to every call to the backend
+func WithBackendPerRPCCredentials(
+	creds credentials.PerRPCCredentials,
+) BackendOptFunc {
+	return func(b *backend) {
+		b.perRPCCredentials = creds
+	}
+}
+
+// WithCallerTokenPassthrough forwards the bearer token of the incoming request to the backend
+// in the authorization metadata. Requests without a token fall back to the backend's per-RPC
+// credentials, if any
+func WithCallerTokenPassthrough() BackendOptFunc {
+	return func(b *backend) {
+		b.passthroughCallerToken = true
+	}
+}
+
+// WithBackend adds a named grpc backend next to the default one. Build clients for it from
+// BackendConn and register their routes with WithRouteBackend
+func WithBackend(
+	name string,
+	target string,
+	opts ...BackendOptFunc,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		b := &backend{target: target, transportCredentials: insecure.NewCredentials()}
+		for _, optFunc := range opts {
+			optFunc(b)
+		}
+		h.backends[name] = b
+	}
+}
+
+// WithDefaultBackend configures the backend passed to NewHttpProxyServer
+func WithDefaultBackend(
+	opts ...BackendOptFunc,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		for _, optFunc := range opts {
+			optFunc(h.backends[DefaultBackend])
+		}
+	}
+}
+
+// WithPerRPCCredentials attaches creds to every call to the default backend,
+// e.g. a service token, see NewRefreshingCredentials
+func WithPerRPCCredentials(
+	creds credentials.PerRPCCredentials,
+) OptFunc {
+	return WithDefaultBackend(WithBackendPerRPCCredentials(creds))
+}
+
+// BackendConn returns the connection to the named backend, creating it on first use.
+// It is closed by Shutdown
+func (hps *HttpProxyServer) BackendConn(name string) (*grpc.ClientConn, error) {
+	hps.mu.Lock()
+	defer hps.mu.Unlock()
+	b, ok := hps.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	if b.conn != nil {
+		return b.conn, nil
+	}
+	if hps.stub != nil {
+		return hps.stubConn(b)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(b.transportCredentials)}
+	if b.authority != "" {
+		dialOpts = append(dialOpts, grpc.WithAuthority(b.authority))
+	}
+	perRPC := b.perRPCCredentials
+	if b.passthroughCallerToken {
+		perRPC = creds.Passthrough{Fallback: b.perRPCCredentials}
+	}
+	if perRPC != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(perRPC))
+	}
+	if hps.tracer != nil {
+		dialOpts = append(dialOpts, hps.tracer.DialOptions()...)
+	}
+	conn, err := grpc.NewClient(b.target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	return conn, nil
+}
+
+// backendStub answers calls in place of the backends, see WithReplay and WithMock
+type backendStub interface {
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+	Stop()
+	// Methods counts the ways the stub answers each method it knows
+	Methods() map[string]int
+}
+
+// stubConn connects b to the stub in its place, without its credentials
+func (hps *HttpProxyServer) stubConn(b *backend) (*grpc.ClientConn, error) {
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(hps.stub.Dial),
+	}
+	if hps.tracer != nil {
+		dialOpts = append(dialOpts, hps.tracer.DialOptions()...)
+	}
+	conn, err := grpc.NewClient("passthrough:///stub", dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	return conn, nil
+}
+
+// logStub tells which methods are answered without a backend
+func (hps *HttpProxyServer) logStub() {
+	for method, responses := range hps.stub.Methods() {
+		hps.logger.Info("answering calls without a backend", slog.String("method", method), slog.Int("responses", responses))
+	}
+}
+
+// passesCallerToken reports whether any backend forwards the caller's token
+func (hps *HttpProxyServer) passesCallerToken() bool {
+	for _, b := range hps.backends {
+		if b.passthroughCallerToken {
+			return true
+		}
+	}
+	return false
+}
+
+// captureCallerToken makes the caller's bearer token available to Passthrough credentials.
+// Websocket handshakes may carry it in the query parameter of any JWT authenticator
+func (hps *HttpProxyServer) captureCallerToken() gin.HandlerFunc {
+	var queryParams []string
+	for _, a := range hps.authenticators {
+		if jwt, ok := a.(*auth.JWTAuthenticator); ok {
+			queryParams = append(queryParams, jwt.QueryParam())
+		}
+	}
+	if len(queryParams) == 0 {
+		queryParams = []string{"access_token"}
+	}
+	return func(c *gin.Context) {
+		for _, queryParam := range queryParams {
+			if token := auth.BearerToken(c.Request, queryParam); token != "" {
+				c.Request = c.Request.WithContext(creds.WithCallerToken(c.Request.Context(), token))
+				break
+			}
+		}
+		c.Next()
+	}
+}
+
+// closeBackends closes every backend connection and stops the background refresh of their credentials
+func (hps *HttpProxyServer) closeBackends() []error {
+	hps.mu.Lock()
+	defer hps.mu.Unlock()
+	var errs []error
+	for _, b := range hps.backends {
+		if closer, ok := b.perRPCCredentials.(interface{ Close() }); ok {
+			closer.Close()
+		}
+		if b.conn == nil {
+			continue
+		}
+		if err := b.conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/creds"
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type authorizationEchoServer struct {
+	tgsbpb.UnimplementedTylerSandboxServiceServer
+}
+
+// UnaryCallString answers with the authorization metadata the call arrived with
+func (authorizationEchoServer) UnaryCallString(ctx context.Context, _ *tgsbpb.UnaryCallStringRequest) (*tgsbpb.UnaryCallStringResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &tgsbpb.UnaryCallStringResponse{Value: append(md.Get("authorization"), "")[0]}, nil
+}
+
+func startAuthorizationEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v\n", err)
+	}
+	server := grpc.NewServer()
+	tgsbpb.RegisterTylerSandboxServiceServer(server, authorizationEchoServer{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func Test_Backends(t *testing.T) {
+	target := startAuthorizationEchoServer(t)
+
+	call := func(t *testing.T, hps *HttpProxyServer, backend string, header http.Header) string {
+		conn, err := hps.BackendConn(backend)
+		if err != nil {
+			t.Fatalf("did not expect error connecting: %v\n", err)
+		}
+		client := tgsbpb.NewTylerSandboxServiceClient(conn)
+		if err := RegisterUnary(hps, "/unary", client.UnaryCallString, WithRouteBackend(backend)); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+		req, _ := http.NewRequest("POST", "/unary", bytes.NewBufferString(`{"value":"x"}`))
+		req.Header = header
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s\n", http.StatusOK, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	t.Run("attaches per-RPC credentials to the default backend", func(t *testing.T) {
+		hps := NewHttpProxyServer(target, WithPerRPCCredentials(NewRefreshingCredentials(StaticToken("service"), false)))
+		defer hps.Shutdown(context.Background())
+		if body := call(t, hps, DefaultBackend, http.Header{}); body != `{"value":"Bearer service"}` {
+			t.Fatalf("Expected the service token, got %s\n", body)
+		}
+	})
+
+	t.Run("passes the caller's token through to a named backend", func(t *testing.T) {
+		hps := NewHttpProxyServer("localhost:0", WithBackend("echo", target,
+			WithBackendPerRPCCredentials(NewRefreshingCredentials(StaticToken("service"), false)),
+			WithCallerTokenPassthrough(),
+		))
+		defer hps.Shutdown(context.Background())
+		body := call(t, hps, "echo", http.Header{"Authorization": {"Bearer caller"}})
+		if body != `{"value":"Bearer caller"}` {
+			t.Fatalf("Expected the caller's token, got %s\n", body)
+		}
+	})
+
+	t.Run("passes through tokens from the query parameter of the jwt authenticator", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v\n", err)
+		}
+		der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		pemFile := filepath.Join(t.TempDir(), "key.pem")
+		if err := os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatalf("failed to write key: %v\n", err)
+		}
+		authenticator, err := NewJWTAuthenticator(JWTConfig{PEMFile: pemFile, QueryParam: "token"})
+		if err != nil {
+			t.Fatalf("did not expect error creating authenticator: %v\n", err)
+		}
+		hps := NewHttpProxyServer(target, WithAuthentication(authenticator))
+		app := gin.New()
+		app.Use(hps.captureCallerToken())
+		app.GET("/stream", func(c *gin.Context) {
+			md, _ := creds.Passthrough{}.GetRequestMetadata(c.Request.Context())
+			c.String(http.StatusOK, md["authorization"])
+		})
+		req, _ := http.NewRequest("GET", "/stream?token=caller", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Body.String() != "Bearer caller" {
+			t.Fatalf("Expected the caller's token from the query, got %q\n", w.Body.String())
+		}
+	})
+
+	t.Run("refuses routes of unknown backends", func(t *testing.T) {
+		hps := NewHttpProxyServer(target)
+		if err := RegisterUnary(hps, "/unary", echoIntCallFunc, WithRouteBackend("nope")); err == nil {
+			t.Fatalf("Expected error registering route\n")
+		}
+	})
+}
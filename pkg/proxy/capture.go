@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"github.com/TylerJGabb/grpc-http-proxy/internal/capture"
+)
+
+// CaptureConfig describes the traffic capture, see WithCapture
+type CaptureConfig = capture.Config
+
+// CaptureRecorder writes captured exchanges to a rotating file, see NewCaptureRecorder
+type CaptureRecorder = capture.Recorder
+
+// CaptureRecord is a single line of a capture file
+type CaptureRecord = capture.Record
+
+// NewCaptureRecorder opens the capture file of cfg
+func NewCaptureRecorder(cfg CaptureConfig) (*CaptureRecorder, error) {
+	return capture.New(cfg)
+}
+
+// WithCapture records the sampled calls of captured methods to r as json lines, for
+// debugging or to serve as fixtures. A record holds the request metadata, the request, the
+// unary response or the first MaxMessages messages of a stream with their timing, and the
+// status and websocket close code the call ended with. Messages are written as protojson
+// with the configured fields redacted. Calls refused before their handler runs, e.g.
+// without valid credentials, are not recorded. The recorder is closed by Shutdown
+func WithCapture(
+	r *CaptureRecorder,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.capture = r
+	}
+}
+
+// captures reports whether calls of the method are captured
+func (hps *HttpProxyServer) captures(fullMethodName string) bool {
+	return hps.capture != nil && hps.capture.Captures(fullMethodName)
+}
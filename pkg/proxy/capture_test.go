@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// countingStream sends the values 1 to its request's value, then fails with FAILED_PRECONDITION
+type countingStream struct {
+	last int32
+	sent int32
+}
+
+func (s *countingStream) RecvMsg(m any) error {
+	if s.sent == s.last {
+		st, _ := status.New(codes.FailedPrecondition, "counted enough").WithDetails(&tgsbpb.UnaryCallStringResponse{Value: "why"})
+		return st.Err()
+	}
+	s.sent++
+	m.(*tgsbpb.ServerStreamIntResponse).Value = s.sent
+	return nil
+}
+
+func openCountingStream(
+	_ context.Context,
+	req *tgsbpb.ServerStreamIntRequest,
+	_ ...grpc.CallOption,
+) (*countingStream, error) {
+	return &countingStream{last: req.Value}, nil
+}
+
+func readCapture(t *testing.T, path string) []CaptureRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("did not expect error opening capture: %v\n", err)
+	}
+	defer f.Close()
+	var records []CaptureRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record CaptureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("capture line %q is not json: %v\n", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func Test_Capture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	recorder, err := NewCaptureRecorder(CaptureConfig{
+		File:    path,
+		Methods: []string{"/tgsbpb.TylerSandboxService/UnaryCallInt", "/tgsbpb.TylerSandboxService/ServerStreamInt"},
+		Redact:  []string{"send_period_seconds"},
+	})
+	if err != nil {
+		t.Fatalf("did not expect error creating recorder: %v\n", err)
+	}
+	hps := NewHttpProxyServer("localhost:0", WithCapture(recorder))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	if err := RegisterServerStream(hps, "/stream", openCountingStream, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+		WithStreamTransport(TransportServerSentEvents)); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+
+	req, _ := http.NewRequest("POST", "/unary", bytes.NewBufferString(`{"value":7}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Request-Id", "abc")
+	hps.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("GET", "/stream?value=2&sendPeriodSeconds=4&access_token=secret-token", nil)
+	hps.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	if err := hps.Shutdown(context.Background()); err != nil {
+		t.Fatalf("did not expect error shutting down: %v\n", err)
+	}
+
+	records := readCapture(t, path)
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d\n", len(records))
+	}
+
+	t.Run("records unary calls with their metadata redacted", func(t *testing.T) {
+		r := records[0]
+		if r.Method != "/tgsbpb.TylerSandboxService/UnaryCallInt" || r.Transport != "http" || r.HTTPMethod != "POST" || r.HTTPStatus != 200 {
+			t.Fatalf("Unexpected record %+v\n", r)
+		}
+		if string(r.Request) != `{"value":7}` || string(r.Response) != `{"value":7}` || r.Status.Code != "OK" {
+			t.Fatalf("Unexpected exchange %s %s %+v\n", r.Request, r.Response, r.Status)
+		}
+		if r.Metadata["authorization"][0] != "[REDACTED]" || r.Metadata["x-request-id"][0] != "abc" {
+			t.Fatalf("Unexpected metadata %v\n", r.Metadata)
+		}
+	})
+
+	t.Run("records the messages and final status of streams", func(t *testing.T) {
+		r := records[1]
+		if !r.Stream || r.Transport != "sse" || r.Path != "/stream?value=2&sendPeriodSeconds=[REDACTED]&access_token=[REDACTED]" {
+			t.Fatalf("Unexpected record %+v\n", r)
+		}
+		if !strings.Contains(string(r.Request), `"sendPeriodSeconds":"[REDACTED]"`) {
+			t.Fatalf("Expected the request to be redacted, got %s\n", r.Request)
+		}
+		if len(r.Messages) != 2 || string(r.Messages[0].Message) != `{"value":1}` || string(r.Messages[1].Message) != `{"value":2}` {
+			t.Fatalf("Unexpected messages %+v\n", r.Messages)
+		}
+		if r.Status.Code != "FAILED_PRECONDITION" || r.Status.Message != "counted enough" || len(r.Status.Details) != 1 {
+			t.Fatalf("Unexpected status %+v\n", r.Status)
+		}
+	})
+}
@@ -0,0 +1,327 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/creds"
+)
+
+// Config is the file based configuration of the proxy, every section is optional
+type Config struct {
+	APIKeys        *APIKeyConfig    `json:"apiKeys"`
+	CORS           *CORSFileConfig  `json:"cors"`
+	RateLimits     *RateLimitConfig `json:"rateLimits"`
+	TrustedProxies []string         `json:"trustedProxies"`
+	Exposure       *ExposureRules   `json:"exposure"`
+	// GroupExposure holds the exposure rules of route groups by group name
+	GroupExposure map[string]ExposureRules `json:"groupExposure"`
+	Authorization *AuthorizationConfig     `json:"authorization"`
+	Validation    *ValidationConfig        `json:"validation"`
+	Audit         *AuditConfig             `json:"audit"`
+	AccessLog     *AccessLogConfig         `json:"accessLog"`
+	Capture       *CaptureConfig           `json:"capture"`
+	Admin         *AdminConfig             `json:"admin"`
+	// Logging configures the logger written to stderr
+	Logging *LoggingConfig `json:"logging"`
+	// Metrics serves prometheus metrics from a new registry
+	Metrics *MetricsConfig `json:"metrics"`
+	// Tracing exports spans of every proxied call
+	Tracing *TracingConfig `json:"tracing"`
+	// Replay answers calls from capture files instead of the backends
+	Replay *ReplayConfig `json:"replay"`
+	// Mock answers calls from fixture files instead of the backends
+	Mock *MockConfig `json:"mock"`
+	// DefaultBackend configures the backend passed to NewHttpProxyServer, its name and target are ignored
+	DefaultBackend *BackendConfig  `json:"defaultBackend"`
+	Backends       []BackendConfig `json:"backends"`
+}
+
+// CORSFileConfig is CORSConfig with its max age written as a duration string
+type CORSFileConfig struct {
+	CORSConfig
+	MaxAge Duration `json:"maxAge"`
+}
+
+// BackendConfig describes a grpc backend and the credentials sent with every call to it
+type BackendConfig struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	// TLS enables TLS towards the backend, the files are reloaded when rotated
+	TLS *BackendTLSConfig `json:"tls"`
+	// Authority overrides the :authority of calls, which defaults to the target
+	Authority              string             `json:"authority"`
+	Credentials            *CredentialsConfig `json:"credentials"`
+	PassthroughCallerToken bool               `json:"passthroughCallerToken"`
+}
+
+// CredentialsConfig describes a token source. Type is one of static, oauth2 or jwt,
+// and selects which of the remaining fields are used
+type CredentialsConfig struct {
+	Type string `json:"type"`
+	// static: the token, or a file holding it
+	Token     string `json:"token"`
+	TokenFile string `json:"tokenFile"`
+	// oauth2: the client credentials grant
+	TokenURL         string   `json:"tokenUrl"`
+	ClientID         string   `json:"clientId"`
+	ClientSecretFile string   `json:"clientSecretFile"`
+	Scopes           []string `json:"scopes"`
+	Audience         string   `json:"audience"`
+	// jwt: tokens signed with a PEM private key, Audience is shared with oauth2
+	KeyFile  string   `json:"keyFile"`
+	KeyID    string   `json:"keyId"`
+	Issuer   string   `json:"issuer"`
+	Subject  string   `json:"subject"`
+	Lifetime Duration `json:"lifetime"`
+	// AllowInsecure permits sending the tokens to backends without transport security
+	AllowInsecure bool `json:"allowInsecure"`
+}
+
+// Duration is a time.Duration written as a string in config, e.g. "5m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadConfig reads a json config file
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Options translates the config into options for NewHttpProxyServer
+func (cfg *Config) Options() ([]OptFunc, error) {
+	var opts []OptFunc
+	if cfg.APIKeys != nil {
+		authenticator, err := NewAPIKeyAuthenticator(*cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAuthentication(authenticator))
+	}
+	if cfg.CORS != nil {
+		corsConfig := cfg.CORS.CORSConfig
+		corsConfig.MaxAge = time.Duration(cfg.CORS.MaxAge)
+		opts = append(opts, WithCORS(corsConfig))
+	}
+	if cfg.RateLimits != nil {
+		limiter, err := NewRateLimiter(*cfg.RateLimits)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithRateLimits(limiter))
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		opts = append(opts, WithTrustedProxies(cfg.TrustedProxies...))
+	}
+	if cfg.Exposure != nil {
+		policy, err := NewExposurePolicy(*cfg.Exposure)
+		if err != nil {
+			return nil, fmt.Errorf("exposure: %w", err)
+		}
+		opts = append(opts, WithExposure(policy))
+	}
+	for group, rules := range cfg.GroupExposure {
+		policy, err := NewExposurePolicy(rules)
+		if err != nil {
+			return nil, fmt.Errorf("exposure of group %s: %w", group, err)
+		}
+		opts = append(opts, WithGroupExposure(group, policy))
+	}
+	if cfg.Authorization != nil {
+		policy, err := NewAuthorizationPolicy(*cfg.Authorization)
+		if err != nil {
+			return nil, fmt.Errorf("authorization: %w", err)
+		}
+		opts = append(opts, WithAuthorization(policy))
+	}
+	if cfg.Validation != nil {
+		opts = append(opts, WithValidation(*cfg.Validation))
+	}
+	if cfg.Logging != nil {
+		logger, err := NewLogger(*cfg.Logging, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithLogger(logger))
+	}
+	if cfg.Metrics != nil {
+		m, err := NewMetrics(nil)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithMetrics(m))
+		if cfg.Metrics.Path != "" {
+			opts = append(opts, WithMetricsPath(cfg.Metrics.Path))
+		}
+	}
+	if cfg.Tracing != nil {
+		provider, err := NewTracerProvider(*cfg.Tracing)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, withOwnedTracing(provider))
+		if cfg.Tracing.MessageSampleRate != nil {
+			opts = append(opts, WithMessageEventSampleRate(*cfg.Tracing.MessageSampleRate))
+		}
+	}
+	if cfg.Audit != nil {
+		logger, err := NewAuditLogger(*cfg.Audit)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAudit(logger))
+	}
+	if cfg.AccessLog != nil {
+		l, err := NewAccessLog(*cfg.AccessLog)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAccessLog(l))
+	}
+	if cfg.Capture != nil {
+		r, err := NewCaptureRecorder(*cfg.Capture)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCapture(r))
+	}
+	if cfg.Replay != nil {
+		r, err := NewReplayServer(*cfg.Replay)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithReplay(r))
+	}
+	if cfg.Mock != nil {
+		m, err := NewMockServer(*cfg.Mock)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithMock(m))
+	}
+	if cfg.Admin != nil {
+		if cfg.Admin.Address == "" {
+			return nil, fmt.Errorf("admin needs an address")
+		}
+		opts = append(opts, WithAdmin(cfg.Admin.Address))
+		if cfg.Admin.TokenFile != "" {
+			token, err := readSecret(cfg.Admin.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("admin token: %w", err)
+			}
+			opts = append(opts, WithAdminToken(token))
+		}
+	}
+	if cfg.DefaultBackend != nil {
+		backendOpts, err := cfg.DefaultBackend.options()
+		if err != nil {
+			return nil, fmt.Errorf("default backend: %w", err)
+		}
+		opts = append(opts, WithDefaultBackend(backendOpts...))
+	}
+	for _, backend := range cfg.Backends {
+		if backend.Name == "" || backend.Target == "" {
+			return nil, fmt.Errorf("backends need a name and a target")
+		}
+		backendOpts, err := backend.options()
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %w", backend.Name, err)
+		}
+		opts = append(opts, WithBackend(backend.Name, backend.Target, backendOpts...))
+	}
+	return opts, nil
+}
+
+func (bc *BackendConfig) options() ([]BackendOptFunc, error) {
+	var opts []BackendOptFunc
+	if bc.TLS != nil {
+		transportCredentials, err := NewReloadingTLSCredentials(*bc.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithBackendTransportCredentials(transportCredentials))
+	}
+	if bc.Authority != "" {
+		opts = append(opts, WithBackendAuthority(bc.Authority))
+	}
+	if bc.Credentials != nil {
+		source, err := bc.Credentials.tokenSource()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithBackendPerRPCCredentials(NewRefreshingCredentials(source, !bc.Credentials.AllowInsecure)))
+	}
+	if bc.PassthroughCallerToken {
+		opts = append(opts, WithCallerTokenPassthrough())
+	}
+	return opts, nil
+}
+
+func (cc *CredentialsConfig) tokenSource() (TokenSource, error) {
+	switch cc.Type {
+	case "static":
+		if cc.TokenFile == "" {
+			return StaticToken(cc.Token), nil
+		}
+		token, err := readSecret(cc.TokenFile)
+		return StaticToken(token), err
+	case "oauth2":
+		secret, err := readSecret(cc.ClientSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		return &ClientCredentials{
+			TokenURL:     cc.TokenURL,
+			ClientID:     cc.ClientID,
+			ClientSecret: secret,
+			Scopes:       cc.Scopes,
+			Audience:     cc.Audience,
+		}, nil
+	case "jwt":
+		key, err := creds.LoadSigner(cc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading signing key: %w", err)
+		}
+		return &SignedJWT{
+			Key:      key,
+			KeyID:    cc.KeyID,
+			Issuer:   cc.Issuer,
+			Subject:  cc.Subject,
+			Audience: cc.Audience,
+			Lifetime: time.Duration(cc.Lifetime),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown credentials type %q", cc.Type)
+	}
+}
+
+// readSecret reads a secret from a file, ignoring surrounding whitespace
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
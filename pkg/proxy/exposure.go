@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/exposure"
+)
+
+// ExposureRules allow or deny methods by glob patterns on their fully qualified names,
+// e.g. /pkg.AdminService/*. Deny patterns win over allow patterns
+type ExposureRules = exposure.Rules
+
+// ExposurePolicy is a compiled set of ExposureRules, see NewExposurePolicy
+type ExposurePolicy = exposure.Policy
+
+// NewExposurePolicy validates the patterns of rules
+func NewExposurePolicy(rules ExposureRules) (*ExposurePolicy, error) {
+	return exposure.New(rules)
+}
+
+// RouteExposure records the exposure decision of a registered route
+type RouteExposure struct {
+	HTTPMethod string `json:"httpMethod"`
+	Path       string `json:"path"`
+	Method     string `json:"method"`
+	Group      string `json:"group,omitempty"`
+	Exposed    bool   `json:"exposed"`
+	Reason     string `json:"reason"`
+}
+
+// WithExposure only registers the routes of methods exposed by policy, routes of other
+// methods are left out of the server entirely
+func WithExposure(
+	policy *ExposurePolicy,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.exposure = policy
+	}
+}
+
+// WithGroupExposure applies policy to the routes of a route group, see WithRouteGroup.
+// Methods must be exposed by both the server's and the group's policy
+func WithGroupExposure(
+	group string,
+	policy *ExposurePolicy,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.groupExposure[group] = policy
+	}
+}
+
+// expose decides whether the route of the method is registered and records the decision
+func (hps *HttpProxyServer) expose(httpMethod, path, fullMethodName, group string) bool {
+	route := RouteExposure{HTTPMethod: httpMethod, Path: path, Method: fullMethodName, Group: group, Exposed: true, Reason: "no rules"}
+	policies := []*ExposurePolicy{hps.exposure, hps.groupExposure[group]}
+	var reasons []string
+	for _, policy := range policies {
+		if policy == nil {
+			continue
+		}
+		exposed, reason := policy.Decide(fullMethodName)
+		reasons = append(reasons, reason)
+		if !exposed {
+			route.Exposed, reasons = false, []string{reason}
+			break
+		}
+	}
+	if len(reasons) > 0 {
+		route.Reason = reasons[len(reasons)-1]
+	}
+	hps.routes = append(hps.routes, route)
+	if route.Exposed {
+		hps.routeMethods[httpMethod+" "+path] = fullMethodName
+	}
+	return route.Exposed
+}
+
+// ExposureReport lists every route registered so far, in order, with whether it is exposed and why
+func (hps *HttpProxyServer) ExposureReport() []RouteExposure {
+	return append([]RouteExposure(nil), hps.routes...)
+}
+
+// WriteExposureReport writes the exposure report as a table, RunBlocking logs it on startup
+func (hps *HttpProxyServer) WriteExposureReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "EXPOSED\tHTTP\tPATH\tMETHOD\tGROUP\tREASON\n")
+	for _, route := range hps.routes {
+		exposed := "no"
+		if route.Exposed {
+			exposed = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", exposed, route.HTTPMethod, route.Path, route.Method, route.Group, route.Reason)
+	}
+	return tw.Flush()
+}
+
+// logExposureReport logs the exposure decision of every route, at info level
+func (hps *HttpProxyServer) logExposureReport() {
+	for _, route := range hps.routes {
+		hps.logger.Info("route registered",
+			slog.Bool("exposed", route.Exposed),
+			slog.String("http_method", route.HTTPMethod),
+			slog.String("path", route.Path),
+			slog.String("method", route.Method),
+			slog.String("group", route.Group),
+			slog.String("reason", route.Reason))
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func Test_Exposure(t *testing.T) {
+	conn, err := grpc.NewClient("localhost:0", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("did not expect error creating client: %v\n", err)
+	}
+	defer conn.Close()
+	client := tgsbpb.NewTylerSandboxServiceClient(conn)
+
+	newServer := func(t *testing.T, opts ...OptFunc) *HttpProxyServer {
+		hps := NewHttpProxyServer("localhost:0", opts...)
+		err := RegisterUnary(hps, "/unarycallint", client.UnaryCallInt)
+		if err == nil {
+			err = RegisterUnary(hps, "/unarycallstring", client.UnaryCallString, WithRouteGroup("public"))
+		}
+		if err != nil {
+			t.Fatalf("did not expect error registering routes: %v\n", err)
+		}
+		return hps
+	}
+	status := func(hps *HttpProxyServer, path string) int {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(`{}`))
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("does not register denied methods", func(t *testing.T) {
+		policy, err := NewExposurePolicy(ExposureRules{Deny: []string{"/tgsbpb.TylerSandboxService/UnaryCallInt"}})
+		if err != nil {
+			t.Fatalf("did not expect error: %v\n", err)
+		}
+		hps := newServer(t, WithExposure(policy))
+		if code := status(hps, "/unarycallint"); code != http.StatusNotFound {
+			t.Fatalf("Expected status code %d for a denied method, got %d\n", http.StatusNotFound, code)
+		}
+		report := hps.ExposureReport()
+		if len(report) != 2 || report[0].Exposed || !report[1].Exposed {
+			t.Fatalf("Expected only the second route to be exposed, got %+v\n", report)
+		}
+		if report[0].Reason != "denied by /tgsbpb.TylerSandboxService/UnaryCallInt" {
+			t.Fatalf("Expected the deny rule as reason, got %q\n", report[0].Reason)
+		}
+	})
+
+	t.Run("applies group rules on top of the server's", func(t *testing.T) {
+		all, _ := NewExposurePolicy(ExposureRules{Allow: []string{"/tgsbpb.TylerSandboxService/*"}})
+		public, _ := NewExposurePolicy(ExposureRules{Allow: []string{"/**/*Int"}})
+		hps := newServer(t, WithExposure(all), WithGroupExposure("public", public))
+		report := hps.ExposureReport()
+		if !report[0].Exposed || report[1].Exposed {
+			t.Fatalf("Expected only the ungrouped route to be exposed, got %+v\n", report)
+		}
+		if report[1].Reason != "not in allowlist" {
+			t.Fatalf("Expected the group allowlist as reason, got %q\n", report[1].Reason)
+		}
+
+		var sb strings.Builder
+		hps.WriteExposureReport(&sb)
+		if !strings.Contains(sb.String(), "/unarycallstring") || strings.Count(sb.String(), "\n") != 3 {
+			t.Fatalf("Expected a header and a line per route, got\n%s\n", sb.String())
+		}
+	})
+
+	t.Run("logs a route event per registered route", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := NewLogger(LoggingConfig{Level: "info", Format: "json"}, &buf)
+		if err != nil {
+			t.Fatalf("did not expect error creating logger: %v\n", err)
+		}
+		policy, _ := NewExposurePolicy(ExposureRules{Deny: []string{"/tgsbpb.TylerSandboxService/UnaryCallInt"}})
+		hps := newServer(t, WithLogger(logger), WithExposure(policy))
+		hps.logExposureReport()
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Expected an event per route, got\n%s\n", buf.String())
+		}
+		var denied map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &denied); err != nil {
+			t.Fatalf("expected a json event, got %q: %v\n", lines[0], err)
+		}
+		if denied["msg"] != "route registered" || denied["exposed"] != false || denied["path"] != "/unarycallint" || denied["reason"] != "denied by /tgsbpb.TylerSandboxService/UnaryCallInt" {
+			t.Fatalf("Unexpected route event %v\n", denied)
+		}
+	})
+}
@@ -1,12 +1,30 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/breaker"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/cors"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/metrics"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/ratelimit"
 	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
-	"github.com/TylerJGabb/grpc-http-proxy/internal/unary"
-	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/tracing"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
@@ -14,12 +32,20 @@ import (
 
 type OptFunc func(*HttpProxyServer)
 
+// CORSConfig describes which origins, methods and headers browsers may use cross-origin
+type CORSConfig = cors.Config
+
+// CircuitBreakerConfig describes when the circuit breaker of a backend method trips
+type CircuitBreakerConfig = breaker.Config
+
+// CircuitBreakerStatus is a point in time view of the circuit breaker of a backend method
+type CircuitBreakerStatus = breaker.Status
+
+// WithGrpcTransportCredentials sets the transport security of the connection to the default backend
 func WithGrpcTransportCredentials(
 	creds credentials.TransportCredentials,
 ) OptFunc {
-	return func(h *HttpProxyServer) {
-		h.transportCredentials = creds
-	}
+	return WithDefaultBackend(WithBackendTransportCredentials(creds))
 }
 
 func WithPort(
@@ -30,57 +56,411 @@ func WithPort(
 	}
 }
 
+// WithTLSConfig makes the standalone server serve HTTPS and WSS with the given config,
+// which must contain a certificate or a way to get one, e.g. GetCertificate
+func WithTLSConfig(
+	tlsConfig *tls.Config,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.tlsConfig = tlsConfig
+	}
+}
+
+// WithH2C makes the standalone server accept HTTP/2 without TLS (h2c) alongside HTTP/1.1.
+// It has no effect when a TLS config is set, as HTTP/2 is then negotiated through ALPN
+func WithH2C() OptFunc {
+	return func(h *HttpProxyServer) {
+		h.h2c = true
+	}
+}
+
+// WithUnixSocket makes the standalone server listen on a unix domain socket at path
+// instead of the port. A stale socket file left at path is removed before listening
+func WithUnixSocket(
+	path string,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.unixSocket = path
+	}
+}
+
+// WithCircuitBreaker gives every backend method its own circuit breaker. While a breaker
+// is open, unary calls fail fast with 503 and websockets are closed with CloseTryAgainLater
+func WithCircuitBreaker(
+	cfg CircuitBreakerConfig,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.breakers = breaker.NewRegistry(cfg)
+	}
+}
+
+// WithAuthentication requires every request to carry valid credentials for one of the
+// authenticators, tried in order. Unauthenticated requests are rejected with 401, or
+// with close code 4401 for websockets. See NewJWTAuthenticator
+func WithAuthentication(
+	authenticators ...Authenticator,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.authenticators = append(h.authenticators, authenticators...)
+	}
+}
+
+// WithCORS answers preflight requests and adds CORS headers for the allowed origins, ahead
+// of authentication. Websocket upgrades from other origins than the proxy's own are accepted
+// only from the allowed origins
+func WithCORS(
+	cfg CORSConfig,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.cors = cors.New(cfg)
+	}
+}
+
+// NewRateLimiter validates cfg, see WithRateLimits
+func NewRateLimiter(cfg RateLimitConfig) (*RateLimiter, error) {
+	return ratelimit.NewLimiter(cfg)
+}
+
+// WithRateLimits limits calls with token buckets keyed by client ip, API key, JWT subject or
+// method, and caps the concurrent streams of every caller. Calls over a limit are rejected
+// with 429 and Retry-After, or with CloseTryAgainLater for websockets. Client ips are taken
+// from X-Forwarded-For only when the request comes from one of the trusted proxies
+func WithRateLimits(
+	limiter *RateLimiter,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.limiter = limiter
+	}
+}
+
+// WithTrustedProxies sets the addresses or CIDRs of the proxies whose X-Forwarded-For
+// and X-Real-IP headers are trusted for the client ip. No proxy is trusted by default
+func WithTrustedProxies(
+	proxies ...string,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.trustedProxies = append(h.trustedProxies, proxies...)
+	}
+}
+
+// WithShutdownRetryAfter sets the retry hint handed to clients while the server is
+// shutting down, both in the Retry-After header of rejected requests and in the
+// reason of the CloseGoingAway frame sent to active websockets
+func WithShutdownRetryAfter(
+	retryAfter time.Duration,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.shutdownRetryAfter = retryAfter
+	}
+}
+
 type HttpProxyServer struct {
-	port                 int
-	grpcServerHost       string
-	transportCredentials credentials.TransportCredentials
+	port               int
+	shutdownRetryAfter time.Duration
+	tlsConfig          *tls.Config
+	h2c                bool
+	unixSocket         string
+	breakers           *breaker.Registry
+	authenticators     []auth.Authenticator
+	cors               *cors.Policy
+	limiter            *ratelimit.Limiter
+	trustedProxies     []string
+	exposure           *ExposurePolicy
+	groupExposure      map[string]*ExposurePolicy
+	routes             []RouteExposure
+	authz              *AuthorizationPolicy
+	validation         *ValidationConfig
+	audit              *AuditLogger
+	accessLog          *AccessLog
+	capture            *CaptureRecorder
+	interceptors       []Interceptor
+	stub               backendStub
+	logger             *slog.Logger
+	metrics            *Metrics
+	metricsPath        string
+	tracerProvider     trace.TracerProvider
+	ownsTracerProvider bool
+	tracer             *tracing.Tracer
+	// messageEventSampleRate is the fraction of stream messages recorded as span events
+	messageEventSampleRate float64
+	routeMethods           map[string]string
+	auditedRoutes          map[string]auditHook
+	streams                *serverstream.Registry
+	adminAddress           string
+	adminToken             string
+	app                    *gin.Engine
+
+	// mu guards server, shutdown and the backend connections, which are
+	// shared between RunBlocking, BackendConn and Shutdown
+	mu          sync.Mutex
+	server      *http.Server
+	adminServer *http.Server
+	backends    map[string]*backend
+	shutdown    bool
+
+	// goingAway is closed when Shutdown is called, inFlight tracks every
+	// request that is being handled, including hijacked websocket connections
+	goingAway     chan struct{}
+	goingAwayOnce sync.Once
+	inFlight      sync.WaitGroup
 }
 
 func NewHttpProxyServer(grpcServerHost string, opts ...OptFunc) *HttpProxyServer {
 	hps := &HttpProxyServer{
-		grpcServerHost:       grpcServerHost,
-		port:                 8080,
-		transportCredentials: insecure.NewCredentials(),
+		port:                   8080,
+		shutdownRetryAfter:     5 * time.Second,
+		goingAway:              make(chan struct{}),
+		groupExposure:          map[string]*ExposurePolicy{},
+		logger:                 slog.Default(),
+		metricsPath:            DefaultMetricsPath,
+		messageEventSampleRate: 1,
+		routeMethods:           map[string]string{},
+		auditedRoutes:          map[string]auditHook{},
+		streams:                serverstream.NewRegistry(),
+		backends: map[string]*backend{
+			DefaultBackend: {target: grpcServerHost, transportCredentials: insecure.NewCredentials()},
+		},
 	}
 	for _, optFunc := range opts {
 		optFunc(hps)
 	}
+	hps.setBackendLoggers()
+	if hps.stub != nil {
+		hps.logStub()
+	}
+	hps.app = gin.New()
+	if err := hps.app.SetTrustedProxies(hps.trustedProxies); err != nil {
+		// invalid addresses leave no proxy trusted
+		hps.logger.Warn("ignoring trusted proxies", slog.Any("error", err))
+		hps.app.SetTrustedProxies(nil)
+	}
+	hps.app.Use(logging.Middleware(hps.logger))
+	if hps.tracerProvider != nil {
+		hps.tracer = tracing.New(hps.tracerProvider, hps.messageEventSampleRate)
+		hps.app.Use(hps.tracer.Middleware())
+	}
+	if hps.accessLog != nil {
+		hps.app.Use(hps.accessLog.Middleware(hps.credentialQueryParams()...))
+	}
+	if hps.metrics != nil {
+		if err := hps.metrics.Register(metrics.NewBackendCollector(hps.backendConns)); err != nil {
+			hps.logger.Warn("not reporting backend connection states", slog.Any("error", err))
+		}
+		if hps.breakers != nil {
+			if err := hps.metrics.Register(metrics.NewBreakerCollector(hps.breakerStates)); err != nil {
+				hps.logger.Warn("not reporting circuit breaker states", slog.Any("error", err))
+			}
+		}
+		hps.app.Use(hps.metrics.Middleware(hps.methodOf))
+	}
+	if hps.cors != nil {
+		hps.app.Use(hps.cors.Middleware())
+	}
+	hps.app.Use(hps.trackInFlight)
+	if len(hps.authenticators) > 0 {
+		hps.app.Use(auth.Middleware(hps.authenticators, hps.reject()))
+	}
+	if hps.passesCallerToken() {
+		hps.app.Use(hps.captureCallerToken())
+	}
 	return hps
 }
 
+// ClientConn returns the connection to the default backend, creating it on first use.
+// Use it to build the generated clients whose methods are passed to RegisterUnary
+// and RegisterServerStream. It is closed by Shutdown.
+func (hps *HttpProxyServer) ClientConn() (*grpc.ClientConn, error) {
+	return hps.BackendConn(DefaultBackend)
+}
+
+// checkOrigin is the origin check of websocket upgrades, nil leaves it to the upgrader's same-origin default
+func (hps *HttpProxyServer) checkOrigin() func(r *http.Request) bool {
+	if hps.cors == nil {
+		return nil
+	}
+	return hps.cors.CheckOrigin
+}
+
+// reject refuses requests and websockets under the proxy's origin policy, and audits the
+// refusal if the route is audited
+func (hps *HttpProxyServer) reject() func(c *gin.Context, httpStatus int, closeCode int, reason string) {
+	reject := serverstream.Rejecter(hps.checkOrigin())
+	return func(c *gin.Context, httpStatus int, closeCode int, reason string) {
+		reject(c, httpStatus, closeCode, reason)
+		hps.auditRefusal(c, httpStatus, reason)
+	}
+}
+
+// retryAfterSeconds rounds the shutdown retry hint up to whole seconds,
+// which is the granularity of the Retry-After header
+func (hps *HttpProxyServer) retryAfterSeconds() int {
+	return int((hps.shutdownRetryAfter + time.Second - 1) / time.Second)
+}
+
+func (hps *HttpProxyServer) goingAwayReason() string {
+	return fmt.Sprintf("server shutting down, retry after %ds", hps.retryAfterSeconds())
+}
+
+// trackInFlight registers every request with the in-flight wait group so Shutdown
+// can wait for it, and rejects requests that arrive once shutdown has begun
+func (hps *HttpProxyServer) trackInFlight(c *gin.Context) {
+	// checking shutdown and adding to the wait group under the same lock
+	// guarantees no Add happens once Shutdown has started waiting
+	hps.mu.Lock()
+	if hps.shutdown {
+		hps.mu.Unlock()
+		c.Header("Retry-After", fmt.Sprint(hps.retryAfterSeconds()))
+		c.String(http.StatusServiceUnavailable, "server is shutting down")
+		c.Abort()
+		return
+	}
+	hps.inFlight.Add(1)
+	hps.mu.Unlock()
+	defer hps.inFlight.Done()
+	c.Next()
+}
+
+// CircuitBreakers returns the status of the circuit breaker of every registered
+// backend method, or nil when circuit breaking is not enabled
+func (hps *HttpProxyServer) CircuitBreakers() []CircuitBreakerStatus {
+	if hps.breakers == nil {
+		return nil
+	}
+	return hps.breakers.Snapshot()
+}
+
+// breaker returns the circuit breaker of the backend method, or nil when circuit breaking is not enabled
+func (hps *HttpProxyServer) breaker(backendName, fullMethodName string) *breaker.Breaker {
+	if hps.breakers == nil {
+		return nil
+	}
+	return hps.breakers.Get(hps.backends[backendName].target, fullMethodName)
+}
+
+// Handler returns the proxy as an http.Handler, for mounting it in an existing server or mux
+// instead of running it with RunBlocking. Shutdown still drains the requests it handles,
+// but stopping the listener is then left to the owner of the server
+func (hps *HttpProxyServer) Handler() http.Handler {
+	if hps.servesPublicMetrics() {
+		return hps.serveMetrics(hps.app)
+	}
+	return hps.app
+}
+
+func (hps *HttpProxyServer) listen() (net.Listener, error) {
+	if hps.unixSocket == "" {
+		return net.Listen("tcp", fmt.Sprintf(":%d", hps.port))
+	}
+	if info, err := os.Stat(hps.unixSocket); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(hps.unixSocket); err != nil {
+			return nil, fmt.Errorf("removing stale unix socket: %w", err)
+		}
+	}
+	return net.Listen("unix", hps.unixSocket)
+}
+
+// RunBlocking serves the registered routes until Shutdown is called. A server without any
+// routes serves those of the TylerSandboxService, see RegisterTylerSandboxRoutes, as it did
+// before routes could be registered
 func (hps *HttpProxyServer) RunBlocking() error {
-	conn, err := grpc.NewClient(hps.grpcServerHost,
-		grpc.WithTransportCredentials(hps.transportCredentials),
-	)
+	if len(hps.routes) == 0 {
+		if err := RegisterTylerSandboxRoutes(hps); err != nil {
+			return fmt.Errorf("registering default routes: %w", err)
+		}
+	}
+	handler := hps.Handler()
+	if hps.h2c && hps.tlsConfig == nil {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	server := &http.Server{
+		Handler:   handler,
+		TLSConfig: hps.tlsConfig,
+	}
+
+	hps.mu.Lock()
+	if hps.shutdown {
+		hps.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	hps.server = server
+	hps.mu.Unlock()
+
+	// the admin api is only started once the proxy itself listens, so it never outlives a failed start
+	listener, err := hps.listen()
 	if err != nil {
 		return err
 	}
-	client := tgsbpb.NewTylerSandboxServiceClient(conn)
-	app := gin.New()
-
-	app.POST("/unarycallint", func(c *gin.Context) {
-		unary.ProxyRequest(c, &tgsbpb.UnaryCallIntRequest{}, client.UnaryCallInt)
-	})
-	app.POST("/unarycallstring", func(c *gin.Context) {
-		unary.ProxyRequest(c, &tgsbpb.UnaryCallStringRequest{}, client.UnaryCallString)
-	})
-
-	app.GET("/serverstreamstring", func(c *gin.Context) {
-		serverstream.ServerStreamProxy(
-			c,
-			client.ServerStreamString,
-			serverstream.ParseStringStreamRequest,
-			&tgsbpb.ServerStreamStringRequest{},
-		)
-	})
-	app.GET("/serverstreamint", func(c *gin.Context) {
-		serverstream.ServerStreamProxy(
-			c,
-			client.ServerStreamInt,
-			serverstream.ParseIntStreamRequest,
-			&tgsbpb.ServerStreamIntRequest{},
-		)
-	})
-
-	return app.Run(fmt.Sprintf(":%d", hps.port))
+	if hps.adminAddress != "" {
+		if err := hps.runAdmin(); err != nil {
+			listener.Close()
+			return fmt.Errorf("admin listener: %w", err)
+		}
+	}
+	hps.logger.Info("serving", slog.String("addr", listener.Addr().String()))
+	hps.logExposureReport()
+	if hps.tlsConfig != nil {
+		// the certificates come from the TLS config
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the server. It stops accepting new connections, sends
+// CloseGoingAway to every active websocket, waits for in-flight requests to finish
+// and finally closes the connections to the grpc backends. If ctx is done before all
+// requests have finished, the grpc connections are closed anyway and ctx's error is returned.
+func (hps *HttpProxyServer) Shutdown(ctx context.Context) error {
+	hps.mu.Lock()
+	hps.shutdown = true
+	server := hps.server
+	adminServer := hps.adminServer
+	hps.mu.Unlock()
+
+	hps.goingAwayOnce.Do(func() { close(hps.goingAway) })
+
+	var errs []error
+	if server != nil {
+		// hijacked websocket connections are not tracked by the http server,
+		// so this only waits for unary calls, the wait group below covers the rest.
+		// a ctx error is reported below, once
+		if err := server.Shutdown(ctx); err != nil && ctx.Err() == nil {
+			errs = append(errs, err)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		hps.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+
+	if adminServer != nil {
+		// the admin api stays up while requests drain, so stuck streams can still be terminated
+		errs = append(errs, adminServer.Close())
+	}
+	errs = append(errs, hps.closeBackends()...)
+	if hps.stub != nil {
+		hps.stub.Stop()
+	}
+	if hps.audit != nil {
+		errs = append(errs, hps.audit.Close())
+	}
+	if hps.accessLog != nil {
+		errs = append(errs, hps.accessLog.Close())
+	}
+	if hps.capture != nil {
+		errs = append(errs, hps.capture.Close())
+	}
+	errs = append(errs, hps.shutdownTracing())
+	return errors.Join(errs...)
 }
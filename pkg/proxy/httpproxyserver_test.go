@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"google.golang.org/grpc"
+)
+
+func echoIntCallFunc(
+	_ context.Context,
+	req *tgsbpb.UnaryCallIntRequest,
+	_ ...grpc.CallOption,
+) (*tgsbpb.UnaryCallIntResponse, error) {
+	return &tgsbpb.UnaryCallIntResponse{Value: req.Value}, nil
+}
+
+func Test_HttpProxyServer(t *testing.T) {
+
+	t.Run("handler can be mounted in an existing mux", func(t *testing.T) {
+		hps := NewHttpProxyServer("localhost:0")
+		if err := RegisterUnary(hps, "/proxy/unary", echoIntCallFunc); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/proxy/", hps.Handler())
+
+		req, _ := http.NewRequest("POST", "/proxy/unary", bytes.NewBufferString(`{"value":1}`))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("serves on a unix socket until shut down", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "proxy.sock")
+		hps := NewHttpProxyServer("localhost:0", WithUnixSocket(socket))
+		if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- hps.RunBlocking()
+		}()
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}}
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			resp, err = client.Post("http://proxy/unary", "application/json", bytes.NewBufferString(`{"value":1}`))
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("failed to call proxy over unix socket: %v\n", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusOK, resp.StatusCode)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := hps.Shutdown(ctx); err != nil {
+			t.Fatalf("did not expect error shutting down: %v\n", err)
+		}
+		select {
+		case err := <-runErr:
+			if err != nil {
+				t.Fatalf("expected RunBlocking to return nil after shutdown, got %v\n", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for RunBlocking to return\n")
+		}
+	})
+
+	t.Run("serves the sandbox routes when none are registered", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "proxy.sock")
+		hps := NewHttpProxyServer("localhost:0", WithUnixSocket(socket))
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- hps.RunBlocking()
+		}()
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}}
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			resp, err = client.Post("http://proxy/unarycallint", "application/json", bytes.NewBufferString(`{"value":1}`))
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("failed to call proxy over unix socket: %v\n", err)
+		}
+		resp.Body.Close()
+		// there is no backend, but the route is mounted
+		if resp.StatusCode == http.StatusNotFound {
+			t.Fatalf("Expected /unarycallint to be mounted by default\n")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := hps.Shutdown(ctx); err != nil {
+			t.Fatalf("did not expect error shutting down: %v\n", err)
+		}
+		if err := <-runErr; err != nil {
+			t.Fatalf("expected RunBlocking to return nil after shutdown, got %v\n", err)
+		}
+	})
+
+	t.Run("does not start the admin api if the proxy cannot listen", func(t *testing.T) {
+		taken, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatalf("did not expect error listening: %v\n", err)
+		}
+		defer taken.Close()
+		free, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatalf("did not expect error listening: %v\n", err)
+		}
+		adminAddress := free.Addr().String()
+		free.Close()
+
+		hps := NewHttpProxyServer(taken.Addr().String(), WithAdmin(adminAddress))
+		if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+		if err := hps.RunBlocking(); err == nil {
+			t.Fatalf("expected RunBlocking to fail on a taken address\n")
+		}
+		if conn, err := net.Dial("tcp", adminAddress); err == nil {
+			conn.Close()
+			t.Fatalf("expected the admin api not to be served\n")
+		}
+	})
+
+	t.Run("rejects requests once shut down", func(t *testing.T) {
+		hps := NewHttpProxyServer("localhost:0", WithShutdownRetryAfter(3*time.Second))
+		if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+		if err := hps.Shutdown(context.Background()); err != nil {
+			t.Fatalf("did not expect error shutting down: %v\n", err)
+		}
+
+		req, _ := http.NewRequest("POST", "/unary", bytes.NewBufferString(`{"value":1}`))
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusServiceUnavailable, w.Code)
+		}
+		if w.Header().Get("Retry-After") != "3" {
+			t.Fatalf("Expected Retry-After 3, got %s\n", w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("answers cors preflight requests ahead of authentication", func(t *testing.T) {
+		authenticator, err := NewAPIKeyAuthenticator(APIKeyConfig{Keys: []APIKey{{Name: "test", Hash: HashAPIKey("secret")}}})
+		if err != nil {
+			t.Fatalf("did not expect error creating authenticator: %v\n", err)
+		}
+		hps := NewHttpProxyServer("localhost:0",
+			WithAuthentication(authenticator),
+			WithCORS(CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowedHeaders: []string{"Content-Type", "X-API-Key"}}),
+		)
+		if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+
+		req, _ := http.NewRequest(http.MethodOptions, "/unary", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "x-api-key")
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusNoContent, w.Code)
+		}
+
+		req, _ = http.NewRequest("POST", "/unary", bytes.NewBufferString(`{"value":1}`))
+		req.Header.Set("Origin", "https://app.example.com")
+		w = httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusUnauthorized, w.Code)
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Fatalf("Expected cors headers on the rejection, got %q\n", w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+}
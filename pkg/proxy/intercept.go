@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"github.com/TylerJGabb/grpc-http-proxy/internal/intercept"
+)
+
+// Interceptor plugs custom logic into the stages of the calls of every route, see WithInterceptors
+type Interceptor = intercept.Interceptor
+
+// StreamDirection is the way a stream message travels, see Interceptor.StreamMessage
+type StreamDirection = intercept.Direction
+
+const (
+	// StreamInbound is the request of a server stream, its only message from the client
+	StreamInbound = intercept.Inbound
+	// StreamOutbound messages go from the backend to the client
+	StreamOutbound = intercept.Outbound
+)
+
+// WithInterceptors runs interceptors at the stages of the calls of every unary and server
+// stream route, in the order they are given and after those of earlier options. The proxy
+// has no client or bidirectional streaming routes. Interceptors run after authentication
+// and rate limiting, and may tell routes apart by c.FullPath(). A stage that fails rejects
+// the call, or ends the stream, with the grpc status of its error
+func WithInterceptors(
+	interceptors ...Interceptor,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.interceptors = append(h.interceptors, interceptors...)
+	}
+}
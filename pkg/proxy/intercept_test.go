@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_Interceptors(t *testing.T) {
+	var closed []string
+	doubling := Interceptor{
+		BeforeDecode: func(c *gin.Context) error {
+			if c.GetHeader("X-Block") != "" {
+				return status.Error(codes.PermissionDenied, "blocked")
+			}
+			return nil
+		},
+		AfterDecode: func(c *gin.Context, request proto.Message) error {
+			if r, ok := request.(*tgsbpb.UnaryCallIntRequest); ok {
+				r.Value *= 2
+			}
+			return nil
+		},
+		StreamMessage: func(c *gin.Context, direction StreamDirection, n int64, message proto.Message) error {
+			if direction == StreamOutbound {
+				message.(*tgsbpb.ServerStreamIntResponse).Value *= 10
+			}
+			return nil
+		},
+	}
+	limiting := Interceptor{
+		Response: func(c *gin.Context, response proto.Message) error {
+			if response.(*tgsbpb.UnaryCallIntResponse).Value > 100 {
+				return status.Error(codes.OutOfRange, "too large")
+			}
+			return nil
+		},
+		StreamMessage: func(c *gin.Context, direction StreamDirection, n int64, message proto.Message) error {
+			if direction == StreamOutbound && n > 2 {
+				return status.Error(codes.ResourceExhausted, "enough messages")
+			}
+			return nil
+		},
+		StreamClose: func(c *gin.Context, request proto.Message, err error, elapsed time.Duration) {
+			closed = append(closed, c.FullPath()+" "+status.Code(err).String())
+		},
+	}
+	hps := NewHttpProxyServer("localhost:0", WithInterceptors(doubling), WithInterceptors(limiting))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	if err := RegisterServerStream(hps, "/stream", openCountingStream, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+		WithStreamTransport(TransportServerSentEvents)); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+
+	call := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("unary requests are rewritten before the call", func(t *testing.T) {
+		w := call("POST", "/unary", `{"value":21}`)
+		if w.Code != http.StatusOK || w.Body.String() != `{"value":42}` {
+			t.Fatalf("Expected the doubled value, got %d %s\n", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("unary responses are rejected with the status of the interceptor", func(t *testing.T) {
+		w := call("POST", "/unary", `{"value":51}`)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "too large") {
+			t.Fatalf("Expected 400 too large, got %d %s\n", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("requests are rejected before they are decoded", func(t *testing.T) {
+		for _, path := range []string{"/unary", "/stream?value=1"} {
+			method := "POST"
+			if strings.HasPrefix(path, "/stream") {
+				method = "GET"
+			}
+			if w := call(method, path, `{"value":1}`, "X-Block", "yes"); w.Code != http.StatusForbidden {
+				t.Fatalf("Expected %s to be refused with 403, got %d\n", path, w.Code)
+			}
+		}
+		if len(closed) != 0 {
+			t.Fatalf("Expected refused streams not to be closed, got %v\n", closed)
+		}
+	})
+
+	t.Run("stream messages are rewritten, then the stream is ended", func(t *testing.T) {
+		w := call("GET", "/stream?value=5", "")
+		body := w.Body.String()
+		if !strings.Contains(body, "data: {\"value\":10}") || !strings.Contains(body, "data: {\"value\":20}") || strings.Contains(body, "\"value\":30") {
+			t.Fatalf("Expected two rewritten messages, got %q\n", body)
+		}
+		if !strings.HasSuffix(body, "event: error\ndata: enough messages\n\n") {
+			t.Fatalf("Expected the stream to end with an error event, got %q\n", body)
+		}
+		if len(closed) != 1 || closed[0] != "/stream ResourceExhausted" {
+			t.Fatalf("Expected the stream to close with RESOURCE_EXHAUSTED, got %v\n", closed)
+		}
+	})
+}
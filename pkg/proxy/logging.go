@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/creds"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+)
+
+// RequestIDHeader carries the id every log event of a request is tagged with. It is taken
+// from the request when set and generated otherwise, and always echoed in the response
+const RequestIDHeader = logging.RequestIDHeader
+
+// LoggingConfig selects the level, debug, info, warn or error, and the format, json or text, of a logger
+type LoggingConfig = logging.Config
+
+// NewLogger returns a logger writing to w as described by cfg
+func NewLogger(cfg LoggingConfig, w io.Writer) (*slog.Logger, error) {
+	return logging.New(cfg, w)
+}
+
+// WithLogger sets the logger of the proxy, slog.Default() is used otherwise. Events of a
+// request carry its request_id, remote_addr and grpc method, and calls end with a single
+// event carrying their grpc code and duration, logged at info level when they succeed or
+// the client gave up, warn when the client was otherwise at fault and error otherwise.
+// Forwarded stream messages are logged at debug level
+func WithLogger(
+	logger *slog.Logger,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.logger = logger
+	}
+}
+
+// setBackendLoggers points the credentials of the backends that log at the proxy's logger
+func (hps *HttpProxyServer) setBackendLoggers() {
+	for _, b := range hps.backends {
+		if reloading, ok := b.transportCredentials.(*creds.ReloadingTLS); ok {
+			reloading.SetLogger(hps.logger.With(slog.String("backend", b.target)))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Logging(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(LoggingConfig{Level: "info", Format: "json"}, &buf)
+	if err != nil {
+		t.Fatalf("did not expect error creating logger: %v\n", err)
+	}
+	hps := NewHttpProxyServer("localhost:0", WithLogger(logger))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	call := func(body string) *httptest.ResponseRecorder {
+		buf.Reset()
+		req, _ := http.NewRequest("POST", "/unary", strings.NewReader(body))
+		req.Header.Set(RequestIDHeader, "req-1")
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		return w
+	}
+	event := func(t *testing.T) map[string]any {
+		var ev map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+			t.Fatalf("expected a single json event, got %q: %v\n", buf.String(), err)
+		}
+		return ev
+	}
+
+	t.Run("logs a call with its method, request id and code", func(t *testing.T) {
+		w := call(`{"value":1}`)
+		if w.Header().Get(RequestIDHeader) != "req-1" {
+			t.Fatalf("expected the request id to be echoed\n")
+		}
+		ev := event(t)
+		if ev["msg"] != "unary call" || ev["method"] != "/tgsbpb.TylerSandboxService/UnaryCallInt" ||
+			ev["request_id"] != "req-1" || ev["code"] != "OK" || ev["level"] != "INFO" {
+			t.Fatalf("unexpected event %v\n", ev)
+		}
+	})
+
+	t.Run("classifies calls that fail because of the client", func(t *testing.T) {
+		if w := call(`not json`); w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusBadRequest, w.Code)
+		}
+		ev := event(t)
+		if ev["code"] != "InvalidArgument" || ev["error_class"] != "client" || ev["level"] != "WARN" {
+			t.Fatalf("unexpected event %v\n", ev)
+		}
+	})
+}
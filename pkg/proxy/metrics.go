@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/metrics"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/unary"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultMetricsPath is where metrics are served unless WithMetricsPath says otherwise
+const DefaultMetricsPath = "/metrics"
+
+// Metrics are the prometheus collectors of a proxy. Custom collectors added with
+// Register are served along with them
+type Metrics = metrics.Metrics
+
+// MetricsConfig is the file based configuration of the metrics endpoint
+type MetricsConfig struct {
+	// Path is where metrics are served, DefaultMetricsPath by default
+	Path string `json:"path"`
+}
+
+// NewMetrics registers the collectors of a proxy with registry, along with the go runtime
+// and process collectors. A nil registry creates a new one
+func NewMetrics(registry *prometheus.Registry) (*Metrics, error) {
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	return metrics.New(registry)
+}
+
+// WithMetrics records the traffic of the proxy in m and serves it at DefaultMetricsPath:
+// requests by route, method, http status and grpc code, unary latency, time to the first
+// message of streams, active streams, forwarded messages and their sizes in each direction,
+// and the state of the backend connections and of the circuit breakers. With WithAdmin the
+// endpoint is served by the admin listener only, behind the admin token if any. Otherwise
+// it is served by the proxy ahead of every other handler, authentication included, so it
+// is meant for networks scrapers live on
+func WithMetrics(
+	m *Metrics,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.metrics = m
+	}
+}
+
+// WithMetricsPath sets where metrics are served
+func WithMetricsPath(
+	path string,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.metricsPath = path
+	}
+}
+
+// methodOf returns the grpc method of a route, or "" if it is not a proxied route
+func (hps *HttpProxyServer) methodOf(httpMethod, route string) string {
+	return hps.routeMethods[httpMethod+" "+route]
+}
+
+// backendConns lists the backends that have been connected to
+func (hps *HttpProxyServer) backendConns() []metrics.BackendConn {
+	hps.mu.Lock()
+	defer hps.mu.Unlock()
+	var conns []metrics.BackendConn
+	for name, b := range hps.backends {
+		if b.conn != nil {
+			conns = append(conns, metrics.BackendConn{Name: name, Target: b.target, State: b.conn.GetState()})
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Name < conns[j].Name })
+	return conns
+}
+
+// breakerStates lists the circuit breakers of the backend methods called so far
+func (hps *HttpProxyServer) breakerStates() []metrics.BreakerState {
+	var states []metrics.BreakerState
+	for _, status := range hps.breakers.Snapshot() {
+		states = append(states, metrics.BreakerState{Backend: status.Backend, Method: status.Method, State: status.State})
+	}
+	return states
+}
+
+// servesPublicMetrics reports whether metrics are served by the proxy rather than by the admin listener
+func (hps *HttpProxyServer) servesPublicMetrics() bool {
+	return hps.metrics != nil && hps.adminAddress == ""
+}
+
+// serveMetrics wraps handler so the metrics path is answered before any of its middleware runs
+func (hps *HttpProxyServer) serveMetrics(handler http.Handler) http.Handler {
+	metricsHandler := hps.metrics.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == hps.metricsPath {
+			metricsHandler.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// unaryMetrics returns the options recording the metrics of a unary route, if enabled
+func (hps *HttpProxyServer) unaryMetrics(path, fullMethodName string) []unary.OptFunc {
+	if hps.metrics == nil {
+		return nil
+	}
+	route := hps.metrics.Route(path, fullMethodName)
+	return []unary.OptFunc{
+		unary.WithObserver(unary.Observer{
+			Request:  func(size int) { route.Message(metrics.DirectionInbound, size) },
+			Response: func(size int) { route.Message(metrics.DirectionOutbound, size) },
+		}),
+		unary.WithCompletion(func(c *gin.Context, _ proto.Message, err error, elapsed time.Duration) {
+			metrics.SetCode(c, err)
+			route.UnaryDone(elapsed)
+		}),
+	}
+}
+
+// streamMetrics returns the options recording the metrics of a server stream route, if enabled
+func (hps *HttpProxyServer) streamMetrics(path, fullMethodName string, transport StreamTransport) []serverstream.OptFunc {
+	if hps.metrics == nil {
+		return nil
+	}
+	route := hps.metrics.Route(path, fullMethodName)
+	transportName := "websocket"
+	if transport == TransportServerSentEvents {
+		transportName = "sse"
+	}
+	return []serverstream.OptFunc{
+		serverstream.WithObserver(serverstream.Observer{
+			Opened: func(request proto.Message) {
+				route.StreamOpened(transportName)
+				// the request is parsed from the url, so its size is that of the message
+				route.Message(metrics.DirectionInbound, proto.Size(request))
+			},
+			Closed: func() { route.StreamClosed(transportName) },
+			Message: func(n int64, size int, sinceOpen time.Duration) {
+				route.Message(metrics.DirectionOutbound, size)
+				if n == 1 {
+					route.FirstMessage(sinceOpen)
+				}
+			},
+		}),
+		serverstream.WithCompletion(func(c *gin.Context, _ proto.Message, err error, _ time.Duration) {
+			metrics.SetCode(c, err)
+		}),
+	}
+}
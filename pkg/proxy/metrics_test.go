@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_Metrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewMetrics(registry)
+	if err != nil {
+		t.Fatalf("did not expect error creating metrics: %v\n", err)
+	}
+	custom := prometheus.NewCounter(prometheus.CounterOpts{Name: "custom_total", Help: "A custom counter."})
+	if err := m.Register(custom); err != nil {
+		t.Fatalf("did not expect error registering a custom collector: %v\n", err)
+	}
+	custom.Inc()
+	authenticator, err := NewAPIKeyAuthenticator(APIKeyConfig{Keys: []APIKey{{Name: "test", Hash: HashAPIKey("secret")}}})
+	if err != nil {
+		t.Fatalf("did not expect error creating authenticator: %v\n", err)
+	}
+	hps := NewHttpProxyServer("localhost:0", WithMetrics(m), WithAuthentication(authenticator), WithCircuitBreaker(CircuitBreakerConfig{}))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	if err := RegisterServerStream(hps, "/stream", openEndedStream, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+		WithStreamTransport(TransportServerSentEvents)); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	serve := func(method, path, body string, authenticated bool) int {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if authenticated {
+			req.Header.Set("X-API-Key", "secret")
+		}
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+	serve("POST", "/unary", `{"value":1}`, true)
+	serve("POST", "/unary", `{"value":1}`, false)
+	serve("GET", "/stream?value=3", "", true)
+
+	req, _ := http.NewRequest("GET", DefaultMetricsPath, nil)
+	w := httptest.NewRecorder()
+	hps.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected metrics to be served without credentials, got status %d\n", w.Code)
+	}
+	body, _ := io.ReadAll(w.Body)
+	exposition := string(body)
+
+	for _, expected := range []string{
+		`grpc_http_proxy_requests_total{grpc_code="OK",http_status="200",method="/tgsbpb.TylerSandboxService/UnaryCallInt",route="/unary"} 1`,
+		`grpc_http_proxy_requests_total{grpc_code="Unauthenticated",http_status="401",method="/tgsbpb.TylerSandboxService/UnaryCallInt",route="/unary"} 1`,
+		`grpc_http_proxy_requests_total{grpc_code="OK",http_status="200",method="/tgsbpb.TylerSandboxService/ServerStreamInt",route="/stream"} 1`,
+		`grpc_http_proxy_unary_duration_seconds_count{method="/tgsbpb.TylerSandboxService/UnaryCallInt",route="/unary"} 1`,
+		`grpc_http_proxy_messages_total{direction="inbound",method="/tgsbpb.TylerSandboxService/UnaryCallInt",route="/unary"} 1`,
+		`grpc_http_proxy_messages_total{direction="outbound",method="/tgsbpb.TylerSandboxService/UnaryCallInt",route="/unary"} 1`,
+		`grpc_http_proxy_messages_total{direction="inbound",method="/tgsbpb.TylerSandboxService/ServerStreamInt",route="/stream"} 1`,
+		`grpc_http_proxy_active_streams{method="/tgsbpb.TylerSandboxService/ServerStreamInt",route="/stream",transport="sse"} 0`,
+		`grpc_http_proxy_circuit_breaker_state{backend="localhost:0",method="/tgsbpb.TylerSandboxService/UnaryCallInt",state="closed"} 1`,
+		`grpc_http_proxy_circuit_breaker_state{backend="localhost:0",method="/tgsbpb.TylerSandboxService/UnaryCallInt",state="open"} 0`,
+		`custom_total 1`,
+	} {
+		if !strings.Contains(exposition, expected) {
+			t.Fatalf("expected metrics to contain %s, got\n%s\n", expected, exposition)
+		}
+	}
+}
+
+func Test_MetricsOnAdmin(t *testing.T) {
+	m, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("did not expect error creating metrics: %v\n", err)
+	}
+	hps := NewHttpProxyServer("localhost:0", WithMetrics(m), WithAdmin("localhost:0"), WithAdminToken("admin-secret"))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	hps.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/unary", strings.NewReader(`{"value":1}`)))
+
+	w := httptest.NewRecorder()
+	hps.Handler().ServeHTTP(w, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected metrics not to be served by the proxy, got status %d\n", w.Code)
+	}
+	w = httptest.NewRecorder()
+	hps.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected the admin token to be required, got status %d\n", w.Code)
+	}
+	req := httptest.NewRequest("GET", DefaultMetricsPath, nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	w = httptest.NewRecorder()
+	hps.AdminHandler().ServeHTTP(w, req)
+	expected := `grpc_http_proxy_requests_total{grpc_code="OK",http_status="200",method="/tgsbpb.TylerSandboxService/UnaryCallInt",route="/unary"} 1`
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), expected) {
+		t.Fatalf("Expected the admin listener to serve %s, got status %d\n%s\n", expected, w.Code, w.Body.String())
+	}
+}
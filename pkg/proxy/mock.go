@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"github.com/TylerJGabb/grpc-http-proxy/internal/mock"
+)
+
+// MockConfig lists the fixture files a mock server answers from, see NewMockServer
+type MockConfig = mock.Config
+
+// MockFixture is the content of a fixture file
+type MockFixture = mock.Fixture
+
+// MockServer answers grpc calls from fixtures, see WithMock
+type MockServer = mock.Server
+
+// NewMockServer loads the fixture files of cfg
+func NewMockServer(cfg MockConfig) (*MockServer, error) {
+	return mock.New(cfg)
+}
+
+// WithMock answers every call from the fixtures of m instead of the backends, which are
+// never dialed, e.g. to build clients of a contract before it is implemented. A fixture
+// answers a method with the first of its responses that matches the request: a static
+// or templated response echoing fields of the request, an error status with details, or
+// a scripted stream of messages, delays, errors and EOF. Calls that match no response
+// fail with NOT_FOUND. Only the last of WithReplay and WithMock applies. The mock server
+// is stopped by Shutdown
+func WithMock(
+	m *MockServer,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.stub = m
+	}
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+)
+
+var mockFixtures = map[string]string{
+	"unary.json": `{
+		"method": "/tgsbpb.TylerSandboxService/UnaryCallString",
+		"responses": [
+			{"when": {"value": "secret"}, "status": {"code": "PERMISSION_DENIED", "message": "not for you",
+				"details": [{"@type": "type.googleapis.com/tgsbpb.UnaryCallStringResponse", "value": "why"}]}},
+			{"when": {"value": "static"}, "response": {"value": "always the same"}},
+			{"template": true, "response": {"value": "hello {{.request.value}}"}}
+		]
+	}`,
+	"stream.json": `{
+		"method": "/tgsbpb.TylerSandboxService/ServerStreamInt",
+		"responses": [
+			{"when": {"value": 1}, "template": true, "stream": [
+				{"message": {"value": "{{.request.value}}"}},
+				{"delayMs": 20, "message": {"value": 2}},
+				{"status": {"code": "UNAVAILABLE", "message": "backend went away"}}
+			]},
+			{"stream": [{"message": {"value": 7}}, {"eof": true}, {"message": {"value": 8}}]}
+		]
+	}`,
+}
+
+func Test_Mock(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range mockFixtures {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("did not expect error writing fixture: %v\n", err)
+		}
+	}
+	m, err := NewMockServer(MockConfig{Fixtures: []string{dir}})
+	if err != nil {
+		t.Fatalf("did not expect error creating mock server: %v\n", err)
+	}
+	hps := NewHttpProxyServer("unreachable:1", WithMock(m))
+	defer hps.Shutdown(context.Background())
+	conn, err := hps.ClientConn()
+	if err != nil {
+		t.Fatalf("did not expect error connecting: %v\n", err)
+	}
+	client := tgsbpb.NewTylerSandboxServiceClient(conn)
+	if err := RegisterUnary(hps, "/unary", client.UnaryCallString); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	if err := RegisterUnary(hps, "/unaryint", client.UnaryCallInt); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	if err := RegisterServerStream(hps, "/stream", client.ServerStreamInt, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+		WithStreamTransport(TransportServerSentEvents)); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	s := httptest.NewServer(hps.Handler())
+	defer s.Close()
+
+	post := func(path, body string) (int, string) {
+		resp, err := http.Post(s.URL+path, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("did not expect error posting: %v\n", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	stream := func(query string) string {
+		resp, err := http.Get(s.URL + "/stream?" + query)
+		if err != nil {
+			t.Fatalf("did not expect error opening stream: %v\n", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	t.Run("answers with static and templated responses", func(t *testing.T) {
+		if code, body := post("/unary", `{"value":"static"}`); code != http.StatusOK || body != `{"value":"always the same"}` {
+			t.Fatalf("Unexpected response %d %s\n", code, body)
+		}
+		if code, body := post("/unary", `{"value":"world"}`); code != http.StatusOK || body != `{"value":"hello world"}` {
+			t.Fatalf("Unexpected response %d %s\n", code, body)
+		}
+	})
+
+	t.Run("fails with error statuses", func(t *testing.T) {
+		if code, body := post("/unary", `{"value":"secret"}`); code != http.StatusForbidden || !strings.Contains(body, "not for you") {
+			t.Fatalf("Unexpected response %d %s\n", code, body)
+		}
+	})
+
+	t.Run("refuses methods without fixtures", func(t *testing.T) {
+		if code, body := post("/unaryint", `{"value":1}`); code != http.StatusNotFound || !strings.Contains(body, "no fixture") {
+			t.Fatalf("Unexpected response %d %s\n", code, body)
+		}
+	})
+
+	t.Run("plays scripted streams", func(t *testing.T) {
+		expected := "event: message\ndata: {\"value\":1}\n\nevent: message\ndata: {\"value\":2}\n\nevent: error\ndata: backend went away\n\n"
+		if body := stream("value=1"); body != expected {
+			t.Fatalf("Expected %q, got %q\n", expected, body)
+		}
+		expected = "event: message\ndata: {\"value\":7}\n\nevent: end\ndata: server stream ended\n\n"
+		if body := stream("value=5"); body != expected {
+			t.Fatalf("Expected %q, got %q\n", expected, body)
+		}
+	})
+}
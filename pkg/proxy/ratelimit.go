@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// RateLimitConfig describes the rate limits, method costs and concurrent stream cap of the proxy
+type RateLimitConfig = ratelimit.Config
+
+// RateLimiter enforces a RateLimitConfig, see NewRateLimiter
+type RateLimiter = ratelimit.Limiter
+
+// RateLimitRule limits the calls of every client ip, API key, JWT subject or method
+type RateLimitRule = ratelimit.Rule
+
+// RateLimitKey selects what the buckets of a RateLimitRule are keyed by
+type RateLimitKey = ratelimit.Key
+
+const (
+	RateLimitByIP      = ratelimit.KeyIP
+	RateLimitByAPIKey  = ratelimit.KeyAPIKey
+	RateLimitBySubject = ratelimit.KeySubject
+	RateLimitByMethod  = ratelimit.KeyMethod
+)
+
+// streamCapRetryAfter is the retry hint of callers at their concurrent stream cap,
+// there is no telling when one of their streams ends
+const streamCapRetryAfter = 5 * time.Second
+
+// rateLimitGuard enforces the rate limits of the method's route and, for streams, the cap on
+// the caller's concurrent streams. It runs after authentication, so limits can be keyed by identity
+func (hps *HttpProxyServer) rateLimitGuard(fullMethodName string, stream bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, _ := auth.FromContext(c.Request.Context())
+		keys := func(key ratelimit.Key) string {
+			switch key {
+			case ratelimit.KeyIP:
+				return c.ClientIP()
+			case ratelimit.KeyAPIKey:
+				if identity != nil && identity.Scheme == "apikey" {
+					return identity.Subject
+				}
+			case ratelimit.KeySubject:
+				if identity != nil && identity.Scheme == "jwt" {
+					return identity.Subject
+				}
+			}
+			return ""
+		}
+		if ok, retryAfter := hps.limiter.Take(fullMethodName, keys); !ok {
+			hps.rejectOverLimit(c, retryAfter, "rate limit exceeded")
+			return
+		}
+		if !stream {
+			c.Next()
+			return
+		}
+
+		caller := "ip:" + c.ClientIP()
+		if identity != nil {
+			caller = identity.Scheme + ":" + identity.Subject
+		}
+		ok, release := hps.limiter.AcquireStream(caller)
+		if !ok {
+			hps.rejectOverLimit(c, streamCapRetryAfter, "too many concurrent streams")
+			return
+		}
+		// stream handlers return once the stream has ended
+		defer release()
+		c.Next()
+	}
+}
+
+func (hps *HttpProxyServer) rejectOverLimit(c *gin.Context, retryAfter time.Duration, reason string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	hps.reject()(c, http.StatusTooManyRequests, websocket.CloseTryAgainLater, reason)
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_RateLimits(t *testing.T) {
+
+	t.Run("limits unary calls per client ip", func(t *testing.T) {
+		limiter, err := NewRateLimiter(RateLimitConfig{Rules: []RateLimitRule{{Key: RateLimitByIP, Limit: RateLimit{Rate: 0.5, Burst: 1}}}})
+		if err != nil {
+			t.Fatalf("did not expect error creating limiter: %v\n", err)
+		}
+		hps := NewHttpProxyServer("localhost:0", WithRateLimits(limiter))
+		if err := RegisterUnary(hps, "/unary", echoIntCallFunc); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+		call := func(remoteAddr string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("POST", "/unary", bytes.NewBufferString(`{"value":1}`))
+			req.RemoteAddr = remoteAddr
+			// untrusted proxies cannot pick the client ip
+			req.Header.Set("X-Forwarded-For", "9.9.9.9")
+			w := httptest.NewRecorder()
+			hps.Handler().ServeHTTP(w, req)
+			return w
+		}
+		if w := call("1.1.1.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusOK, w.Code)
+		}
+		w := call("1.1.1.1:1001")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusTooManyRequests, w.Code)
+		}
+		if w.Header().Get("Retry-After") != "2" {
+			t.Fatalf("Expected Retry-After 2, got %q\n", w.Header().Get("Retry-After"))
+		}
+		if w := call("2.2.2.2:1000"); w.Code != http.StatusOK {
+			t.Fatalf("Expected another ip not to be limited, got %d\n", w.Code)
+		}
+	})
+
+	t.Run("caps concurrent streams per caller", func(t *testing.T) {
+		limiter, _ := NewRateLimiter(RateLimitConfig{MaxStreams: 1})
+		hps := NewHttpProxyServer("localhost:0", WithRateLimits(limiter))
+		started, finish := make(chan struct{}), make(chan struct{})
+		app := gin.New()
+		app.GET("/stream", hps.rateLimitGuard("/pkg.S/Stream", true), func(c *gin.Context) {
+			close(started)
+			<-finish
+		})
+		get := func() *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("GET", "/stream", nil)
+			req.RemoteAddr = "1.1.1.1:1000"
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, req)
+			return w
+		}
+
+		done := make(chan struct{})
+		go func() {
+			get()
+			close(done)
+		}()
+		<-started
+		w := get()
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusTooManyRequests, w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Fatalf("Expected a Retry-After header\n")
+		}
+		close(finish)
+		<-done
+		if ok, release := limiter.AcquireStream("ip:1.1.1.1"); !ok {
+			t.Fatalf("Expected the ended stream to be released\n")
+		} else {
+			release()
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"github.com/TylerJGabb/grpc-http-proxy/internal/replay"
+)
+
+// ReplayConfig describes the capture files a replay server answers from, see NewReplayServer
+type ReplayConfig = replay.Config
+
+// ReplayServer answers grpc calls from capture files, see WithReplay
+type ReplayServer = replay.Server
+
+// NewReplayServer loads the capture files of cfg, see WithCapture
+func NewReplayServer(cfg ReplayConfig) (*ReplayServer, error) {
+	return replay.New(cfg)
+}
+
+// WithReplay answers every call from the captures of r instead of the backends, which are
+// never dialed, e.g. to work without running them. Unary calls are answered with the
+// response or status of a recorded call of the method whose request matches, fields that
+// were redacted matching any value, and calls that match nothing fail with NOT_FOUND.
+// Streams send the recorded messages with their original or scaled timing, then end with
+// the recorded status, from which their close code follows. Streams their client ended
+// stay open until it leaves. Only the last of WithReplay and WithMock applies. The replay
+// server is stopped by Shutdown
+func WithReplay(
+	r *ReplayServer,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.stub = r
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+)
+
+const replayedCalls = `{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","request":{"value":1},"response":{"value":10},"status":{"code":"OK"}}
+{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","request":{"value":1},"response":{"value":11},"status":{"code":"OK"}}
+{"method":"/tgsbpb.TylerSandboxService/UnaryCallInt","request":{"value":"[REDACTED]"},"status":{"code":"PermissionDenied","message":"not yours"}}
+{"method":"/tgsbpb.TylerSandboxService/ServerStreamInt","stream":true,"request":{"value":2},"messages":[{"offsetMs":40,"message":{"value":1}},{"offsetMs":80,"message":{"value":2}}],"status":{"code":"FailedPrecondition","message":"counted enough"}}
+{"method":"/unknown.Service/Method","request":{},"status":{"code":"OK"}}
+`
+
+func Test_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	if err := os.WriteFile(path, []byte(replayedCalls), 0o600); err != nil {
+		t.Fatalf("did not expect error writing capture: %v\n", err)
+	}
+	speed := 2.0
+	replay, err := NewReplayServer(ReplayConfig{Files: []string{path}, Speed: &speed})
+	if err != nil {
+		t.Fatalf("did not expect error creating replay server: %v\n", err)
+	}
+	// the backend address is never dialed
+	hps := NewHttpProxyServer("unreachable:1", WithReplay(replay))
+	defer hps.Shutdown(context.Background())
+	conn, err := hps.ClientConn()
+	if err != nil {
+		t.Fatalf("did not expect error connecting: %v\n", err)
+	}
+	client := tgsbpb.NewTylerSandboxServiceClient(conn)
+	if err := RegisterUnary(hps, "/unary", client.UnaryCallInt); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	if err := RegisterServerStream(hps, "/stream", client.ServerStreamInt, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+		WithStreamTransport(TransportServerSentEvents)); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	s := httptest.NewServer(hps.Handler())
+	defer s.Close()
+
+	post := func(body string) (int, string) {
+		resp, err := http.Post(s.URL+"/unary", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("did not expect error posting: %v\n", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	t.Run("answers unary calls from the recordings of their request in turn", func(t *testing.T) {
+		for _, expected := range []string{`{"value":10}`, `{"value":11}`, `{"value":10}`} {
+			if code, body := post(`{"value":1}`); code != http.StatusOK || body != expected {
+				t.Fatalf("Expected 200 %s, got %d %s\n", expected, code, body)
+			}
+		}
+	})
+
+	t.Run("matches redacted fields to any value and replays errors", func(t *testing.T) {
+		if code, body := post(`{"value":5}`); code != http.StatusForbidden || !strings.Contains(body, "not yours") {
+			t.Fatalf("Expected 403 not yours, got %d %s\n", code, body)
+		}
+	})
+
+	t.Run("replays streams with scaled timing and their final status", func(t *testing.T) {
+		start := time.Now()
+		resp, err := http.Get(s.URL + "/stream?value=2")
+		if err != nil {
+			t.Fatalf("did not expect error opening stream: %v\n", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		expected := "event: message\ndata: {\"value\":1}\n\nevent: message\ndata: {\"value\":2}\n\nevent: error\ndata: counted enough\n\n"
+		if string(body) != expected {
+			t.Fatalf("Expected %q, got %q\n", expected, body)
+		}
+		if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+			t.Fatalf("Expected the messages to be sent over 40ms, took %v\n", elapsed)
+		}
+	})
+
+	t.Run("refuses streams that were not recorded", func(t *testing.T) {
+		resp, err := http.Get(s.URL + "/stream?value=3")
+		if err != nil {
+			t.Fatalf("did not expect error opening stream: %v\n", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(body), "no recorded call") {
+			t.Fatalf("Expected the stream to fail with NOT_FOUND, got %s\n", body)
+		}
+	})
+}
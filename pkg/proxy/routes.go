@@ -0,0 +1,416 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/auth"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/codec"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/logging"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/retry"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/TylerJGabb/grpc-http-proxy/internal/unary"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Codec translates between proto messages and http payloads, see JSONCodec and ProtoCodec
+type Codec = codec.Codec
+
+// JSONCodec encodes messages with protojson, it is used when no codec is configured
+type JSONCodec = codec.JSON
+
+// ProtoCodec encodes messages in the protobuf wire format
+type ProtoCodec = codec.Proto
+
+// RetryPolicy describes how unary calls are retried or hedged, see WithRetryPolicy
+type RetryPolicy = retry.Policy
+
+// GrpcClientStream is satisfied by every generated server streaming client
+type GrpcClientStream = serverstream.GrpcClientStream
+
+// StreamTransport selects how server streams are delivered to http clients
+type StreamTransport int
+
+const (
+	// TransportWebSocket upgrades the request and sends every message as a websocket message
+	TransportWebSocket StreamTransport = iota
+	// TransportServerSentEvents sends every message as a server-sent event
+	TransportServerSentEvents
+)
+
+type routeConfig struct {
+	methodName  string
+	httpMethod  string
+	codec       codec.Codec
+	transport   StreamTransport
+	retryPolicy *retry.Policy
+	backend     string
+	group       string
+}
+
+type RouteOptFunc func(*routeConfig)
+
+// WithMethodName sets the fully qualified grpc method of the route, e.g. /pkg.Service/Method.
+// It is only needed when the method cannot be resolved from the registered call function,
+// e.g. when it is a closure rather than a method of a generated client
+func WithMethodName(
+	fullMethodName string,
+) RouteOptFunc {
+	return func(rc *routeConfig) {
+		rc.methodName = fullMethodName
+	}
+}
+
+// WithHTTPMethod sets the http method of a unary route, POST is used by default
+func WithHTTPMethod(
+	httpMethod string,
+) RouteOptFunc {
+	return func(rc *routeConfig) {
+		rc.httpMethod = httpMethod
+	}
+}
+
+// WithCodec sets the codec used for the payloads of the route, protojson is used by default
+func WithCodec(
+	c Codec,
+) RouteOptFunc {
+	return func(rc *routeConfig) {
+		rc.codec = c
+	}
+}
+
+// WithStreamTransport sets how a server stream route delivers messages, websockets are used by default
+func WithStreamTransport(
+	transport StreamTransport,
+) RouteOptFunc {
+	return func(rc *routeConfig) {
+		rc.transport = transport
+	}
+}
+
+// WithRetryPolicy retries or hedges the calls of a unary route when they fail with a
+// retryable code, the number of attempts is reported in the X-Proxy-Attempts response header
+func WithRetryPolicy(
+	policy RetryPolicy,
+) RouteOptFunc {
+	return func(rc *routeConfig) {
+		rc.retryPolicy = &policy
+	}
+}
+
+// WithRouteBackend names the backend the route's calls go to, see WithBackend. It must
+// match the connection the call function's client was built from, and keys the route's
+// circuit breaker
+func WithRouteBackend(
+	name string,
+) RouteOptFunc {
+	return func(rc *routeConfig) {
+		rc.backend = name
+	}
+}
+
+// WithRouteGroup puts the route in a group, whose exposure rules apply in addition to
+// the server's, see WithGroupExposure
+func WithRouteGroup(
+	group string,
+) RouteOptFunc {
+	return func(rc *routeConfig) {
+		rc.group = group
+	}
+}
+
+func newRouteConfig(opts []RouteOptFunc) *routeConfig {
+	rc := &routeConfig{
+		backend:    DefaultBackend,
+		httpMethod: http.MethodPost,
+		codec:      codec.JSON{},
+		transport:  TransportWebSocket,
+	}
+	for _, optFunc := range opts {
+		optFunc(rc)
+	}
+	return rc
+}
+
+// RegisterUnary mounts a unary grpc method at path. callFunc is usually a method of a
+// generated client, e.g. client.UnaryCallInt, the request body is decoded into the
+// request message and the response message is written back with the route's codec.
+// Methods that are not exposed, see WithExposure, are skipped without error
+func RegisterUnary[T, U proto.Message](
+	hps *HttpProxyServer,
+	path string,
+	callFunc func(context.Context, T, ...grpc.CallOption) (U, error),
+	opts ...RouteOptFunc,
+) error {
+	cfg := newRouteConfig(opts)
+	var emptyRequest T
+	method, err := resolveMethod(callFunc, emptyRequest.ProtoReflect().Descriptor(), false, cfg.methodName)
+	if err != nil {
+		return fmt.Errorf("registering unary route %s: %w", path, err)
+	}
+	if _, ok := hps.backends[cfg.backend]; !ok {
+		return fmt.Errorf("registering unary route %s: unknown backend %q", path, cfg.backend)
+	}
+	if !hps.expose(cfg.httpMethod, path, fullMethodName(method), cfg.group) {
+		return nil
+	}
+
+	unaryOpts := []unary.OptFunc{
+		unary.WithCodec(cfg.codec),
+	}
+	if cfg.retryPolicy != nil {
+		unaryOpts = append(unaryOpts, unary.WithRetryPolicy(*cfg.retryPolicy))
+	}
+	if b := hps.breaker(cfg.backend, fullMethodName(method)); b != nil {
+		unaryOpts = append(unaryOpts, unary.WithBreaker(b))
+	}
+	// requests are validated before they are authorized, so policies see well formed requests
+	if check := hps.validateRequest(); check != nil {
+		unaryOpts = append(unaryOpts, unary.WithRequestCheck(check))
+	}
+	if check := hps.authorize(fullMethodName(method)); check != nil {
+		unaryOpts = append(unaryOpts, unary.WithRequestCheck(check))
+	}
+	if complete := hps.auditCall(fullMethodName(method), false); complete != nil {
+		hps.auditedRoutes[cfg.httpMethod+" "+path] = complete
+		unaryOpts = append(unaryOpts, unary.WithCompletion(complete))
+	}
+	if hps.captures(fullMethodName(method)) {
+		unaryOpts = append(unaryOpts, unary.WithResponseHook(hps.capture.Response), unary.WithCompletion(hps.capture.Complete))
+	}
+	for _, i := range hps.interceptors {
+		unaryOpts = append(unaryOpts, unary.WithInterceptor(i))
+	}
+	unaryOpts = append(unaryOpts, hps.unaryMetrics(path, fullMethodName(method))...)
+	hps.app.Handle(cfg.httpMethod, path, hps.routeHandlers(fullMethodName(method), false, func(c *gin.Context) {
+		unary.ProxyRequest(c, newMessage(emptyRequest), callFunc, unaryOpts...)
+	})...)
+	return nil
+}
+
+// RegisterServerStream mounts a server streaming grpc method at path. openStreamFunc is
+// usually a method of a generated client, e.g. client.ServerStreamInt, and parseRequest
+// builds the request message from the http request, see QueryParser. Methods that are
+// not exposed are skipped without error
+func RegisterServerStream[T proto.Message, U GrpcClientStream](
+	hps *HttpProxyServer,
+	path string,
+	openStreamFunc func(context.Context, T, ...grpc.CallOption) (U, error),
+	parseRequest func(c *gin.Context) (T, error),
+	opts ...RouteOptFunc,
+) error {
+	cfg := newRouteConfig(opts)
+	var emptyRequest T
+	method, err := resolveMethod(openStreamFunc, emptyRequest.ProtoReflect().Descriptor(), true, cfg.methodName)
+	if err != nil {
+		return fmt.Errorf("registering server stream route %s: %w", path, err)
+	}
+	if _, ok := hps.backends[cfg.backend]; !ok {
+		return fmt.Errorf("registering server stream route %s: unknown backend %q", path, cfg.backend)
+	}
+	if !hps.expose(http.MethodGet, path, fullMethodName(method), cfg.group) {
+		return nil
+	}
+	responseType, err := protoregistry.GlobalTypes.FindMessageByName(method.Output().FullName())
+	if err != nil {
+		return fmt.Errorf("registering server stream route %s: %w", path, err)
+	}
+
+	streamOpts := []serverstream.OptFunc{
+		serverstream.WithCodec(cfg.codec),
+		serverstream.WithGoingAway(hps.goingAway, hps.goingAwayReason()),
+		serverstream.WithCheckOrigin(hps.checkOrigin()),
+		serverstream.WithRegistry(hps.streams, fullMethodName(method)),
+	}
+	if len(hps.authenticators) > 0 {
+		// browsers passing their token as a subprotocol expect it to be selected
+		streamOpts = append(streamOpts, serverstream.WithSubprotocols(auth.BearerSubprotocol))
+	}
+	if b := hps.breaker(cfg.backend, fullMethodName(method)); b != nil {
+		streamOpts = append(streamOpts, serverstream.WithBreaker(b))
+	}
+	if check := hps.validateRequest(); check != nil {
+		streamOpts = append(streamOpts, serverstream.WithRequestCheck(check))
+	}
+	if check := hps.authorize(fullMethodName(method)); check != nil {
+		streamOpts = append(streamOpts, serverstream.WithRequestCheck(check))
+	}
+	if complete := hps.auditCall(fullMethodName(method), true); complete != nil {
+		hps.auditedRoutes[http.MethodGet+" "+path] = complete
+		streamOpts = append(streamOpts, serverstream.WithCompletion(complete))
+	}
+	if hps.captures(fullMethodName(method)) {
+		streamOpts = append(streamOpts, serverstream.WithMessageHook(hps.capture.Message), serverstream.WithCompletion(hps.capture.Complete))
+	}
+	for _, i := range hps.interceptors {
+		streamOpts = append(streamOpts, serverstream.WithInterceptor(i))
+	}
+	streamOpts = append(streamOpts, hps.streamMetrics(path, fullMethodName(method), cfg.transport)...)
+	handler := func(c *gin.Context) {
+		serverstream.ServerStreamProxy(c, openStreamFunc, parseRequest, responseType.New().Interface(), streamOpts...)
+	}
+	if cfg.transport == TransportServerSentEvents {
+		handler = func(c *gin.Context) {
+			serverstream.ServerSentEventsProxy(c, openStreamFunc, parseRequest, responseType.New().Interface(), streamOpts...)
+		}
+	}
+	hps.app.GET(path, hps.routeHandlers(fullMethodName(method), true, handler)...)
+	return nil
+}
+
+// routeHandlers prepends the handlers that apply to a single method to the route's handler
+func (hps *HttpProxyServer) routeHandlers(fullMethodName string, stream bool, handler gin.HandlerFunc) []gin.HandlerFunc {
+	handlers := []gin.HandlerFunc{logging.Method(fullMethodName)}
+	if len(hps.authenticators) > 0 {
+		handlers = append(handlers, auth.RouteGuard(fullMethodName, hps.reject()))
+	}
+	if hps.limiter != nil {
+		handlers = append(handlers, hps.rateLimitGuard(fullMethodName, stream))
+	}
+	if hps.captures(fullMethodName) {
+		handlers = append(handlers, hps.capture.Middleware(fullMethodName, stream, hps.credentialQueryParams()...))
+	}
+	return append(handlers, handler)
+}
+
+// QueryParser returns a parser that builds the request message from the url query.
+// Parameters are matched to fields by their json or proto name, repeated fields take
+// every value of the parameter and parameters that match no field are ignored.
+// Message typed fields are not supported.
+func QueryParser[T proto.Message]() func(c *gin.Context) (T, error) {
+	return func(c *gin.Context) (T, error) {
+		var emptyRequest T
+		request := newMessage(emptyRequest)
+		fields := request.ProtoReflect().Descriptor().Fields()
+		body := map[string]any{}
+		for key, values := range c.Request.URL.Query() {
+			fd := fields.ByJSONName(key)
+			if fd == nil {
+				fd = fields.ByTextName(key)
+			}
+			if fd == nil {
+				continue
+			}
+			if fd.Message() != nil {
+				return request, fmt.Errorf("query parameter %s: message fields are not supported", key)
+			}
+			converted := make([]any, 0, len(values))
+			for _, value := range values {
+				v, err := queryValue(fd, value)
+				if err != nil {
+					return request, fmt.Errorf("query parameter %s: %w", key, err)
+				}
+				converted = append(converted, v)
+			}
+			if fd.IsList() {
+				body[fd.JSONName()] = converted
+			} else {
+				body[fd.JSONName()] = converted[len(converted)-1]
+			}
+		}
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return request, err
+		}
+		if err := protojson.Unmarshal(payload, request); err != nil {
+			return request, err
+		}
+		return request, nil
+	}
+}
+
+// queryValue converts a query value into what protojson expects for the field,
+// which accepts strings for every scalar type except bool
+func queryValue(fd protoreflect.FieldDescriptor, value string) (any, error) {
+	if fd.Kind() == protoreflect.BoolKind {
+		return strconv.ParseBool(value)
+	}
+	return value, nil
+}
+
+func newMessage[T proto.Message](emptyMessage T) T {
+	return emptyMessage.ProtoReflect().New().Interface().(T)
+}
+
+// fullMethodName formats md the way grpc does, e.g. /pkg.Service/Method
+func fullMethodName(md protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+}
+
+// resolveMethod finds the descriptor of the grpc method invoked by callFunc among the
+// registered proto files. Candidates must take input and match the streaming kind, and
+// are narrowed down by the name of callFunc, which for generated clients is the method name.
+// If fullMethodName is set, it is looked up directly instead.
+func resolveMethod(
+	callFunc any,
+	input protoreflect.MessageDescriptor,
+	serverStreaming bool,
+	fullMethodName string,
+) (protoreflect.MethodDescriptor, error) {
+	matches := func(md protoreflect.MethodDescriptor) bool {
+		return md.Input().FullName() == input.FullName() &&
+			md.IsStreamingServer() == serverStreaming &&
+			!md.IsStreamingClient()
+	}
+
+	if fullMethodName != "" {
+		name := strings.ReplaceAll(strings.TrimPrefix(fullMethodName, "/"), "/", ".")
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("method %s: %w", fullMethodName, err)
+		}
+		md, ok := d.(protoreflect.MethodDescriptor)
+		if !ok || !matches(md) {
+			return nil, fmt.Errorf("%s is not a method taking %s", fullMethodName, input.FullName())
+		}
+		return md, nil
+	}
+
+	var candidates []protoreflect.MethodDescriptor
+	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				if md := methods.Get(j); matches(md) {
+					candidates = append(candidates, md)
+				}
+			}
+		}
+		return true
+	})
+
+	// method values are named like pkg.(*client).Method-fm
+	funcName := runtime.FuncForPC(reflect.ValueOf(callFunc).Pointer()).Name()
+	funcName = strings.TrimSuffix(funcName, "-fm")
+	funcName = funcName[strings.LastIndex(funcName, ".")+1:]
+	var named []protoreflect.MethodDescriptor
+	for _, md := range candidates {
+		if string(md.Name()) == funcName {
+			named = append(named, md)
+		}
+	}
+	if len(named) > 0 {
+		candidates = named
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("no registered grpc method takes %s, use WithMethodName", input.FullName())
+	case 1:
+		return candidates[0], nil
+	default:
+		return nil, fmt.Errorf("%d grpc methods take %s, use WithMethodName", len(candidates), input.FullName())
+	}
+}
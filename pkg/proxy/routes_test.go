@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func Test_RegisterUnary(t *testing.T) {
+
+	t.Run("resolves the method of a generated client", func(t *testing.T) {
+		client := tgsbpb.NewTylerSandboxServiceClient(nil)
+		md, err := resolveMethod(client.UnaryCallString, (&tgsbpb.UnaryCallStringRequest{}).ProtoReflect().Descriptor(), false, "")
+		if err != nil {
+			t.Fatalf("did not expect error resolving method: %v\n", err)
+		}
+		if fullMethodName(md) != tgsbpb.TylerSandboxService_UnaryCallString_FullMethodName {
+			t.Fatalf("expected %s, got %s\n", tgsbpb.TylerSandboxService_UnaryCallString_FullMethodName, fullMethodName(md))
+		}
+	})
+
+	t.Run("fails to register a method that cannot be resolved", func(t *testing.T) {
+		hps := NewHttpProxyServer("localhost:0")
+		callFunc := func(context.Context, *wrapperspb.StringValue, ...grpc.CallOption) (*wrapperspb.StringValue, error) {
+			return nil, nil
+		}
+		if err := RegisterUnary(hps, "/test", callFunc); err == nil {
+			t.Fatalf("expected error registering unresolvable method, got nil\n")
+		}
+	})
+
+	t.Run("proxies with the configured codec", func(t *testing.T) {
+		hps := NewHttpProxyServer("localhost:0")
+		callFunc := func(_ context.Context, req *tgsbpb.UnaryCallIntRequest, _ ...grpc.CallOption) (*tgsbpb.UnaryCallIntResponse, error) {
+			return &tgsbpb.UnaryCallIntResponse{Value: req.Value}, nil
+		}
+		err := RegisterUnary(hps, "/test", callFunc, WithCodec(ProtoCodec{}))
+		if err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+
+		payload, _ := proto.Marshal(&tgsbpb.UnaryCallIntRequest{Value: 42})
+		req, _ := http.NewRequest("POST", "/test", bytes.NewBuffer(payload))
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusOK, w.Code)
+		}
+		expectedContentType := ProtoCodec{}.ContentType()
+		if w.Header().Get("Content-Type") != expectedContentType {
+			t.Fatalf("Expected content type %s, got %s\n", expectedContentType, w.Header().Get("Content-Type"))
+		}
+		received := &tgsbpb.UnaryCallIntResponse{}
+		if err := proto.Unmarshal(w.Body.Bytes(), received); err != nil {
+			t.Fatalf("failed to unmarshal response: %v\n", err)
+		}
+		if received.Value != 42 {
+			t.Fatalf("Expected response value %d, got %d\n", 42, received.Value)
+		}
+	})
+}
+
+func Test_QueryParser(t *testing.T) {
+
+	t.Run("parses fields by json and proto name", func(t *testing.T) {
+		parse := QueryParser[*tgsbpb.ServerStreamIntRequest]()
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/test?value=7&send_period_seconds=2&closeAtNthResponse=3&unknown=x", nil)
+		request, err := parse(c)
+		if err != nil {
+			t.Fatalf("did not expect error parsing query: %v\n", err)
+		}
+		expected := &tgsbpb.ServerStreamIntRequest{Value: 7, SendPeriodSeconds: 2, CloseAtNthResponse: 3}
+		if !proto.Equal(request, expected) {
+			t.Fatalf("expected %v, got %v\n", expected, request)
+		}
+	})
+
+	t.Run("fails on malformed values", func(t *testing.T) {
+		parse := QueryParser[*tgsbpb.ServerStreamIntRequest]()
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/test?value=abc", nil)
+		if _, err := parse(c); err == nil {
+			t.Fatalf("expected error parsing malformed value, got nil\n")
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingFlushTimeout bounds the flush of the spans of an owned provider on Shutdown
+const tracingFlushTimeout = 5 * time.Second
+
+// TracingConfig is the file based configuration of tracing
+type TracingConfig = tracing.Config
+
+// NewTracerProvider returns a provider exporting spans as described by cfg,
+// the stdout exporter writes to os.Stdout
+func NewTracerProvider(cfg TracingConfig) (*sdktrace.TracerProvider, error) {
+	return tracing.NewProvider(cfg, os.Stdout)
+}
+
+// NewInMemoryTracerProvider returns a provider keeping every span in the returned
+// exporter as soon as it ends, which is meant for tests
+func NewInMemoryTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+// WithTracing traces every proxied call with provider: a server span for the http request
+// or websocket session, continuing the trace of the caller's traceparent header, and a
+// client span for the grpc call, whose traceparent is propagated in the outgoing metadata.
+// Messages of streams are recorded as events of the client span. Spans of grpc calls are
+// only recorded for connections made by ClientConn and BackendConn. The provider belongs
+// to the caller and is left running by Shutdown, only the provider of a config's tracing
+// section is shut down with the proxy
+func WithTracing(
+	provider trace.TracerProvider,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.tracerProvider, h.ownsTracerProvider = provider, false
+	}
+}
+
+// withOwnedTracing is WithTracing for a provider the proxy created, which Shutdown shuts down
+func withOwnedTracing(
+	provider trace.TracerProvider,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.tracerProvider, h.ownsTracerProvider = provider, true
+	}
+}
+
+// WithMessageEventSampleRate sets the fraction of stream messages recorded as span events,
+// every message is recorded by default and none with a rate of 0
+func WithMessageEventSampleRate(
+	rate float64,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.messageEventSampleRate = rate
+	}
+}
+
+// shutdownTracing flushes and shuts down the provider if the proxy created it. It gets a
+// context of its own, the one of Shutdown may be done by the time the requests have ended
+func (hps *HttpProxyServer) shutdownTracing() error {
+	provider, ok := hps.tracerProvider.(interface{ Shutdown(context.Context) error })
+	if !ok || !hps.ownsTracerProvider {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+	return provider.Shutdown(ctx)
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type traceparentEchoServer struct {
+	tgsbpb.UnimplementedTylerSandboxServiceServer
+}
+
+// UnaryCallString answers with the traceparent metadata the call arrived with
+func (traceparentEchoServer) UnaryCallString(ctx context.Context, _ *tgsbpb.UnaryCallStringRequest) (*tgsbpb.UnaryCallStringResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &tgsbpb.UnaryCallStringResponse{Value: append(md.Get("traceparent"), "")[0]}, nil
+}
+
+// ServerStreamInt sends as many messages as the requested value
+func (traceparentEchoServer) ServerStreamInt(req *tgsbpb.ServerStreamIntRequest, stream tgsbpb.TylerSandboxService_ServerStreamIntServer) error {
+	for i := int32(0); i < req.Value; i++ {
+		if err := stream.Send(&tgsbpb.ServerStreamIntResponse{Value: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startTraceparentEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v\n", err)
+	}
+	server := grpc.NewServer()
+	tgsbpb.RegisterTylerSandboxServiceServer(server, traceparentEchoServer{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+// keepingExporter keeps its spans once shut down, unlike the in memory exporter it wraps
+type keepingExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (keepingExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func Test_Tracing(t *testing.T) {
+	target := startTraceparentEchoServer(t)
+
+	newTracedProxy := func(t *testing.T, opts ...OptFunc) (*HttpProxyServer, *tracetest.InMemoryExporter) {
+		provider, exporter := NewInMemoryTracerProvider()
+		hps := NewHttpProxyServer(target, append([]OptFunc{WithTracing(provider)}, opts...)...)
+		t.Cleanup(func() { hps.Shutdown(context.Background()) })
+		conn, err := hps.ClientConn()
+		if err != nil {
+			t.Fatalf("did not expect error connecting: %v\n", err)
+		}
+		client := tgsbpb.NewTylerSandboxServiceClient(conn)
+		if err := RegisterUnary(hps, "/unary", client.UnaryCallString); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+		if err := RegisterServerStream(hps, "/stream", client.ServerStreamInt, QueryParser[*tgsbpb.ServerStreamIntRequest](),
+			WithStreamTransport(TransportServerSentEvents)); err != nil {
+			t.Fatalf("did not expect error registering route: %v\n", err)
+		}
+		return hps, exporter
+	}
+
+	t.Run("continues the caller's trace and propagates it to the backend", func(t *testing.T) {
+		hps, exporter := newTracedProxy(t)
+		const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		req, _ := http.NewRequest("POST", "/unary", bytes.NewBufferString(`{"value":"x"}`))
+		req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s\n", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		spans := exporter.GetSpans()
+		server, ok := findSpan(spans, "POST /unary")
+		if !ok {
+			t.Fatalf("Expected a server span, got %v\n", spans)
+		}
+		client, ok := findSpan(spans, "tgsbpb.TylerSandboxService/UnaryCallString")
+		if !ok {
+			t.Fatalf("Expected a client span, got %v\n", spans)
+		}
+		if server.SpanKind != trace.SpanKindServer || client.SpanKind != trace.SpanKindClient {
+			t.Fatalf("Expected server and client spans, got %v and %v\n", server.SpanKind, client.SpanKind)
+		}
+		if server.SpanContext.TraceID().String() != traceID || server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+			t.Fatalf("Expected the server span to continue the caller's trace, got %v\n", server.SpanContext)
+		}
+		if client.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Fatalf("Expected the client span to be a child of the server span\n")
+		}
+		expected := "00-" + traceID + "-" + client.SpanContext.SpanID().String() + "-01"
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("Expected the backend to receive traceparent %s, got %s\n", expected, w.Body.String())
+		}
+	})
+
+	t.Run("records stream messages as events of the client span", func(t *testing.T) {
+		hps, exporter := newTracedProxy(t)
+		req, _ := http.NewRequest("GET", "/stream?value=3", nil)
+		hps.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+		client, ok := findSpan(exporter.GetSpans(), "tgsbpb.TylerSandboxService/ServerStreamInt")
+		if !ok {
+			t.Fatalf("Expected a client span, got %v\n", exporter.GetSpans())
+		}
+		// the request is sent, then three responses are received
+		if len(client.Events) != 4 {
+			t.Fatalf("Expected 4 message events, got %d\n", len(client.Events))
+		}
+	})
+
+	t.Run("samples message events", func(t *testing.T) {
+		hps, exporter := newTracedProxy(t, WithMessageEventSampleRate(0))
+		req, _ := http.NewRequest("GET", "/stream?value=3", nil)
+		hps.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+		client, ok := findSpan(exporter.GetSpans(), "tgsbpb.TylerSandboxService/ServerStreamInt")
+		if !ok {
+			t.Fatalf("Expected a client span, got %v\n", exporter.GetSpans())
+		}
+		if len(client.Events) != 0 {
+			t.Fatalf("Expected no message events, got %d\n", len(client.Events))
+		}
+	})
+
+	t.Run("leaves the caller's provider running on shutdown", func(t *testing.T) {
+		provider, exporter := NewInMemoryTracerProvider()
+		hps := NewHttpProxyServer(target, WithTracing(provider))
+		if err := hps.Shutdown(context.Background()); err != nil {
+			t.Fatalf("did not expect error shutting down: %v\n", err)
+		}
+		_, span := provider.Tracer("app").Start(context.Background(), "after-shutdown")
+		span.End()
+		if _, ok := findSpan(exporter.GetSpans(), "after-shutdown"); !ok {
+			t.Fatalf("Expected the provider to keep recording, got %v\n", exporter.GetSpans())
+		}
+	})
+
+	t.Run("flushes and shuts down its own provider even once ctx is done", func(t *testing.T) {
+		exporter := keepingExporter{tracetest.NewInMemoryExporter()}
+		provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+		hps := NewHttpProxyServer(target, withOwnedTracing(provider))
+		_, span := provider.Tracer("app").Start(context.Background(), "before-shutdown")
+		span.End()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		hps.Shutdown(ctx)
+		if _, ok := findSpan(exporter.GetSpans(), "before-shutdown"); !ok {
+			t.Fatalf("Expected the pending span to be flushed, got %v\n", exporter.GetSpans())
+		}
+		_, span = provider.Tracer("app").Start(context.Background(), "after-shutdown")
+		span.End()
+		if _, ok := findSpan(exporter.GetSpans(), "after-shutdown"); ok {
+			t.Fatalf("Expected the provider to be shut down\n")
+		}
+	})
+}
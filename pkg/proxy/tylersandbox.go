@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"errors"
+
+	"github.com/TylerJGabb/grpc-http-proxy/internal/serverstream"
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+)
+
+// RegisterTylerSandboxRoutes mounts the unary and server streaming methods of the
+// TylerSandboxService, as served by the example server
+func RegisterTylerSandboxRoutes(hps *HttpProxyServer) error {
+	conn, err := hps.ClientConn()
+	if err != nil {
+		return err
+	}
+	client := tgsbpb.NewTylerSandboxServiceClient(conn)
+	return errors.Join(
+		RegisterUnary(hps, "/unarycallint", client.UnaryCallInt),
+		RegisterUnary(hps, "/unarycallstring", client.UnaryCallString),
+		RegisterServerStream(hps, "/serverstreamstring", client.ServerStreamString, serverstream.ParseStringStreamRequest),
+		RegisterServerStream(hps, "/serverstreamint", client.ServerStreamInt, serverstream.ParseIntStreamRequest),
+	)
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"github.com/TylerJGabb/grpc-http-proxy/internal/validate"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
+)
+
+// ValidationConfig describes how request messages are validated, see WithValidation
+type ValidationConfig struct {
+	// Strict also rejects fields unknown to the request's descriptor
+	Strict bool `json:"strict"`
+}
+
+// WithValidation validates every request against the constraints declared with
+// protoc-gen-validate field options before it reaches the backend. Invalid requests fail
+// with INVALID_ARGUMENT and a google.rpc.BadRequest listing every violation, answered with
+// 400 and the status as json, or close code 1008 for websockets. The request of every unary
+// and server streaming route is validated, the proxy has no client or bidi streaming routes
+func WithValidation(
+	cfg ValidationConfig,
+) OptFunc {
+	return func(h *HttpProxyServer) {
+		h.validation = &cfg
+	}
+}
+
+// validateRequest returns the request check of the routes, or nil when validation is off
+func (hps *HttpProxyServer) validateRequest() func(c *gin.Context, request proto.Message) error {
+	if hps.validation == nil {
+		return nil
+	}
+	strict := hps.validation.Strict
+	return func(_ *gin.Context, request proto.Message) error {
+		return validate.Message(request, strict)
+	}
+}
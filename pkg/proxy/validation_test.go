@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TylerJGabb/grpc-http-proxy/pkg/tgsbpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_Validation(t *testing.T) {
+	hps := NewHttpProxyServer("localhost:0", WithValidation(ValidationConfig{Strict: true}))
+	if err := RegisterUnary(hps, "/unary", echoIntCallFunc, WithCodec(ProtoCodec{})); err != nil {
+		t.Fatalf("did not expect error registering route: %v\n", err)
+	}
+	call := func(body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/unary", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		hps.Handler().ServeHTTP(w, req)
+		return w
+	}
+	valid, _ := proto.Marshal(&tgsbpb.UnaryCallIntRequest{Value: 1})
+
+	t.Run("passes valid requests", func(t *testing.T) {
+		if w := call(valid); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("answers unknown fields with a bad request", func(t *testing.T) {
+		// field 15, varint 1, which UnaryCallIntRequest does not declare
+		w := call(append(valid, 0x78, 0x01))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status code %d, got %d\n", http.StatusBadRequest, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "type.googleapis.com/google.rpc.BadRequest") {
+			t.Fatalf("Expected a google.rpc.BadRequest detail, got %s\n", w.Body.String())
+		}
+	})
+}